@@ -1,26 +1,48 @@
 package aws
 
 import (
+	"bytes"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/elasticsearchservice"
+	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/rds"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/infinityworks/fk-infra/util"
+	"io/ioutil"
 	"log"
+	"strings"
+	"time"
 )
 
-func NewSession(region string) *session.Session {
-	newSession, e := session.NewSession(&aws.Config{
+const (
+	secretsManagerCurrentStage  = "AWSCURRENT"
+	secretsManagerPendingStage  = "AWSPENDING"
+	databaseModificationTimeout = 10 * time.Minute
+)
+
+func NewSession(region string) (*session.Session, error) {
+	newSession, err := session.NewSession(&aws.Config{
 		Region: util.String(region),
 	})
-	util.CheckError(e)
-	return newSession
+	return newSession, util.WrapError(util.CloudError, err, "unable to create AWS session for %s", region)
 }
 
-func CreateBucket(bucketName, region string) string {
-	s3api := s3.New(NewSession(region))
+func CreateBucket(bucketName, region string) (string, error) {
+	awsSession, err := NewSession(region)
+	if err != nil {
+		return "", err
+	}
+	s3api := s3.New(awsSession)
 	bucketsOutput, err := s3api.ListBuckets(&s3.ListBucketsInput{})
-	util.CheckError(err)
+	if err != nil {
+		return "", util.WrapError(util.CloudError, err, "unable to list buckets")
+	}
 
 	exists := false
 	for _, bucket := range bucketsOutput.Buckets {
@@ -35,16 +57,20 @@ func CreateBucket(bucketName, region string) string {
 			Bucket:                    &bucketName,
 			CreateBucketConfiguration: bucketLocationConfiguration(region),
 		})
-		util.CheckError(err)
+		if err != nil {
+			return "", util.WrapError(util.CloudError, err, "unable to create bucket %s", bucketName)
+		}
 		_, err = s3api.PutBucketVersioning(&s3.PutBucketVersioningInput{
 			Bucket: &bucketName,
 			VersioningConfiguration: &s3.VersioningConfiguration{
 				Status: util.String(s3.BucketVersioningStatusEnabled),
 			},
 		})
-		util.CheckError(err)
+		if err != nil {
+			return "", util.WrapError(util.CloudError, err, "unable to enable versioning on bucket %s", bucketName)
+		}
 	}
-	return bucketName
+	return bucketName, nil
 }
 
 func bucketLocationConfiguration(region string) *s3.CreateBucketConfiguration {
@@ -56,8 +82,12 @@ func bucketLocationConfiguration(region string) *s3.CreateBucketConfiguration {
 	}
 }
 
-func CreateKmsKey(keyName, region string) string {
-	kmsApi := kms.New(NewSession(region))
+func CreateKmsKey(keyName, region string) (string, error) {
+	awsSession, err := NewSession(region)
+	if err != nil {
+		return "", err
+	}
+	kmsApi := kms.New(awsSession)
 	keyAlias := util.String("alias/environment-key-" + keyName)
 
 	if key, err := kmsApi.DescribeKey(&kms.DescribeKeyInput{
@@ -66,15 +96,410 @@ func CreateKmsKey(keyName, region string) string {
 		output, err := kmsApi.CreateKey(&kms.CreateKeyInput{
 			Description: util.String("Used to encrypt and decrypt infrastructure secrets for safe storage"),
 		})
-		util.CheckError(err)
+		if err != nil {
+			return "", util.WrapError(util.CloudError, err, "unable to create KMS key")
+		}
 		_, err = kmsApi.CreateAlias(&kms.CreateAliasInput{
 			AliasName:   keyAlias,
 			TargetKeyId: output.KeyMetadata.Arn,
 		})
-		util.CheckError(err)
-		return *keyAlias
+		if err != nil {
+			return "", util.WrapError(util.CloudError, err, "unable to create KMS alias %s", *keyAlias)
+		}
+		return *keyAlias, nil
 	} else if *key.KeyMetadata.KeyState != kms.KeyStateEnabled {
-		log.Panic("KMS key already exists but is not enabled")
+		return "", util.NewError(util.CloudError, "KMS key %s already exists but is not enabled", *keyAlias)
+	}
+	return *keyAlias, nil
+}
+
+func DeleteBucket(bucketName, region string) error {
+	awsSession, err := NewSession(region)
+	if err != nil {
+		return err
+	}
+	s3api := s3.New(awsSession)
+
+	// The bucket is versioned so every version and delete marker has to go before the bucket can be removed
+	var deleteErr error
+	err = s3api.ListObjectVersionsPages(&s3.ListObjectVersionsInput{
+		Bucket: &bucketName,
+	}, func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+		var objects []*s3.ObjectIdentifier
+		for _, version := range page.Versions {
+			objects = append(objects, &s3.ObjectIdentifier{Key: version.Key, VersionId: version.VersionId})
+		}
+		for _, deleteMarker := range page.DeleteMarkers {
+			objects = append(objects, &s3.ObjectIdentifier{Key: deleteMarker.Key, VersionId: deleteMarker.VersionId})
+		}
+		if len(objects) > 0 {
+			_, deleteErr = s3api.DeleteObjects(&s3.DeleteObjectsInput{
+				Bucket: &bucketName,
+				Delete: &s3.Delete{Objects: objects},
+			})
+		}
+		return deleteErr == nil
+	})
+	if err == nil {
+		err = deleteErr
+	}
+	if err != nil {
+		return util.WrapError(util.CloudError, err, "unable to empty bucket %s", bucketName)
+	}
+
+	_, err = s3api.DeleteBucket(&s3.DeleteBucketInput{
+		Bucket: &bucketName,
+	})
+	if err != nil {
+		return util.WrapError(util.CloudError, err, "unable to delete bucket %s", bucketName)
+	}
+	log.Printf("Deleted bucket %s", bucketName)
+	return nil
+}
+
+func DeleteKmsKey(keyAlias, region string) error {
+	awsSession, err := NewSession(region)
+	if err != nil {
+		return err
+	}
+	kmsApi := kms.New(awsSession)
+
+	key, err := kmsApi.DescribeKey(&kms.DescribeKeyInput{
+		KeyId: &keyAlias,
+	})
+	if err != nil {
+		return util.WrapError(util.CloudError, err, "unable to find KMS key %s", keyAlias)
+	}
+
+	_, err = kmsApi.DeleteAlias(&kms.DeleteAliasInput{
+		AliasName: &keyAlias,
+	})
+	if err != nil {
+		return util.WrapError(util.CloudError, err, "unable to delete KMS alias %s", keyAlias)
+	}
+
+	// KMS keys can't be deleted immediately, the shortest waiting period AWS allows is seven days
+	output, err := kmsApi.ScheduleKeyDeletion(&kms.ScheduleKeyDeletionInput{
+		KeyId:               key.KeyMetadata.Arn,
+		PendingWindowInDays: aws.Int64(7),
+	})
+	if err != nil {
+		return util.WrapError(util.CloudError, err, "unable to schedule deletion of KMS key %s", keyAlias)
+	}
+	log.Printf("Scheduled deletion of KMS key %s on %s", keyAlias, output.DeletionDate)
+	return nil
+}
+
+// FetchObject returns the content of an object in a bucket and whether it exists.
+func FetchObject(bucketName, key, region string) ([]byte, bool, error) {
+	awsSession, err := NewSession(region)
+	if err != nil {
+		return nil, false, err
+	}
+	output, err := s3.New(awsSession).GetObject(&s3.GetObjectInput{
+		Bucket: &bucketName,
+		Key:    &key,
+	})
+	if errWithCode, ok := err.(awserr.Error); ok && s3.ErrCodeNoSuchKey == errWithCode.Code() {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, util.WrapError(util.CloudError, err, "unable to fetch s3://%s/%s", bucketName, key)
+	}
+	defer output.Body.Close()
+	content, err := ioutil.ReadAll(output.Body)
+	return content, true, util.WrapError(util.CloudError, err, "unable to fetch s3://%s/%s", bucketName, key)
+}
+
+func PutObject(bucketName, key, region string, content []byte) error {
+	awsSession, err := NewSession(region)
+	if err != nil {
+		return err
+	}
+	_, err = s3.New(awsSession).PutObject(&s3.PutObjectInput{
+		Bucket: &bucketName,
+		Key:    &key,
+		Body:   bytes.NewReader(content),
+	})
+	return util.WrapError(util.CloudError, err, "unable to write s3://%s/%s", bucketName, key)
+}
+
+// FetchSecret returns the current value of a Secrets Manager secret and whether it exists.
+func FetchSecret(secretName, region string) (string, bool, error) {
+	awsSession, err := NewSession(region)
+	if err != nil {
+		return "", false, err
+	}
+	output, err := secretsmanager.New(awsSession).GetSecretValue(&secretsmanager.GetSecretValueInput{
+		SecretId: &secretName,
+	})
+	if errWithCode, ok := err.(awserr.Error); ok && secretsmanager.ErrCodeResourceNotFoundException == errWithCode.Code() {
+		return "", false, nil
+	}
+	// Secrets scheduled for deletion can't be read, or replaced until they are gone
+	if errWithCode, ok := err.(awserr.Error); ok && secretsmanager.ErrCodeInvalidRequestException == errWithCode.Code() {
+		secret, describeErr := secretsmanager.New(awsSession).DescribeSecret(&secretsmanager.DescribeSecretInput{
+			SecretId: &secretName,
+		})
+		if describeErr == nil && secret.DeletedDate != nil {
+			return "", false, util.NewError(util.ConfigError, "secret %s is scheduled for deletion, restore it with aws secretsmanager restore-secret or remove it with aws secretsmanager delete-secret --force-delete-without-recovery", secretName)
+		}
+	}
+	if err != nil {
+		return "", false, util.WrapError(util.CloudError, err, "unable to fetch secret %s", secretName)
+	}
+	return aws.StringValue(output.SecretString), true, nil
+}
+
+// ListSecrets returns the names of the secrets starting with prefix, leaving out those already scheduled for
+// deletion.
+func ListSecrets(prefix, region string) ([]string, error) {
+	awsSession, err := NewSession(region)
+	if err != nil {
+		return nil, err
+	}
+	var secretNames []string
+	err = secretsmanager.New(awsSession).ListSecretsPages(&secretsmanager.ListSecretsInput{}, func(page *secretsmanager.ListSecretsOutput, lastPage bool) bool {
+		for _, secret := range page.SecretList {
+			if strings.HasPrefix(aws.StringValue(secret.Name), prefix) && secret.DeletedDate == nil {
+				secretNames = append(secretNames, aws.StringValue(secret.Name))
+			}
+		}
+		return true
+	})
+	return secretNames, util.WrapError(util.CloudError, err, "unable to list secrets")
+}
+
+// DeleteSecret schedules deletion of a secret, it can be restored during the shortest recovery window Secrets
+// Manager allows.
+func DeleteSecret(secretName, region string) error {
+	awsSession, err := NewSession(region)
+	if err != nil {
+		return err
+	}
+	output, err := secretsmanager.New(awsSession).DeleteSecret(&secretsmanager.DeleteSecretInput{
+		SecretId:             &secretName,
+		RecoveryWindowInDays: aws.Int64(7),
+	})
+	if err != nil {
+		return util.WrapError(util.CloudError, err, "unable to schedule deletion of secret %s", secretName)
+	}
+	log.Printf("Scheduled deletion of secret %s on %s", secretName, output.DeletionDate)
+	return nil
+}
+
+// CreateSecret stores a new Secrets Manager secret encrypted with the given KMS key.
+func CreateSecret(secretName, description, kmsKeyId, value, region string) error {
+	awsSession, err := NewSession(region)
+	if err != nil {
+		return err
+	}
+	_, err = secretsmanager.New(awsSession).CreateSecret(&secretsmanager.CreateSecretInput{
+		Name:         &secretName,
+		Description:  &description,
+		KmsKeyId:     &kmsKeyId,
+		SecretString: &value,
+	})
+	return util.WrapError(util.CloudError, err, "unable to create secret %s", secretName)
+}
+
+// PutPendingSecretValue adds a version of a secret labelled AWSPENDING, the current version stays in use until
+// the new one is promoted.
+func PutPendingSecretValue(secretName, value, region string) (string, error) {
+	awsSession, err := NewSession(region)
+	if err != nil {
+		return "", err
+	}
+	output, err := secretsmanager.New(awsSession).PutSecretValue(&secretsmanager.PutSecretValueInput{
+		SecretId:      &secretName,
+		SecretString:  &value,
+		VersionStages: []*string{util.String(secretsManagerPendingStage)},
+	})
+	if err != nil {
+		return "", util.WrapError(util.CloudError, err, "unable to add a version to secret %s", secretName)
+	}
+	return aws.StringValue(output.VersionId), nil
+}
+
+// PromoteSecretVersion makes a pending version of a secret the current one, the version it replaces stays
+// available as AWSPREVIOUS.
+func PromoteSecretVersion(secretName, versionId, region string) error {
+	awsSession, err := NewSession(region)
+	if err != nil {
+		return err
+	}
+	secretsManager := secretsmanager.New(awsSession)
+	secret, err := secretsManager.DescribeSecret(&secretsmanager.DescribeSecretInput{
+		SecretId: &secretName,
+	})
+	if err != nil {
+		return util.WrapError(util.CloudError, err, "unable to describe secret %s", secretName)
+	}
+	var currentVersionId *string
+	for candidateVersionId, stages := range secret.VersionIdsToStages {
+		for _, stage := range stages {
+			if aws.StringValue(stage) == secretsManagerCurrentStage {
+				currentVersionId = util.String(candidateVersionId)
+			}
+		}
+	}
+
+	if _, err := secretsManager.UpdateSecretVersionStage(&secretsmanager.UpdateSecretVersionStageInput{
+		SecretId:            &secretName,
+		VersionStage:        util.String(secretsManagerCurrentStage),
+		MoveToVersionId:     &versionId,
+		RemoveFromVersionId: currentVersionId,
+	}); err != nil {
+		return util.WrapError(util.CloudError, err, "unable to promote the new version of secret %s", secretName)
+	}
+	_, err = secretsManager.UpdateSecretVersionStage(&secretsmanager.UpdateSecretVersionStageInput{
+		SecretId:            &secretName,
+		VersionStage:        util.String(secretsManagerPendingStage),
+		RemoveFromVersionId: &versionId,
+	})
+	return util.WrapError(util.CloudError, err, "unable to clear the pending version of secret %s", secretName)
+}
+
+// ModifyDatabasePassword asks RDS to change the master password of an instance. Once it returns without an error
+// the new password is the one the instance ends up with, RDS applies it shortly afterwards.
+func ModifyDatabasePassword(identifier, password, region string) error {
+	awsSession, err := NewSession(region)
+	if err != nil {
+		return err
+	}
+	_, err = rds.New(awsSession).ModifyDBInstance(&rds.ModifyDBInstanceInput{
+		DBInstanceIdentifier: &identifier,
+		MasterUserPassword:   &password,
+		ApplyImmediately:     aws.Bool(true),
+	})
+	return util.WrapError(util.CloudError, err, "unable to change the password of database %s", identifier)
+}
+
+// WaitForDatabasePassword waits for RDS to finish applying a new master password, connections that are already
+// open are unaffected. Failing to look the instance up is retried until the timeout.
+func WaitForDatabasePassword(identifier, region string) error {
+	awsSession, err := NewSession(region)
+	if err != nil {
+		return err
+	}
+	rdsApi := rds.New(awsSession)
+	for start := time.Now(); time.Since(start) < databaseModificationTimeout; {
+		time.Sleep(10 * time.Second)
+		output, err := rdsApi.DescribeDBInstances(&rds.DescribeDBInstancesInput{
+			DBInstanceIdentifier: &identifier,
+		})
+		if err != nil {
+			log.Printf("Unable to look up database %s, retrying: %v", identifier, err)
+			continue
+		}
+		if len(output.DBInstances) == 0 {
+			return util.NewError(util.CloudError, "database %s disappeared while its password was changed", identifier)
+		}
+		instance := output.DBInstances[0]
+		passwordPending := instance.PendingModifiedValues != nil && instance.PendingModifiedValues.MasterUserPassword != nil
+		if aws.StringValue(instance.DBInstanceStatus) == "available" && !passwordPending {
+			return nil
+		}
+		log.Printf("Waiting for database %s to apply its new password, currently %s", identifier, aws.StringValue(instance.DBInstanceStatus))
+	}
+	return util.NewError(util.TimeoutError, "database %s did not apply its new password within %s", identifier, databaseModificationTimeout)
+}
+
+func IamRoleExists(roleName, region string) (bool, error) {
+	awsSession, err := NewSession(region)
+	if err != nil {
+		return false, err
+	}
+	_, err = iam.New(awsSession).GetRole(&iam.GetRoleInput{
+		RoleName: &roleName,
+	})
+	if errWithCode, ok := err.(awserr.Error); ok && iam.ErrCodeNoSuchEntityException == errWithCode.Code() {
+		return false, nil
+	}
+	return err == nil, util.WrapError(util.CloudError, err, "unable to look up IAM role %s", roleName)
+}
+
+func DatabaseSnapshotExists(snapshotIdentifier, region string) (bool, error) {
+	awsSession, err := NewSession(region)
+	if err != nil {
+		return false, err
+	}
+	_, err = rds.New(awsSession).DescribeDBSnapshots(&rds.DescribeDBSnapshotsInput{
+		DBSnapshotIdentifier: &snapshotIdentifier,
+	})
+	if errWithCode, ok := err.(awserr.Error); ok && rds.ErrCodeDBSnapshotNotFoundFault == errWithCode.Code() {
+		return false, nil
+	}
+	return err == nil, util.WrapError(util.CloudError, err, "unable to look up database snapshot %s", snapshotIdentifier)
+}
+
+// DatabaseInstanceState returns the RDS status of the instance, such as available or backing-up.
+func DatabaseInstanceState(identifier, region string) (string, error) {
+	awsSession, err := NewSession(region)
+	if err != nil {
+		return "", err
+	}
+	output, err := rds.New(awsSession).DescribeDBInstances(&rds.DescribeDBInstancesInput{
+		DBInstanceIdentifier: &identifier,
+	})
+	if errWithCode, ok := err.(awserr.Error); ok && rds.ErrCodeDBInstanceNotFoundFault == errWithCode.Code() {
+		return "not found", nil
+	}
+	if err != nil {
+		return "", util.WrapError(util.CloudError, err, "unable to look up database %s", identifier)
+	}
+	if len(output.DBInstances) == 0 {
+		return "not found", nil
+	}
+	return aws.StringValue(output.DBInstances[0].DBInstanceStatus), nil
+}
+
+// ElasticSearchDomainHealth returns the green, yellow or red cluster status AWS last reported for the domain,
+// or processing while a configuration change is being applied.
+func ElasticSearchDomainHealth(domainName, region string) (string, error) {
+	awsSession, err := NewSession(region)
+	if err != nil {
+		return "", err
+	}
+	output, err := elasticsearchservice.New(awsSession).DescribeElasticsearchDomain(&elasticsearchservice.DescribeElasticsearchDomainInput{
+		DomainName: &domainName,
+	})
+	if errWithCode, ok := err.(awserr.Error); ok && elasticsearchservice.ErrCodeResourceNotFoundException == errWithCode.Code() {
+		return "not found", nil
+	}
+	if err != nil {
+		return "", util.WrapError(util.CloudError, err, "unable to look up ElasticSearch domain %s", domainName)
+	}
+	if aws.BoolValue(output.DomainStatus.Processing) {
+		return "processing", nil
+	}
+
+	identity, err := sts.New(awsSession).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", util.WrapError(util.CloudError, err, "unable to look up AWS account")
+	}
+	cloudWatch := cloudwatch.New(awsSession)
+	for _, health := range []string{"red", "yellow", "green"} {
+		statistics, err := cloudWatch.GetMetricStatistics(&cloudwatch.GetMetricStatisticsInput{
+			Namespace:  util.String("AWS/ES"),
+			MetricName: util.String("ClusterStatus." + health),
+			Dimensions: []*cloudwatch.Dimension{
+				{Name: util.String("DomainName"), Value: &domainName},
+				{Name: util.String("ClientId"), Value: identity.Account},
+			},
+			StartTime:  aws.Time(time.Now().Add(-10 * time.Minute)),
+			EndTime:    aws.Time(time.Now()),
+			Period:     aws.Int64(60),
+			Statistics: []*string{util.String(cloudwatch.StatisticMaximum)},
+		})
+		if err != nil {
+			return "", util.WrapError(util.CloudError, err, "unable to look up health of ElasticSearch domain %s", domainName)
+		}
+		for _, datapoint := range statistics.Datapoints {
+			if aws.Float64Value(datapoint.Maximum) > 0 {
+				return health, nil
+			}
+		}
 	}
-	return *keyAlias
+	return "unknown", nil
 }
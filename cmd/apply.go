@@ -3,40 +3,137 @@ package cmd
 import (
 	"github.com/infinityworks/fk-infra/crypto"
 	"github.com/infinityworks/fk-infra/model"
+	"github.com/infinityworks/fk-infra/plan"
 	"github.com/infinityworks/fk-infra/templates"
 	"github.com/infinityworks/fk-infra/terraform"
 	"github.com/infinityworks/fk-infra/util"
 	"github.com/spf13/cobra"
+	"log"
 )
 
 const (
-	FlagApprove = "approve"
+	FlagApprove  = "approve"
+	FlagPlanFile = "plan-file"
 )
 
 var applyCmd = &cobra.Command{
 	Use:   "apply",
 	Short: "Initiate this directory ready for use",
 	Long:  "Creates basic config with defaults and necessary tooling ready to start creating a simple cluster and supporting infrastructure. Edit fk-infra.yml to change defaults",
-	Run: func(cmd *cobra.Command, args []string) {
-		config := model.FetchConfig()
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := model.FetchConfig()
+		if err != nil {
+			return err
+		}
 		approved, err := cmd.Flags().GetBool(FlagApprove)
-		util.CheckError(err)
+		if err != nil {
+			return err
+		}
+		planFile, err := cmd.Flags().GetString(FlagPlanFile)
+		if err != nil {
+			return err
+		}
 
-		crypto.DecryptKeys()
+		if planFile != "" {
+			return applyPlanFile(config, planFile, approved)
+		}
 
-		templates.RenderNetwork(config)
-		templates.RenderElasticSearch(config)
-		templates.RenderDatabases(config)
+		// Without approval nothing is written, not even the migrated state
+		renderTerraform := templates.RenderTerraformForPlan
+		if approved {
+			if err := terraform.MigrateLegacyState(config.Spec.ConfigBucket, config.Spec.Region); err != nil {
+				return err
+			}
+			renderTerraform = templates.RenderTerraform
+		} else if migrationPending, err := reportStateMigration(config); err != nil || migrationPending {
+			return err
+		}
 
-		terraform.PlanAndApply(approved)
+		if err := crypto.DecryptKeys(); err != nil {
+			return err
+		}
 
-		terraformOutputs := terraform.FetchTerraformOutputs()
+		if err := renderTerraform(config); err != nil {
+			return err
+		}
 
-		templates.ApplyKubernetesClusters(config, terraformOutputs, approved)
+		if err := terraform.PlanAndApply(approved); err != nil {
+			return err
+		}
+
+		terraformOutputs, err := terraform.FetchTerraformOutputs()
+		if err != nil {
+			return err
+		}
+
+		return templates.ApplyKubernetesClusters(config, terraformOutputs, approved)
 	},
 }
 
+// reportStateMigration describes the migration of terraform state an approved run would start with, terraform
+// can't plan until it has been made.
+func reportStateMigration(config *model.Config) (bool, error) {
+	stateMigration, err := terraform.PendingStateMigration(config.Spec.ConfigBucket, config.Spec.Region)
+	if err != nil || len(stateMigration) == 0 {
+		return false, err
+	}
+	log.Printf("Terraform state would be migrated before terraform runs:")
+	for _, step := range stateMigration {
+		log.Printf("  %s", step)
+	}
+	log.Printf("Terraform changes can be shown once the state has been migrated")
+	return true, nil
+}
+
+func applyPlanFile(config *model.Config, planFile string, approved bool) error {
+	summary, err := plan.ReadSummary(planFile)
+	if err != nil {
+		return err
+	}
+	configBytes, err := model.FetchConfigBytes()
+	if err != nil {
+		return err
+	}
+	if plan.Checksum(configBytes) != summary.ConfigChecksum {
+		return util.NewError(util.ConfigError, "fk-infra.yml has changed since %s was planned, run plan again", planFile)
+	}
+
+	if !approved {
+		log.Printf("Plan %s is ready to apply, see %s for the changes", planFile, plan.SummaryFilename(planFile))
+		return nil
+	}
+
+	if len(summary.StateMigration) > 0 {
+		if err := terraform.MigrateLegacyState(config.Spec.ConfigBucket, config.Spec.Region); err != nil {
+			return err
+		}
+		log.Printf("Terraform state migrated, run plan again to plan the environment")
+		return nil
+	}
+
+	if err := crypto.DecryptKeys(); err != nil {
+		return err
+	}
+
+	// The secrets are only created once the plan has been approved, with the passwords it was made with
+	if err := templates.CreatePlannedDatabasePasswords(config, planFile); err != nil {
+		return err
+	}
+
+	if err := terraform.ApplyPlan(planFile, summary.States); err != nil {
+		return err
+	}
+
+	terraformOutputs, err := terraform.FetchTerraformOutputs()
+	if err != nil {
+		return err
+	}
+
+	return templates.ApplyPlannedKubernetesClusters(config, terraformOutputs, summary.Kubernetes)
+}
+
 func init() {
 	applyCmd.Flags().Bool(FlagApprove, false, "Approve the described infrastructure and apply it to the environment")
+	applyCmd.Flags().String(FlagPlanFile, "", "Apply a plan saved by the plan command instead of planning again")
 	RootCmd.AddCommand(applyCmd)
 }
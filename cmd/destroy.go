@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"github.com/infinityworks/fk-infra/aws"
+	"github.com/infinityworks/fk-infra/model"
+	"github.com/infinityworks/fk-infra/templates"
+	"github.com/infinityworks/fk-infra/terraform"
+	"github.com/spf13/cobra"
+	"log"
+)
+
+const (
+	FlagDeleteEncryptionKey = "delete-encryption-key"
+	FlagDeleteConfigBucket  = "delete-config-bucket"
+)
+
+var destroyCmd = &cobra.Command{
+	Use:   "destroy",
+	Short: "Tear down the environment described in this directory",
+	Long:  "Deletes the kubernetes clusters and then destroys the databases, queues, elasticsearch domains and network in that order. Databases are snapshotted before they are removed and their password secrets are scheduled for deletion",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := model.FetchConfig()
+		if err != nil {
+			return err
+		}
+		approved, err := cmd.Flags().GetBool(FlagApprove)
+		if err != nil {
+			return err
+		}
+		deleteEncryptionKey, err := cmd.Flags().GetBool(FlagDeleteEncryptionKey)
+		if err != nil {
+			return err
+		}
+		deleteConfigBucket, err := cmd.Flags().GetBool(FlagDeleteConfigBucket)
+		if err != nil {
+			return err
+		}
+
+		// Without approval nothing is written, not even the migrated state, which is migrated before anything is
+		// deleted
+		if approved {
+			if err := terraform.MigrateLegacyState(config.Spec.ConfigBucket, config.Spec.Region); err != nil {
+				return err
+			}
+		}
+
+		if err := templates.DeleteKubernetesClusters(config, approved); err != nil {
+			return err
+		}
+
+		if !approved {
+			if migrationPending, err := reportStateMigration(config); err != nil || migrationPending {
+				return err
+			}
+		}
+
+		if err := templates.RenderTerraformForDestroy(config); err != nil {
+			return err
+		}
+
+		if err := templates.ValidateFinalSnapshots(config); err != nil {
+			return err
+		}
+
+		if err := terraform.PlanAndDestroy(approved); err != nil {
+			return err
+		}
+
+		// The secrets are encrypted with the environment key so they go before it
+		if err := templates.DeleteDatabasePasswords(config, approved); err != nil {
+			return err
+		}
+
+		if !approved {
+			if deleteConfigBucket {
+				log.Printf("Config bucket %s would be deleted", config.Spec.ConfigBucket)
+			}
+			if deleteEncryptionKey {
+				log.Printf("Encryption key %s would be scheduled for deletion", config.Spec.EncryptionKey)
+			}
+			return nil
+		}
+
+		// The bucket holds the kops and terraform state so it has to outlive everything else
+		if deleteConfigBucket {
+			if err := aws.DeleteBucket(config.Spec.ConfigBucket, config.Spec.Region); err != nil {
+				return err
+			}
+		}
+		if deleteEncryptionKey {
+			return aws.DeleteKmsKey(config.Spec.EncryptionKey, config.Spec.Region)
+		}
+		return nil
+	},
+}
+
+func init() {
+	destroyCmd.Flags().Bool(FlagApprove, false, "Approve the destruction of the described infrastructure")
+	destroyCmd.Flags().Bool(FlagDeleteEncryptionKey, false, "Schedule deletion of the KMS key created by init, encrypted files can no longer be decrypted once it is gone")
+	destroyCmd.Flags().Bool(FlagDeleteConfigBucket, false, "Delete the config bucket created by init, including all kops and terraform state")
+	RootCmd.AddCommand(destroyCmd)
+}
@@ -19,38 +19,56 @@ var initCmd = &cobra.Command{
 	Use:   "init",
 	Short: "Initiate this directory ready for use",
 	Long:  "Creates basic config with defaults and necessary tooling ready to start creating a simple cluster and supporting infrastructure. Edit fk-infra.yml to change defaults",
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		envName, err := cmd.Flags().GetString(FlagEnvironmentName)
-		util.CheckError(err)
+		if err != nil {
+			return err
+		}
 		region, err := cmd.Flags().GetString(FlagRegion)
-		util.CheckError(err)
-
-		bucketLocation := aws.CreateBucket(envName, region)
-		keyAlias := aws.CreateKmsKey(envName, region)
+		if err != nil {
+			return err
+		}
 
-		configModel := model.Config{
-			Spec: model.Spec{
-				EnvironmentName: envName,
-				Region:          region,
-				EncryptionKey:   keyAlias,
-				ConfigBucket:    bucketLocation,
-				Kubernetes: []model.Kubernetes{{
-					Name:                     gossipClusterFriendlyKubernetesName(envName),
-					LoggingElasticSearchName: "logging",
-				}},
-				ElasticSearch: []model.ElasticSearch{{Name: "logging"},
-				},
-			},
+		bucketLocation, err := aws.CreateBucket(envName, region)
+		if err != nil {
+			return err
+		}
+		keyAlias, err := aws.CreateKmsKey(envName, region)
+		if err != nil {
+			return err
 		}
 
+		configModel := initialConfig(envName, region, bucketLocation, keyAlias)
 		configModelBytes, err := yaml.Marshal(&configModel)
-		util.CheckError(err)
-		util.WriteFile("./fk-infra.yml", configModelBytes)
+		if err != nil {
+			return err
+		}
+		if err := util.WriteFile("./fk-infra.yml", configModelBytes); err != nil {
+			return err
+		}
 
-		crypto.CreateOrValidateExistingKey()
+		return crypto.CreateOrValidateExistingKey()
 	},
 }
 
+// initialConfig is a single cluster logging to a single ElasticSearch domain, with everything else defaulted.
+func initialConfig(envName, region, bucketLocation, keyAlias string) model.Config {
+	return model.Config{
+		Spec: model.Spec{
+			EnvironmentName: envName,
+			Region:          region,
+			EncryptionKey:   keyAlias,
+			ConfigBucket:    bucketLocation,
+			Kubernetes: []model.Kubernetes{{
+				Name:                     gossipClusterFriendlyKubernetesName(envName),
+				LoggingElasticSearchName: "logging",
+			}},
+			ElasticSearch: []model.ElasticSearch{{Name: "logging"},
+			},
+		},
+	}
+}
+
 func gossipClusterFriendlyKubernetesName(envName string) string {
 	return fmt.Sprintf("%s.k8s.local", envName)
 }
@@ -58,7 +76,11 @@ func gossipClusterFriendlyKubernetesName(envName string) string {
 func init() {
 	initCmd.Flags().String(FlagEnvironmentName, "", "The name of the environment to initiate")
 	initCmd.Flags().String(FlagRegion, "", "The region to create the environment")
-	util.CheckError(initCmd.MarkFlagRequired(FlagEnvironmentName))
-	util.CheckError(initCmd.MarkFlagRequired(FlagRegion))
+	if err := initCmd.MarkFlagRequired(FlagEnvironmentName); err != nil {
+		panic(err)
+	}
+	if err := initCmd.MarkFlagRequired(FlagRegion); err != nil {
+		panic(err)
+	}
 	RootCmd.AddCommand(initCmd)
 }
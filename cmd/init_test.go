@@ -0,0 +1,24 @@
+package cmd
+
+import (
+	"github.com/ghodss/yaml"
+	"github.com/infinityworks/fk-infra/model"
+	"github.com/infinityworks/fk-infra/templates"
+	"testing"
+)
+
+func TestInitialConfigIsValid(t *testing.T) {
+	configBytes, err := yaml.Marshal(initialConfig("dev", "eu-west-1", "dev-fk-infra-config", "alias/dev-fk-infra"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Read back the way every other command reads fk-infra.yml
+	var config model.Config
+	if err := yaml.Unmarshal(configBytes, &config); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := templates.ValidateConfig(config.Spec); err != nil {
+		t.Errorf("expected the config written by init to be valid, got %v\n%s", err, configBytes)
+	}
+}
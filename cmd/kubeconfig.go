@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/infinityworks/fk-infra/model"
+	"github.com/infinityworks/fk-infra/templates"
+	"github.com/spf13/cobra"
+	"log"
+)
+
+const (
+	FlagKubeConfigFile = "file"
+)
+
+var kubeConfigCmd = &cobra.Command{
+	Use:   "kubeconfig <cluster-name>",
+	Short: "Export credentials for one of the kubernetes clusters",
+	Long:  "Writes credentials for a cluster in fk-infra.yml to a kubeconfig file of its own, under a context named after the cluster, leaving ~/.kube/config untouched",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := model.FetchConfig()
+		if err != nil {
+			return err
+		}
+		clusterName := args[0]
+		kubeConfigFile, err := cmd.Flags().GetString(FlagKubeConfigFile)
+		if err != nil {
+			return err
+		}
+		if kubeConfigFile == "" {
+			kubeConfigFile = fmt.Sprintf("./%s.kubeconfig", clusterName)
+		}
+
+		if err := templates.ExportKubeConfig(config, clusterName, kubeConfigFile); err != nil {
+			return err
+		}
+
+		log.Printf("Credentials for %s written to %s, use them with KUBECONFIG=%s", clusterName, kubeConfigFile, kubeConfigFile)
+		return nil
+	},
+}
+
+func init() {
+	kubeConfigCmd.Flags().String(FlagKubeConfigFile, "", "Where to write the kubeconfig, defaults to <cluster-name>.kubeconfig")
+	RootCmd.AddCommand(kubeConfigCmd)
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"github.com/infinityworks/fk-infra/crypto"
+	"github.com/infinityworks/fk-infra/model"
+	"github.com/infinityworks/fk-infra/plan"
+	"github.com/infinityworks/fk-infra/templates"
+	"github.com/infinityworks/fk-infra/terraform"
+	"github.com/spf13/cobra"
+	"log"
+)
+
+const (
+	defaultPlanFile = "fk-infra.tfplan"
+)
+
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Save a reviewable plan of the changes apply would make",
+	Long:  "Writes a terraform plan file along with a json summary of the resources to add, change and destroy per component and the changes kops would make to each cluster. Nothing is changed until it is applied with apply --plan-file. State left by terraform 0.11 is migrated by applying a plan of its own first",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := model.FetchConfig()
+		if err != nil {
+			return err
+		}
+		planFile, err := cmd.Flags().GetString(FlagPlanFile)
+		if err != nil {
+			return err
+		}
+
+		// Terraform can't plan against state left by terraform 0.11, migrating it is planned on its own instead
+		stateMigration, err := terraform.PendingStateMigration(config.Spec.ConfigBucket, config.Spec.Region)
+		if err != nil {
+			return err
+		}
+		if len(stateMigration) > 0 {
+			return planStateMigration(planFile, stateMigration)
+		}
+
+		if err := crypto.DecryptKeys(); err != nil {
+			return err
+		}
+
+		if err := templates.RenderTerraformForPlan(config); err != nil {
+			return err
+		}
+
+		plans, err := terraform.Plan(planFile)
+		if err != nil {
+			return err
+		}
+
+		summary, err := planSummary(config, plans)
+		if err != nil {
+			return err
+		}
+		if err := plan.WriteSummary(planFile, summary); err != nil {
+			return err
+		}
+
+		log.Printf("Plan saved to %s and summarised in %s", planFile, plan.SummaryFilename(planFile))
+		return nil
+	},
+}
+
+func planStateMigration(planFile string, stateMigration []string) error {
+	configBytes, err := model.FetchConfigBytes()
+	if err != nil {
+		return err
+	}
+	summary := plan.Summary{
+		ConfigChecksum: plan.Checksum(configBytes),
+		StateMigration: stateMigration,
+	}
+	if err := plan.WriteSummary(planFile, summary); err != nil {
+		return err
+	}
+
+	log.Printf("Terraform state has to be migrated before the environment can be planned:")
+	for _, step := range stateMigration {
+		log.Printf("  %s", step)
+	}
+	log.Printf("Migrate it with apply --plan-file %s --approve and then plan again", planFile)
+	return nil
+}
+
+func planSummary(config *model.Config, plans map[string][]byte) (plan.Summary, error) {
+	var summary plan.Summary
+	configBytes, err := model.FetchConfigBytes()
+	if err != nil {
+		return summary, err
+	}
+	summary.ConfigChecksum = plan.Checksum(configBytes)
+
+	summary.States = make(map[string]terraform.StateVersion)
+	for component := range plans {
+		if summary.States[component], err = terraform.FetchStateVersion(component); err != nil {
+			return summary, err
+		}
+	}
+	if summary.Terraform, err = plan.TerraformChanges(plans); err != nil {
+		return summary, err
+	}
+
+	terraformOutputs, err := terraform.FetchTerraformOutputs()
+	if err != nil {
+		return summary, err
+	}
+	summary.Kubernetes, err = templates.PlanKubernetesClusters(config, terraformOutputs)
+	return summary, err
+}
+
+func init() {
+	planCmd.Flags().String(FlagPlanFile, defaultPlanFile, "Where to save the plan, the summary is saved alongside it")
+	RootCmd.AddCommand(planCmd)
+}
@@ -2,25 +2,60 @@ package cmd
 
 import (
 	"fmt"
+	"github.com/infinityworks/fk-infra/executable"
 	"github.com/infinityworks/fk-infra/util"
 	"github.com/spf13/cobra"
 	"os"
 )
 
+const (
+	FlagToolMirror    = "tool-mirror"
+	FlagToolsFromPath = "tools-from-path"
+)
+
+// Exit codes let scripts tell a mistake in fk-infra.yml apart from a failure talking to AWS or running a tool.
+var exitCodes = map[util.ErrorKind]int{
+	util.UnknownError:   1,
+	util.ConfigError:    2,
+	util.CloudError:     3,
+	util.ExecutionError: 4,
+	util.TimeoutError:   5,
+}
+
 var RootCmd = &cobra.Command{
-	Use:   "fk-infra",
-	Short: "Create a kubernetes cluster and additional infrastructure to complement",
-	Run: func(cmd *cobra.Command, args []string) {
-		util.CheckError(cmd.Help())
+	Use:           "fk-infra",
+	Short:         "Create a kubernetes cluster and additional infrastructure to complement",
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		toolMirror, err := cmd.Flags().GetString(FlagToolMirror)
+		if err != nil {
+			return err
+		}
+		toolsFromPath, err := cmd.Flags().GetBool(FlagToolsFromPath)
+		if err != nil {
+			return err
+		}
+		executable.Configure(executable.Options{
+			Mirror:        toolMirror,
+			ToolsFromPath: toolsFromPath,
+		})
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cmd.Help()
 	},
 }
 
 func init() {
+	RootCmd.PersistentFlags().String(FlagToolMirror, "", "Fetch kops, terraform and terraform plugins from a local directory or http(s) URL instead of the internet, laid out as <tool>/<version>/<release file> and terraform-plugins/<os>_<arch>")
+	RootCmd.PersistentFlags().Bool(FlagToolsFromPath, false, "Use kops and terraform from PATH when they match the pinned versions")
 }
 
 func Execute() {
 	if err := RootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		kind := util.KindOf(err)
+		fmt.Fprintf(os.Stderr, "%s error: %v\n", kind, err)
+		os.Exit(exitCodes[kind])
 	}
 }
@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"github.com/infinityworks/fk-infra/model"
+	"github.com/infinityworks/fk-infra/templates"
+	"github.com/spf13/cobra"
+)
+
+var rotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Replace credentials managed for the environment",
+}
+
+var rotateDatabasePasswordCmd = &cobra.Command{
+	Use:   "db-password <database-name>",
+	Short: "Replace the password of one of the databases",
+	Long:  "Generates a new password for a database, changes it on the RDS instance and then makes it current in Secrets Manager. The Secret for the database in each cluster is updated and the deployments reading it are restarted. Connections opened with the old password stay open until their pods are replaced",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := model.FetchConfig()
+		if err != nil {
+			return err
+		}
+		return templates.RotateDatabasePassword(config, args[0])
+	},
+}
+
+func init() {
+	rotateCmd.AddCommand(rotateDatabasePasswordCmd)
+	RootCmd.AddCommand(rotateCmd)
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"github.com/infinityworks/fk-infra/model"
+	"github.com/infinityworks/fk-infra/status"
+	"github.com/infinityworks/fk-infra/terraform"
+	"github.com/infinityworks/fk-infra/util"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+const (
+	FlagOutput  = "output"
+	outputTable = "table"
+	outputJson  = "json"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Report the state of the environment",
+	Long:  "Reports the network from the terraform outputs, the validation and node counts of each kubernetes cluster, the state of each database, the health of each elasticsearch domain and the fluent-bit rollout, as a table or json",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := model.FetchConfig()
+		if err != nil {
+			return err
+		}
+		output, err := cmd.Flags().GetString(FlagOutput)
+		if err != nil {
+			return err
+		}
+		if output != outputTable && output != outputJson {
+			return util.NewError(util.ConfigError, "unknown output %s, expected %s or %s", output, outputTable, outputJson)
+		}
+
+		terraformOutputs, err := terraform.FetchTerraformOutputs()
+		if err != nil {
+			return err
+		}
+		report, err := status.Collect(config, terraformOutputs)
+		if err != nil {
+			return err
+		}
+
+		if output == outputJson {
+			return report.WriteJson(os.Stdout)
+		}
+		return report.WriteTable(os.Stdout)
+	},
+}
+
+func init() {
+	statusCmd.Flags().StringP(FlagOutput, "o", outputTable, "Output format, table or json")
+	RootCmd.AddCommand(statusCmd)
+}
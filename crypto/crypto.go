@@ -10,16 +10,24 @@ import (
 	"os"
 )
 
-func Encrypt(filename string) {
+func Encrypt(filename string) error {
 	log.Printf("encrypting file %s", filename)
-	config := model.FetchConfig().Spec
-	newKms := kms.NewKms(config.EncryptionKey, config.Region, os.Getenv("AWS_PROFILE"))
-	util.CheckError(secrets.NewSecrets(newKms, compress.NewGzipCompressor(), filename).Encrypt("./"))
+	config, err := model.FetchConfig()
+	if err != nil {
+		return err
+	}
+	newKms := kms.NewKms(config.Spec.EncryptionKey, config.Spec.Region, os.Getenv("AWS_PROFILE"))
+	err = secrets.NewSecrets(newKms, compress.NewGzipCompressor(), filename).Encrypt("./")
+	return util.WrapError(util.CloudError, err, "unable to encrypt %s", filename)
 }
 
-func Decrypt(filename string) {
+func Decrypt(filename string) error {
 	log.Printf("decrypting file %s", filename)
-	config := model.FetchConfig().Spec
-	newKms := kms.NewKms(config.EncryptionKey, config.Region, os.Getenv("AWS_PROFILE"))
-	util.CheckError(secrets.NewSecrets(newKms, compress.NewGzipCompressor(), filename).Decrypt("./"))
+	config, err := model.FetchConfig()
+	if err != nil {
+		return err
+	}
+	newKms := kms.NewKms(config.Spec.EncryptionKey, config.Spec.Region, os.Getenv("AWS_PROFILE"))
+	err = secrets.NewSecrets(newKms, compress.NewGzipCompressor(), filename).Decrypt("./")
+	return util.WrapError(util.CloudError, err, "unable to decrypt %s", filename)
 }
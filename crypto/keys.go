@@ -22,70 +22,93 @@ const (
 	gitignoreFile  = "keys/.gitignore"
 )
 
-func CreateOrValidateExistingKey() {
+func CreateOrValidateExistingKey() error {
 	if !util.PathExists(keyDir) {
 		log.Println("fresh installation, creating keys directory")
-		createKeyDirectory()
+		if err := createKeyDirectory(); err != nil {
+			return err
+		}
 	}
 
 	if util.PathExists(encryptedName(privateKeyFile)) {
 		log.Println("existing key detected and being used")
-		DecryptKeys()
+		if err := DecryptKeys(); err != nil {
+			return err
+		}
 	} else {
 		log.Println("creating new key")
-		privateKey := createAndEncryptPrivateKey()
-		createAndEncryptPublicKey(privateKey)
+		privateKey, err := createAndEncryptPrivateKey()
+		if err != nil {
+			return err
+		}
+		if err := createAndEncryptPublicKey(privateKey); err != nil {
+			return err
+		}
 	}
 
 	privateKeyBytes, err := ioutil.ReadFile(privateKeyFile)
-	util.CheckError(err)
+	if err != nil {
+		return util.WrapError(util.ConfigError, err, "unable to read %s", privateKeyFile)
+	}
 
 	_, err = ssh.ParseRawPrivateKey(privateKeyBytes)
-	util.CheckError(err)
+	return util.WrapError(util.ConfigError, err, "%s is not a valid private key", privateKeyFile)
 }
 
-func DecryptKeys() {
-	Decrypt(encryptedName(privateKeyFile))
-	Decrypt(encryptedName(PublicKeyFile))
+func DecryptKeys() error {
+	if err := Decrypt(encryptedName(privateKeyFile)); err != nil {
+		return err
+	}
+	return Decrypt(encryptedName(PublicKeyFile))
 }
 
 func encryptedName(fileName string) string {
 	return fmt.Sprintf("%s.enc", fileName)
 }
 
-func createAndEncryptPrivateKey() *rsa.PrivateKey {
+func createAndEncryptPrivateKey() (*rsa.PrivateKey, error) {
 	privateKey, err := rsa.GenerateKey(rand.Reader, 4096)
-	util.CheckError(err)
+	if err != nil {
+		return nil, util.WrapError(util.UnknownError, err, "unable to generate private key")
+	}
 
 	buffer := new(bytes.Buffer)
 
-	util.CheckError(pem.Encode(buffer, &pem.Block{
+	if err := pem.Encode(buffer, &pem.Block{
 		Type:  "RSA PRIVATE KEY",
 		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
-	}))
-
-	util.WriteFile(privateKeyFile, buffer.Bytes())
+	}); err != nil {
+		return nil, util.WrapError(util.UnknownError, err, "unable to encode private key")
+	}
 
-	Encrypt(privateKeyFile)
+	if err := util.WriteFile(privateKeyFile, buffer.Bytes()); err != nil {
+		return nil, err
+	}
 
-	return privateKey
+	return privateKey, Encrypt(privateKeyFile)
 }
 
-func createAndEncryptPublicKey(privateKey *rsa.PrivateKey) {
+func createAndEncryptPublicKey(privateKey *rsa.PrivateKey) error {
 	publicKey, err := ssh.NewPublicKey(&privateKey.PublicKey)
-	util.CheckError(err)
-	util.WriteFile(PublicKeyFile, ssh.MarshalAuthorizedKey(publicKey))
+	if err != nil {
+		return util.WrapError(util.UnknownError, err, "unable to create public key")
+	}
+	if err := util.WriteFile(PublicKeyFile, ssh.MarshalAuthorizedKey(publicKey)); err != nil {
+		return err
+	}
 
-	Encrypt(PublicKeyFile)
+	return Encrypt(PublicKeyFile)
 }
 
-func createKeyDirectory() {
-	util.CheckError(os.MkdirAll(keyDir, os.FileMode(0777)))
-	createGitIgnore()
+func createKeyDirectory() error {
+	if err := os.MkdirAll(keyDir, os.FileMode(0777)); err != nil {
+		return util.WrapError(util.ConfigError, err, "unable to create %s", keyDir)
+	}
+	return createGitIgnore()
 }
 
-func createGitIgnore() {
-	util.WriteFile(gitignoreFile, []byte(
+func createGitIgnore() error {
+	return util.WriteFile(gitignoreFile, []byte(
 		`*
 !*.enc
 !*.gitignore
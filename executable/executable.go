@@ -2,60 +2,197 @@ package executable
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"github.com/infinityworks/fk-infra/util"
 	"github.com/spf13/afero"
 	"io"
+	"io/ioutil"
 	"log"
-	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
 )
 
 const (
-	tempBinaryLocation = ".fk-infra/temp_binary"
+	cacheDirectory = ".fk-infra"
 )
 
-func CacheOrDownload(
-	binaryLocation string,
-	downloadLocation func() string,
-	postDownloadFunction func(tempBinaryLocation string),
-	args ...string) []byte {
+// Tool pins a binary to a version along with the SHA256 of its download for each platform, keyed by "os/arch".
+type Tool struct {
+	Name        string
+	Version     string
+	Filename    func(version, goos, goarch string) string
+	DownloadUrl func(version, filename string) string
+	Checksums   map[string]string
+	VersionArgs []string
+	// Install moves the verified download into place at binaryLocation.
+	Install func(downloadLocation, binaryLocation string) error
+}
+
+// SupportedPlatforms are the "os/arch" pairs every tool has to pin a checksum for.
+var SupportedPlatforms = []string{"linux/amd64", "darwin/amd64"}
+
+var checksumPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// MissingChecksums lists the supported platforms the tool has no valid SHA256 for.
+func (tool Tool) MissingChecksums() []string {
+	var missing []string
+	for _, platform := range SupportedPlatforms {
+		if !checksumPattern.MatchString(tool.Checksums[platform]) {
+			missing = append(missing, platform)
+		}
+	}
+	return missing
+}
+
+// BinaryLocation is keyed by version so changing the pinned version downloads it again.
+func (tool Tool) BinaryLocation() string {
+	return filepath.Join(cacheDirectory, tool.Name, tool.Version, tool.Name)
+}
+
+func (tool Tool) checksumLocation() string {
+	return tool.BinaryLocation() + ".sha256"
+}
+
+// resolvedTools saves checking the same binary on every execution.
+var resolvedTools = make(map[string]string)
+
+func CacheOrDownload(tool Tool, args ...string) ([]byte, error) {
+	return CacheOrDownloadWithInvocation(tool, Invocation{}, args...)
+}
+
+// Invocation changes how a tool is run beyond its arguments.
+type Invocation struct {
+	// Environment is added to the environment the tool runs with, in the form "KEY=value".
+	Environment []string
+	// Quiet only returns the output of the tool rather than also printing it, keeping stdout free for reports.
+	Quiet bool
+	// Directory to run the tool in, the current directory when empty.
+	Directory string
+}
+
+func CacheOrDownloadWithInvocation(tool Tool, invocation Invocation, args ...string) ([]byte, error) {
+	binaryLocation, ok := resolvedTools[tool.Name]
+	if !ok {
+		var err error
+		if binaryLocation, err = resolve(tool); err != nil {
+			return nil, err
+		}
+		resolvedTools[tool.Name] = binaryLocation
+	}
+	return execute(binaryLocation, invocation, args...)
+}
+
+func resolve(tool Tool) (string, error) {
+	if options.ToolsFromPath {
+		if binaryLocation, found, err := pathBinary(tool); err != nil || found {
+			return binaryLocation, err
+		}
+	}
+
+	binaryLocation := tool.BinaryLocation()
 	if exists, err := afero.Exists(afero.NewOsFs(), binaryLocation); err == nil && exists {
-		return execute(binaryLocation, args...)
-	} else {
-		util.CheckError(os.MkdirAll(".fk-infra", 0750))
+		return binaryLocation, verifyCachedBinary(tool)
+	}
+	return binaryLocation, downloadAndInstall(tool)
+}
 
-		resp, err := http.Get(downloadLocation())
-		util.CheckError(err)
+func downloadAndInstall(tool Tool) error {
+	platform := fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH)
+	expectedChecksum, ok := tool.Checksums[platform]
+	if !ok {
+		return util.NewError(util.ConfigError, "no checksum is pinned for %s %s on %s, refusing to download it", tool.Name, tool.Version, platform)
+	}
 
-		out, err := os.Create(tempBinaryLocation)
-		util.CheckError(err)
+	binaryDirectory := filepath.Dir(tool.BinaryLocation())
+	if err := os.MkdirAll(binaryDirectory, 0750); err != nil {
+		return util.WrapError(util.ExecutionError, err, "unable to create %s", binaryDirectory)
+	}
 
-		defer func() {
-			util.CheckError(resp.Body.Close())
-			util.CheckError(out.Close())
-		}()
+	downloadUrl := tool.sourceLocation(tool.Filename(tool.Version, runtime.GOOS, runtime.GOARCH))
+	log.Printf("Downloading %s %s from %s", tool.Name, tool.Version, downloadUrl)
+	downloadLocation := filepath.Join(binaryDirectory, "download")
+	checksum, err := Fetch(downloadUrl, downloadLocation)
+	if err != nil {
+		os.Remove(downloadLocation)
+		return err
+	}
+	if !strings.EqualFold(checksum, expectedChecksum) {
+		os.Remove(downloadLocation)
+		return util.NewError(util.ExecutionError, "download of %s %s from %s has checksum %s, expected %s", tool.Name, tool.Version, downloadUrl, checksum, expectedChecksum)
+	}
 
-		_, err = io.Copy(out, resp.Body)
-		util.CheckError(err)
+	if err := tool.Install(downloadLocation, tool.BinaryLocation()); err != nil {
+		return util.WrapError(util.ExecutionError, err, "unable to install %s %s", tool.Name, tool.Version)
+	}
 
-		postDownloadFunction(tempBinaryLocation)
+	// The installed binary is recorded separately from the download as archives are unpacked by Install
+	binaryChecksum, err := FileChecksum(tool.BinaryLocation())
+	if err != nil {
+		return err
+	}
+	return util.WriteFile(tool.checksumLocation(), []byte(binaryChecksum))
+}
 
-		return execute(binaryLocation, args...)
+func verifyCachedBinary(tool Tool) error {
+	expectedChecksum, err := ioutil.ReadFile(tool.checksumLocation())
+	if err != nil {
+		return util.WrapError(util.ExecutionError, err, "cached %s %s has no recorded checksum, delete %s to download it again", tool.Name, tool.Version, filepath.Dir(tool.BinaryLocation()))
+	}
+	checksum, err := FileChecksum(tool.BinaryLocation())
+	if err != nil {
+		return err
+	}
+	if checksum != strings.TrimSpace(string(expectedChecksum)) {
+		return util.NewError(util.ExecutionError, "cached %s %s is corrupted, delete %s to download it again", tool.Name, tool.Version, filepath.Dir(tool.BinaryLocation()))
 	}
+	return nil
 }
 
-func execute(binaryLocation string, args ...string) []byte {
-	log.Printf("Executing %s %s", binaryLocation, args)
-	cmd := exec.Command(binaryLocation, args...)
+// FileChecksum returns the hex encoded SHA256 of a file.
+func FileChecksum(fileLocation string) (string, error) {
+	file, err := os.Open(fileLocation)
+	if err != nil {
+		return "", util.WrapError(util.ExecutionError, err, "unable to open %s", fileLocation)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", util.WrapError(util.ExecutionError, err, "unable to read %s", fileLocation)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func execute(binaryLocation string, invocation Invocation, args ...string) ([]byte, error) {
+	if invocation.Directory != "" {
+		log.Printf("Executing %s %s in %s", binaryLocation, args, invocation.Directory)
+	} else {
+		log.Printf("Executing %s %s", binaryLocation, args)
+	}
+	absoluteBinaryLocation, err := filepath.Abs(binaryLocation)
+	if err != nil {
+		return nil, util.WrapError(util.ExecutionError, err, "unable to find %s", binaryLocation)
+	}
+	cmd := exec.Command(absoluteBinaryLocation, args...)
+	cmd.Dir = invocation.Directory
+	cmd.Env = append(os.Environ(), invocation.Environment...)
 	cmd.Stderr = os.Stderr
+	if invocation.Quiet {
+		output, err := cmd.Output()
+		return output, util.WrapError(util.ExecutionError, err, "%s %s failed", binaryLocation, args)
+	}
 	dualWriter := DualWriter{
 		buffer: new(bytes.Buffer),
 	}
 	cmd.Stdout = dualWriter
-	err := cmd.Run()
-	util.CheckError(err)
-	return dualWriter.Bytes()
+	err = cmd.Run()
+	return dualWriter.Bytes(), util.WrapError(util.ExecutionError, err, "%s %s failed", binaryLocation, args)
 }
 
 type DualWriter struct {
@@ -63,8 +200,9 @@ type DualWriter struct {
 }
 
 func (dualWriter DualWriter) Write(p []byte) (n int, err error) {
-	_, err = os.Stdout.Write(p)
-	util.CheckError(err)
+	if _, err = os.Stdout.Write(p); err != nil {
+		return 0, err
+	}
 	return dualWriter.buffer.Write(p)
 }
 
@@ -0,0 +1,28 @@
+package executable
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMissingChecksums(t *testing.T) {
+	// SHA256 of an empty file
+	checksum := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	tests := []struct {
+		name      string
+		checksums map[string]string
+		want      []string
+	}{
+		{name: "every platform pinned", checksums: map[string]string{"linux/amd64": checksum, "darwin/amd64": checksum}},
+		{name: "nothing pinned", checksums: map[string]string{}, want: []string{"linux/amd64", "darwin/amd64"}},
+		{name: "one platform pinned", checksums: map[string]string{"linux/amd64": checksum}, want: []string{"darwin/amd64"}},
+		{name: "checksum that isn't a SHA256", checksums: map[string]string{"linux/amd64": "abc", "darwin/amd64": checksum}, want: []string{"linux/amd64"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := (Tool{Name: "tool", Checksums: test.checksums}).MissingChecksums(); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
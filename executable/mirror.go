@@ -0,0 +1,113 @@
+package executable
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/infinityworks/fk-infra/util"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Options decide where tools come from. By default they are downloaded from their upstream releases.
+type Options struct {
+	// Mirror is a local directory or http(s) URL laid out as <tool>/<version>/<release file>, checksums are
+	// verified against the pinned ones exactly as they are for upstream downloads.
+	Mirror string
+	// ToolsFromPath uses binaries already on PATH when their version matches the pinned one.
+	ToolsFromPath bool
+}
+
+var options Options
+
+func Configure(toolOptions Options) {
+	options = toolOptions
+}
+
+func Mirror() string {
+	return options.Mirror
+}
+
+func MirrorIsUrl() bool {
+	return isUrl(options.Mirror)
+}
+
+// MirrorLocation joins elements onto the mirror the same way for directories and URLs.
+func MirrorLocation(elements ...string) string {
+	if isUrl(options.Mirror) {
+		return strings.TrimSuffix(options.Mirror, "/") + "/" + path.Join(elements...)
+	}
+	return filepath.Join(append([]string{options.Mirror}, elements...)...)
+}
+
+func (tool Tool) sourceLocation(filename string) string {
+	if options.Mirror != "" {
+		return MirrorLocation(tool.Name, tool.Version, filename)
+	}
+	return tool.DownloadUrl(tool.Version, filename)
+}
+
+// pathBinary returns the location of the tool on PATH, refusing one reporting a different version.
+func pathBinary(tool Tool) (string, bool, error) {
+	binaryLocation, err := exec.LookPath(tool.Name)
+	if err != nil {
+		log.Printf("%s is not on PATH, falling back to %s %s", tool.Name, tool.Name, tool.Version)
+		return "", false, nil
+	}
+	versionOutput, err := exec.Command(binaryLocation, tool.VersionArgs...).Output()
+	if err != nil {
+		return "", false, util.WrapError(util.ExecutionError, err, "unable to check the version of %s", binaryLocation)
+	}
+	// 1.11.1 mustn't match 1.11.10 or 11.11.1
+	versionPattern := regexp.MustCompile(fmt.Sprintf(`(^|[^0-9.])v?%s([^0-9.]|\.[^0-9]|\.?$)`, regexp.QuoteMeta(tool.Version)))
+	if !versionPattern.Match(versionOutput) {
+		return "", false, util.NewError(util.ConfigError, "%s on PATH is not version %s: %s", binaryLocation, tool.Version, strings.TrimSpace(string(versionOutput)))
+	}
+	return binaryLocation, true, nil
+}
+
+// Fetch copies a URL or local file to destination, returning the SHA256 of what was written.
+func Fetch(source, destination string) (string, error) {
+	var reader io.ReadCloser
+	if isUrl(source) {
+		resp, err := http.Get(source)
+		if err != nil {
+			return "", util.WrapError(util.ExecutionError, err, "unable to download %s", source)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return "", util.NewError(util.ExecutionError, "unable to download %s, received %s", source, resp.Status)
+		}
+		reader = resp.Body
+	} else {
+		file, err := os.Open(source)
+		if err != nil {
+			return "", util.WrapError(util.ExecutionError, err, "unable to read %s", source)
+		}
+		reader = file
+	}
+	defer reader.Close()
+
+	out, err := os.Create(destination)
+	if err != nil {
+		return "", util.WrapError(util.ExecutionError, err, "unable to create %s", destination)
+	}
+	defer out.Close()
+
+	hash := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(out, hash), reader); err != nil {
+		return "", util.WrapError(util.ExecutionError, err, "unable to copy %s", source)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func isUrl(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
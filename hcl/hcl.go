@@ -0,0 +1,295 @@
+// Package hcl builds terraform configuration as a tree of blocks and attributes and writes it out as
+// terraform 0.12 HCL, so values taken from the config are always quoted and escaped rather than pasted into text.
+package hcl
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+const indentation = "  "
+
+// Expression is anything that can be written as the value of an attribute.
+type Expression interface {
+	write(buf *bytes.Buffer, indent int)
+}
+
+type rawExpression string
+
+func (expression rawExpression) write(buf *bytes.Buffer, indent int) {
+	buf.WriteString(string(expression))
+}
+
+// String is a literal string, interpolation and template directives within it are escaped.
+func String(value string) Expression {
+	return Template(EscapeTemplate(value))
+}
+
+// EscapeTemplate escapes interpolation and template directives so a value taken from the config can be placed
+// within a Template or Heredoc literally.
+func EscapeTemplate(value string) string {
+	value = strings.Replace(value, "${", "$${", -1)
+	return strings.Replace(value, "%{", "%%{", -1)
+}
+
+// Template is a quoted string whose ${...} interpolations are evaluated by terraform.
+func Template(template string) Expression {
+	return rawExpression(quote(template))
+}
+
+// Heredoc is a multi line template, used for documents such as policies that are easier to read unquoted.
+func Heredoc(template string) Expression {
+	delimiter := "EOF"
+	for strings.Contains(template, delimiter) {
+		delimiter += "_"
+	}
+	return rawExpression(fmt.Sprintf("<<%s\n%s\n%s", delimiter, strings.TrimRight(template, "\n"), delimiter))
+}
+
+func Number(value int) Expression {
+	return rawExpression(strconv.Itoa(value))
+}
+
+func Bool(value bool) Expression {
+	return rawExpression(strconv.FormatBool(value))
+}
+
+// Reference refers to another object in the configuration, e.g. Reference("aws_vpc", "main", "id").
+func Reference(traversal ...string) Expression {
+	return rawExpression(strings.Join(traversal, "."))
+}
+
+type callExpression struct {
+	function  string
+	arguments []Expression
+}
+
+// Call invokes a terraform function.
+func Call(function string, arguments ...Expression) Expression {
+	return callExpression{function: function, arguments: arguments}
+}
+
+func (call callExpression) write(buf *bytes.Buffer, indent int) {
+	buf.WriteString(call.function)
+	buf.WriteString("(")
+	for i, argument := range call.arguments {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		argument.write(buf, indent)
+	}
+	buf.WriteString(")")
+}
+
+type listExpression []Expression
+
+func List(items ...Expression) Expression {
+	return listExpression(items)
+}
+
+// Strings is a list of literal strings.
+func Strings(values ...string) Expression {
+	var items []Expression
+	for _, value := range values {
+		items = append(items, String(value))
+	}
+	return List(items...)
+}
+
+func (list listExpression) write(buf *bytes.Buffer, indent int) {
+	buf.WriteString("[")
+	for i, item := range list {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		item.write(buf, indent)
+	}
+	buf.WriteString("]")
+}
+
+// Object is a map or object value, its keys are written in sorted order so the output is stable.
+type Object map[string]Expression
+
+func (object Object) write(buf *bytes.Buffer, indent int) {
+	if len(object) == 0 {
+		buf.WriteString("{}")
+		return
+	}
+	var keys, writtenKeys []string
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		writtenKeys = append(writtenKeys, objectKey(key))
+	}
+	width := longest(writtenKeys)
+
+	buf.WriteString("{\n")
+	for i, key := range keys {
+		writeIndent(buf, indent+1)
+		fmt.Fprintf(buf, "%-*s = ", width, writtenKeys[i])
+		object[key].write(buf, indent+1)
+		buf.WriteString("\n")
+	}
+	writeIndent(buf, indent)
+	buf.WriteString("}")
+}
+
+// Body holds the attributes and nested blocks of a block or file in the order they were added.
+type Body struct {
+	items []interface{}
+}
+
+type attribute struct {
+	name  string
+	value Expression
+}
+
+type Block struct {
+	Body
+	blockType string
+	labels    []string
+}
+
+// Set adds an attribute, returning the body so attributes can be chained.
+func (body *Body) Set(name string, value Expression) *Body {
+	body.items = append(body.items, attribute{name: name, value: value})
+	return body
+}
+
+// Block adds a nested block and returns it for its own attributes to be set.
+func (body *Body) Block(blockType string, labels ...string) *Block {
+	block := &Block{blockType: blockType, labels: labels}
+	body.items = append(body.items, block)
+	return block
+}
+
+// Consecutive single line attributes are aligned on their equals signs, blocks and attributes spanning
+// several lines are set apart by a blank line.
+func (body *Body) write(buf *bytes.Buffer, indent int) {
+	for i := 0; i < len(body.items); {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		if block, ok := body.items[i].(*Block); ok {
+			block.write(buf, indent)
+			i++
+			continue
+		}
+
+		var attributes []attribute
+		for ; i < len(body.items); i++ {
+			attribute, ok := body.items[i].(attribute)
+			if !ok || (len(attributes) > 0 && multiline(attribute.value)) {
+				break
+			}
+			attributes = append(attributes, attribute)
+			if multiline(attribute.value) {
+				i++
+				break
+			}
+		}
+		var names []string
+		for _, attribute := range attributes {
+			names = append(names, attribute.name)
+		}
+		width := longest(names)
+		for _, attribute := range attributes {
+			writeIndent(buf, indent)
+			fmt.Fprintf(buf, "%-*s = ", width, attribute.name)
+			attribute.value.write(buf, indent)
+			buf.WriteString("\n")
+		}
+	}
+}
+
+func (block *Block) write(buf *bytes.Buffer, indent int) {
+	writeIndent(buf, indent)
+	buf.WriteString(block.blockType)
+	for _, label := range block.labels {
+		buf.WriteString(" ")
+		buf.WriteString(quote(label))
+	}
+	if len(block.items) == 0 {
+		buf.WriteString(" {}\n")
+		return
+	}
+	buf.WriteString(" {\n")
+	block.Body.write(buf, indent+1)
+	writeIndent(buf, indent)
+	buf.WriteString("}\n")
+}
+
+func multiline(expression Expression) bool {
+	switch expression := expression.(type) {
+	case Object:
+		return len(expression) > 0
+	case listExpression:
+		for _, item := range expression {
+			if multiline(item) {
+				return true
+			}
+		}
+	case callExpression:
+		for _, argument := range expression.arguments {
+			if multiline(argument) {
+				return true
+			}
+		}
+	case rawExpression:
+		return strings.Contains(string(expression), "\n")
+	}
+	return false
+}
+
+type File struct {
+	Body
+}
+
+func NewFile() *File {
+	return &File{}
+}
+
+func (file *File) Bytes() []byte {
+	var buf bytes.Buffer
+	file.Body.write(&buf, 0)
+	return buf.Bytes()
+}
+
+// ValidIdentifier reports whether a name can be used as a resource name or attribute without quoting.
+func ValidIdentifier(name string) bool {
+	return identifier.MatchString(name)
+}
+
+func quote(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + replacer.Replace(value) + `"`
+}
+
+func objectKey(key string) string {
+	if ValidIdentifier(key) {
+		return key
+	}
+	return quote(key)
+}
+
+func longest(names []string) int {
+	width := 0
+	for _, name := range names {
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+	return width
+}
+
+func writeIndent(buf *bytes.Buffer, indent int) {
+	buf.WriteString(strings.Repeat(indentation, indent))
+}
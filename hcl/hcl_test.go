@@ -0,0 +1,130 @@
+package hcl
+
+import (
+	"bytes"
+	"testing"
+)
+
+func written(expression Expression) string {
+	var buf bytes.Buffer
+	expression.write(&buf, 0)
+	return buf.String()
+}
+
+func TestExpressions(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression Expression
+		want       string
+	}{
+		{name: "string", expression: String("dev-orders"), want: `"dev-orders"`},
+		{name: "string with interpolation", expression: String("${var.password}"), want: `"$${var.password}"`},
+		{name: "string with template directive", expression: String("%{if true}yes%{endif}"), want: `"%%{if true}yes%%{endif}"`},
+		{name: "string with quotes and backslashes", expression: String(`say "hello" \ goodbye`), want: `"say \"hello\" \\ goodbye"`},
+		{name: "string with control characters", expression: String("one\ntwo\r\tthree"), want: `"one\ntwo\r\tthree"`},
+		{name: "template", expression: Template("${var.environment}-orders"), want: `"${var.environment}-orders"`},
+		{name: "heredoc", expression: Heredoc("{\n  \"Version\": \"2012-10-17\"\n}\n"), want: "<<EOF\n{\n  \"Version\": \"2012-10-17\"\n}\nEOF"},
+		{name: "heredoc containing its delimiter", expression: Heredoc("EOF"), want: "<<EOF_\nEOF\nEOF_"},
+		{name: "number", expression: Number(5432), want: "5432"},
+		{name: "bool", expression: Bool(true), want: "true"},
+		{name: "reference", expression: Reference("aws_vpc", "main", "id"), want: "aws_vpc.main.id"},
+		{name: "call", expression: Call("concat", List(String("a")), Strings("b", "c")), want: `concat(["a"], ["b", "c"])`},
+		{name: "empty list", expression: List(), want: "[]"},
+		{name: "empty object", expression: Object{}, want: "{}"},
+		{
+			name: "nested object",
+			expression: Object{
+				"Name":                  String("dev-orders"),
+				"kubernetes.io/cluster": String("owned"),
+				"ports":                 List(Number(80), Number(443)),
+				"nested":                Object{"key": String("value")},
+			},
+			want: `{
+  Name                    = "dev-orders"
+  "kubernetes.io/cluster" = "owned"
+  nested                  = {
+    key = "value"
+  }
+  ports                   = [80, 443]
+}`,
+		},
+		{
+			name:       "list of objects",
+			expression: List(Object{"cidr_block": String("10.0.0.0/16")}),
+			want: `[{
+  cidr_block = "10.0.0.0/16"
+}]`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := written(test.expression); got != test.want {
+				t.Errorf("expected\n%s\ngot\n%s", test.want, got)
+			}
+		})
+	}
+}
+
+func TestFile(t *testing.T) {
+	file := NewFile()
+	file.Block("terraform")
+	resource := file.Block("resource", "aws_db_instance", "orders")
+	resource.Set("identifier", String("dev-orders")).
+		Set("port", Number(5432)).
+		Set("tags", Object{"Name": String("dev-orders")}).
+		Set("password", Reference("var", "password"))
+	resource.Block("lifecycle").
+		Set("ignore_changes", List(Reference("password")))
+	file.Set("policy", Heredoc("{}"))
+
+	want := `terraform {}
+
+resource "aws_db_instance" "orders" {
+  identifier = "dev-orders"
+  port       = 5432
+
+  tags = {
+    Name = "dev-orders"
+  }
+
+  password = var.password
+
+  lifecycle {
+    ignore_changes = [password]
+  }
+}
+
+policy = <<EOF
+{}
+EOF
+`
+	if got := string(file.Bytes()); got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestValidIdentifier(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "orders", want: true},
+		{name: "dev-orders", want: true},
+		{name: "order_events", want: true},
+		{name: "_internal", want: true},
+		{name: "Orders2", want: true},
+		{name: "", want: false},
+		{name: "2orders", want: false},
+		{name: "-orders", want: false},
+		{name: "dev.orders", want: false},
+		{name: "dev orders", want: false},
+		{name: `orders"`, want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ValidIdentifier(test.name); got != test.want {
+				t.Errorf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
@@ -3,26 +3,47 @@ package kops
 import (
 	"fmt"
 	"github.com/infinityworks/fk-infra/executable"
-	"github.com/infinityworks/fk-infra/util"
-	"log"
 	"os"
-	"runtime"
 )
 
-const (
-	kopsBinaryLocation = ".fk-infra/kops"
-)
+// Downloads for platforms without a pinned checksum are refused rather than run unverified, every platform in
+// executable.SupportedPlatforms needs the SHA256 of its kops-<os>-<arch> release binary.
+var kopsTool = executable.Tool{
+	Name:    "kops",
+	Version: "1.11.1",
+	Filename: func(version, goos, goarch string) string {
+		return fmt.Sprintf("kops-%s-%s", goos, goarch)
+	},
+	DownloadUrl: func(version, filename string) string {
+		return fmt.Sprintf("https://github.com/kubernetes/kops/releases/download/%s/%s", version, filename)
+	},
+	Checksums:   map[string]string{},
+	VersionArgs: []string{"version"},
+	Install: func(downloadLocation, binaryLocation string) error {
+		if err := os.Rename(downloadLocation, binaryLocation); err != nil {
+			return err
+		}
+		return os.Chmod(binaryLocation, 0740)
+	},
+}
+
+func ExecuteKops(args ...string) ([]byte, error) {
+	return executable.CacheOrDownload(kopsTool, args...)
+}
+
+// ExecuteKopsWithKubeConfig keeps kops reading and writing cluster credentials in kubeConfigFile instead of ~/.kube/config.
+func ExecuteKopsWithKubeConfig(kubeConfigFile string, args ...string) ([]byte, error) {
+	return executable.CacheOrDownloadWithInvocation(kopsTool, kubeConfigInvocation(kubeConfigFile, false), args...)
+}
+
+// ExecuteKopsQuietly is ExecuteKopsWithKubeConfig without printing what kops writes to stdout.
+func ExecuteKopsQuietly(kubeConfigFile string, args ...string) ([]byte, error) {
+	return executable.CacheOrDownloadWithInvocation(kopsTool, kubeConfigInvocation(kubeConfigFile, true), args...)
+}
 
-func ExecuteKops(args ...string) []byte {
-	return executable.CacheOrDownload(kopsBinaryLocation,
-		func() string {
-			downloadUrl := fmt.Sprintf("https://github.com/kubernetes/kops/releases/download/1.11.1/kops-%s-%s", runtime.GOOS, runtime.GOARCH)
-			log.Printf("Downloading kops from %s", downloadUrl)
-			return downloadUrl
-		},
-		func(tempBinaryLocation string) {
-			util.CheckError(os.Rename(tempBinaryLocation, kopsBinaryLocation))
-			util.CheckError(os.Chmod(kopsBinaryLocation, 0740))
-		},
-		args...)
+func kubeConfigInvocation(kubeConfigFile string, quiet bool) executable.Invocation {
+	return executable.Invocation{
+		Environment: []string{fmt.Sprintf("KUBECONFIG=%s", kubeConfigFile)},
+		Quiet:       quiet,
+	}
 }
@@ -0,0 +1,9 @@
+package kops
+
+import "testing"
+
+func TestKopsChecksumsArePinned(t *testing.T) {
+	if missing := kopsTool.MissingChecksums(); len(missing) > 0 {
+		t.Errorf("kops %s has no SHA256 pinned for %v, copy them from the published release", kopsTool.Version, missing)
+	}
+}
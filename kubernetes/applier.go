@@ -0,0 +1,164 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"github.com/ericchiang/k8s"
+	v13 "github.com/ericchiang/k8s/apis/apps/v1"
+	"github.com/ericchiang/k8s/apis/core/v1"
+	v12 "github.com/ericchiang/k8s/apis/rbac/v1"
+	"github.com/infinityworks/fk-infra/util"
+	"log"
+	"reflect"
+	"sort"
+)
+
+const (
+	environmentLabel = "fk-infra/environment"
+	componentLabel   = "fk-infra/component"
+
+	componentDatabase      = "database"
+	componentElasticSearch = "elasticsearch"
+	componentQueue         = "queue"
+	componentLogging       = "logging"
+)
+
+// Kinds of object fk-infra applies that are deleted once they are no longer configured. Namespaces are labelled
+// but never pruned, deleting one would take everything else in it too.
+var prunableLists = []func() k8s.ResourceList{
+	func() k8s.ResourceList { return &v1.ServiceList{} },
+	func() k8s.ResourceList { return &v1.ConfigMapList{} },
+	func() k8s.ResourceList { return &v1.SecretList{} },
+	func() k8s.ResourceList { return &v1.ServiceAccountList{} },
+	func() k8s.ResourceList { return &v13.DaemonSetList{} },
+	func() k8s.ResourceList { return &v12.ClusterRoleList{} },
+	func() k8s.ResourceList { return &v12.ClusterRoleBindingList{} },
+}
+
+// Applier writes the objects for one cluster, labelling each with the environment and component it belongs to
+// and remembering it, so whatever an earlier apply left behind can be pruned once everything has been applied.
+// In a dry run it only works out what would change.
+type Applier struct {
+	client      *k8s.Client
+	environment string
+	dryRun      bool
+	applied     map[string]bool
+	changes     Changes
+}
+
+// Changes are the objects an apply created, updated and pruned, or would have in a dry run, named by kind,
+// namespace and name. Diff lists the fields of the updated objects that changed.
+type Changes struct {
+	Create []string
+	Update []string
+	Delete []string
+	Diff   []string
+}
+
+func NewApplier(client *k8s.Client, environment string, dryRun bool) *Applier {
+	return &Applier{
+		client:      client,
+		environment: environment,
+		dryRun:      dryRun,
+		applied:     make(map[string]bool),
+	}
+}
+
+// Apply labels the object as owned by the component and creates or updates it.
+func (applier *Applier) Apply(component string, resource k8s.Resource) error {
+	metadata := resource.GetMetadata()
+	if metadata.Labels == nil {
+		metadata.Labels = make(map[string]string)
+	}
+	metadata.Labels[environmentLabel] = applier.environment
+	metadata.Labels[componentLabel] = component
+
+	created, diff, err := CreateOrUpdate(applier.client, resource, applier.dryRun)
+	if err != nil {
+		return err
+	}
+	id := resourceId(resource)
+	applier.applied[id] = true
+	switch {
+	case created:
+		applier.changes.Create = append(applier.changes.Create, id)
+		log.Printf("%s %s", id, applier.describe("created"))
+	case len(diff) > 0:
+		applier.changes.Update = append(applier.changes.Update, id)
+		log.Printf("%s %s", id, applier.describe("updated"))
+		for _, field := range diff {
+			log.Printf("  %s", field)
+			applier.changes.Diff = append(applier.changes.Diff, fmt.Sprintf("%s %s", id, field))
+		}
+	default:
+		log.Printf("%s is unchanged", id)
+	}
+	return nil
+}
+
+// Prune deletes the objects labelled with the environment that weren't applied, which belong to databases,
+// domains or queues removed from the config. All of them are listed before any is deleted.
+func (applier *Applier) Prune() error {
+	var stale []k8s.Resource
+	for _, newList := range prunableLists {
+		list := newList()
+		if err := applier.client.List(context.TODO(), k8s.AllNamespaces, list, k8s.QueryParam("labelSelector", environmentLabel+"="+applier.environment)); err != nil {
+			return util.WrapError(util.CloudError, err, "unable to list %s owned by %s", reflect.TypeOf(list).Elem().Name(), applier.environment)
+		}
+		for _, resource := range listItems(list) {
+			if !applier.applied[resourceId(resource)] {
+				stale = append(stale, resource)
+			}
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+
+	sort.Slice(stale, func(i, j int) bool {
+		return resourceId(stale[i]) < resourceId(stale[j])
+	})
+	log.Printf("%d objects are no longer in fk-infra.yml:", len(stale))
+	for _, resource := range stale {
+		log.Printf("  %s", resourceId(resource))
+		applier.changes.Delete = append(applier.changes.Delete, resourceId(resource))
+	}
+	for _, resource := range stale {
+		if !applier.dryRun {
+			if err := applier.client.Delete(context.TODO(), resource); err != nil {
+				return util.WrapError(util.CloudError, err, "unable to prune %s", resourceId(resource))
+			}
+		}
+		log.Printf("%s %s", resourceId(resource), applier.describe("pruned"))
+	}
+	return nil
+}
+
+func (applier *Applier) Changes() Changes {
+	return applier.changes
+}
+
+func (applier *Applier) describe(action string) string {
+	if applier.dryRun {
+		return "will be " + action
+	}
+	return "was " + action
+}
+
+func resourceId(resource k8s.Resource) string {
+	metadata := resource.GetMetadata()
+	if metadata.GetNamespace() == "" {
+		return fmt.Sprintf("%s %s", reflect.TypeOf(resource).Elem().Name(), metadata.GetName())
+	}
+	return fmt.Sprintf("%s %s/%s", reflect.TypeOf(resource).Elem().Name(), metadata.GetNamespace(), metadata.GetName())
+}
+
+// Every generated list type holds its objects in an Items slice of pointers.
+func listItems(list k8s.ResourceList) []k8s.Resource {
+	items := reflect.ValueOf(list).Elem().FieldByName("Items")
+	resources := make([]k8s.Resource, items.Len())
+	for i := range resources {
+		resources[i] = items.Index(i).Interface().(k8s.Resource)
+	}
+	return resources
+}
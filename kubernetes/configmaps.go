@@ -3,20 +3,75 @@ package kubernetes
 import (
 	"github.com/ericchiang/k8s/apis/core/v1"
 	v12 "github.com/ericchiang/k8s/apis/meta/v1"
+	"github.com/infinityworks/fk-infra/model"
 	"github.com/infinityworks/fk-infra/terraform"
 	"github.com/infinityworks/fk-infra/util"
 )
 
-func ApplyConfigMaps(outputs terraform.Outputs) {
-	for _, elasticSearchConfig := range outputs.ElasticSearchConfig() {
-		CreateOrUpdate(&v1.ConfigMap{
+// ApplyConfigMaps writes the endpoint of each ElasticSearch domain into a ConfigMap in each of its namespaces,
+// and the details of each queue into a ConfigMap named <queue>-queue in the default namespace.
+func ApplyConfigMaps(applier *Applier, elasticSearchDomains []model.ElasticSearch, outputs terraform.Outputs) error {
+	elasticSearchConfigs, err := outputs.ElasticSearchConfig()
+	if err != nil {
+		return err
+	}
+	for _, elasticSearchConfig := range elasticSearchConfigs {
+		configMap := elasticSearchConfigMap(elasticSearchDomains, elasticSearchConfig.Name)
+		namespaces, err := objectNamespaces(applier.client, configMap)
+		if err != nil {
+			return err
+		}
+		for _, namespace := range namespaces {
+			if err := applier.Apply(componentElasticSearch, &v1.ConfigMap{
+				Metadata: &v12.ObjectMeta{
+					Name:      util.String(objectName(configMap, elasticSearchConfig.Name)),
+					Namespace: util.String(namespace),
+				},
+				Data: map[string]string{
+					objectKey(configMap, "endpoint"): elasticSearchConfig.Endpoint,
+				},
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	queueConfigs, err := outputs.QueueConfig()
+	if err != nil {
+		return err
+	}
+	for _, queueConfig := range queueConfigs {
+		data := map[string]string{
+			"arn": queueConfig.Arn,
+		}
+		if queueConfig.Url != "" {
+			data["url"] = queueConfig.Url
+			data["dead-letter-url"] = queueConfig.DeadLetterUrl
+			data["dead-letter-arn"] = queueConfig.DeadLetterArn
+		}
+		if err := applier.Apply(componentQueue, &v1.ConfigMap{
 			Metadata: &v12.ObjectMeta{
-				Name:      util.String(elasticSearchConfig.Name),
-				Namespace: util.String("default"),
-			},
-			Data: map[string]string{
-				"endpoint": elasticSearchConfig.Endpoint,
+				Name:      util.String(queueConfigMapName(queueConfig.Name)),
+				Namespace: util.String(defaultNamespace),
 			},
-		})
+			Data: data,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queueConfigMapName is suffixed so a queue never shares its ConfigMap with an ElasticSearch domain of the same name.
+func queueConfigMapName(queueName string) string {
+	return queueName + "-queue"
+}
+
+func elasticSearchConfigMap(elasticSearchDomains []model.ElasticSearch, domainName string) *model.KubernetesObject {
+	for _, elasticSearch := range elasticSearchDomains {
+		if elasticSearch.Name == domainName {
+			return elasticSearch.ConfigMap
+		}
 	}
+	return nil
 }
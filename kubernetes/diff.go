@@ -0,0 +1,157 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ericchiang/k8s"
+	"github.com/ericchiang/k8s/apis/core/v1"
+	"github.com/infinityworks/fk-infra/util"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// The data of a ConfigMap or Secret is replaced as a whole so keys that are no longer written are removed,
+// everything else is merged into the live object.
+var replacedFields = map[string]bool{"data": true, "stringData": true, "binaryData": true}
+
+// Values longer than this, such as configuration files, are reported as changed rather than printed.
+const maxDiffValueLength = 80
+
+func newResource(resource k8s.Resource) k8s.Resource {
+	return reflect.New(reflect.TypeOf(resource).Elem()).Interface().(k8s.Resource)
+}
+
+func resourceFields(resource k8s.Resource) (map[string]interface{}, error) {
+	resourceJson, err := json.Marshal(resource)
+	if err != nil {
+		return nil, util.WrapError(util.UnknownError, err, "unable to encode %s", resourceId(resource))
+	}
+	var fields map[string]interface{}
+	err = json.Unmarshal(resourceJson, &fields)
+	return fields, util.WrapError(util.UnknownError, err, "unable to decode %s", resourceId(resource))
+}
+
+func fieldsResource(like k8s.Resource, fields map[string]interface{}) (k8s.Resource, error) {
+	resource := newResource(like)
+	resourceJson, err := json.Marshal(fields)
+	if err != nil {
+		return nil, util.WrapError(util.UnknownError, err, "unable to encode %s", resourceId(like))
+	}
+	err = json.Unmarshal(resourceJson, resource)
+	return resource, util.WrapError(util.UnknownError, err, "unable to decode %s", resourceId(like))
+}
+
+func isSecret(resource k8s.Resource) bool {
+	_, ok := resource.(*v1.Secret)
+	return ok
+}
+
+// DiffFields compares the fields set in desired with those in live, such as a kops spec with the one in its
+// state store, naming each that differs by its path.
+func DiffFields(desired, live map[string]interface{}) []string {
+	return diffFields(desired, live, false)
+}
+
+// diffFields compares the fields the desired object sets with the live ones, ignoring any the cluster fills in.
+// The values of a Secret are never printed.
+func diffFields(desired, live map[string]interface{}, secret bool) []string {
+	var diff []string
+	for _, key := range sortedKeys(desired) {
+		sensitive := secret && replacedFields[key]
+		diff = append(diff, diffValues(key, desired[key], live[key], replacedFields[key], sensitive)...)
+	}
+	return diff
+}
+
+func diffValues(path string, desired, live interface{}, replaced, sensitive bool) []string {
+	switch desired := desired.(type) {
+	case map[string]interface{}:
+		liveMap, ok := live.(map[string]interface{})
+		if !ok {
+			break
+		}
+		var diff []string
+		for _, key := range sortedKeys(desired) {
+			diff = append(diff, diffValues(path+"."+key, desired[key], liveMap[key], false, sensitive)...)
+		}
+		if replaced {
+			for _, key := range sortedKeys(liveMap) {
+				if _, ok := desired[key]; !ok {
+					diff = append(diff, describeChange(path+"."+key, liveMap[key], nil, sensitive))
+				}
+			}
+		}
+		return diff
+	case []interface{}:
+		liveList, ok := live.([]interface{})
+		if !ok || len(liveList) != len(desired) {
+			break
+		}
+		var diff []string
+		for i := range desired {
+			diff = append(diff, diffValues(fmt.Sprintf("%s[%d]", path, i), desired[i], liveList[i], false, sensitive)...)
+		}
+		return diff
+	}
+	if reflect.DeepEqual(desired, live) {
+		return nil
+	}
+	return []string{describeChange(path, live, desired, sensitive)}
+}
+
+func describeChange(path string, from, to interface{}, sensitive bool) string {
+	if sensitive {
+		return fmt.Sprintf("%s: (sensitive value)", path)
+	}
+	fromValue, fromShort := describeValue(from)
+	toValue, toShort := describeValue(to)
+	if !fromShort || !toShort {
+		return fmt.Sprintf("%s: (changed)", path)
+	}
+	return fmt.Sprintf("%s: %s => %s", path, fromValue, toValue)
+}
+
+// describeValue also reports whether the value is short enough to print on one line.
+func describeValue(value interface{}) (string, bool) {
+	if value == nil {
+		return "(unset)", true
+	}
+	valueJson, err := json.Marshal(value)
+	if err != nil {
+		return "", false
+	}
+	return string(valueJson), len(valueJson) <= maxDiffValueLength && !strings.Contains(string(valueJson), `\n`)
+}
+
+// mergeFields sets the desired fields on a copy of the live ones. Lists are taken from the desired object as
+// their items can't be matched up reliably.
+func mergeFields(live, desired map[string]interface{}) map[string]interface{} {
+	return mergeMaps(live, desired, true)
+}
+
+func mergeMaps(live, desired map[string]interface{}, topLevel bool) map[string]interface{} {
+	merged := make(map[string]interface{})
+	for key, value := range live {
+		merged[key] = value
+	}
+	for key, value := range desired {
+		desiredMap, desiredIsMap := value.(map[string]interface{})
+		liveMap, liveIsMap := merged[key].(map[string]interface{})
+		if desiredIsMap && liveIsMap && !(topLevel && replacedFields[key]) {
+			merged[key] = mergeMaps(liveMap, desiredMap, false)
+		} else {
+			merged[key] = value
+		}
+	}
+	return merged
+}
+
+func sortedKeys(fields map[string]interface{}) []string {
+	var keys []string
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
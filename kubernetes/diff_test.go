@@ -0,0 +1,154 @@
+package kubernetes
+
+import (
+	"github.com/ericchiang/k8s"
+	"github.com/ericchiang/k8s/apis/core/v1"
+	v12 "github.com/ericchiang/k8s/apis/meta/v1"
+	"github.com/infinityworks/fk-infra/util"
+	"reflect"
+	"testing"
+)
+
+func configMap(labels, data map[string]string) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		Metadata: &v12.ObjectMeta{
+			Name:      util.String("logs"),
+			Namespace: util.String("default"),
+			Labels:    labels,
+		},
+		Data: data,
+	}
+}
+
+// liveObject adds the fields the API server fills in to a copy of an object.
+func liveObject(resource k8s.Resource) k8s.Resource {
+	fields := fieldsOf(resource)
+	metadata := fields["metadata"].(map[string]interface{})
+	metadata["resourceVersion"] = "42"
+	metadata["uid"] = "6a1c6a3e-5b1e-11e9-8647-d663bd873d93"
+	live, err := fieldsResource(resource, fields)
+	if err != nil {
+		panic(err)
+	}
+	return live
+}
+
+func fieldsOf(resource k8s.Resource) map[string]interface{} {
+	fields, err := resourceFields(resource)
+	if err != nil {
+		panic(err)
+	}
+	return fields
+}
+
+var ownerLabels = map[string]string{environmentLabel: "dev", componentLabel: componentElasticSearch}
+
+func TestDiffAndMergeFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		desired  k8s.Resource
+		live     k8s.Resource
+		wantDiff []string
+		// wantMerged is checked against the fields of the update, which always keeps the resourceVersion
+		wantMerged k8s.Resource
+	}{
+		{
+			name:       "no-op",
+			desired:    configMap(ownerLabels, map[string]string{"endpoint": "logs.es"}),
+			live:       liveObject(configMap(ownerLabels, map[string]string{"endpoint": "logs.es"})),
+			wantMerged: liveObject(configMap(ownerLabels, map[string]string{"endpoint": "logs.es"})),
+		},
+		{
+			name:       "changed field",
+			desired:    configMap(ownerLabels, map[string]string{"endpoint": "new.es"}),
+			live:       liveObject(configMap(ownerLabels, map[string]string{"endpoint": "old.es"})),
+			wantDiff:   []string{`data.endpoint: "old.es" => "new.es"`},
+			wantMerged: liveObject(configMap(ownerLabels, map[string]string{"endpoint": "new.es"})),
+		},
+		{
+			name: "server-defaulted field",
+			desired: &v1.Service{
+				Metadata: &v12.ObjectMeta{Name: util.String("orders-db"), Namespace: util.String("default")},
+				Spec: &v1.ServiceSpec{
+					Type:         util.String("ExternalName"),
+					ExternalName: util.String("orders.rds"),
+				},
+			},
+			live: liveObject(&v1.Service{
+				Metadata: &v12.ObjectMeta{Name: util.String("orders-db"), Namespace: util.String("default")},
+				Spec: &v1.ServiceSpec{
+					Type:            util.String("ExternalName"),
+					ExternalName:    util.String("orders.rds"),
+					SessionAffinity: util.String("None"),
+				},
+			}),
+			wantMerged: liveObject(&v1.Service{
+				Metadata: &v12.ObjectMeta{Name: util.String("orders-db"), Namespace: util.String("default")},
+				Spec: &v1.ServiceSpec{
+					Type:            util.String("ExternalName"),
+					ExternalName:    util.String("orders.rds"),
+					SessionAffinity: util.String("None"),
+				},
+			}),
+		},
+		{
+			name:    "label no longer set is left in place",
+			desired: configMap(ownerLabels, map[string]string{"endpoint": "logs.es"}),
+			live: liveObject(configMap(map[string]string{environmentLabel: "dev", componentLabel: componentElasticSearch, "team": "search"},
+				map[string]string{"endpoint": "logs.es"})),
+			wantMerged: liveObject(configMap(map[string]string{environmentLabel: "dev", componentLabel: componentElasticSearch, "team": "search"},
+				map[string]string{"endpoint": "logs.es"})),
+		},
+		{
+			name:    "changed label",
+			desired: configMap(ownerLabels, map[string]string{"endpoint": "logs.es"}),
+			live: liveObject(configMap(map[string]string{environmentLabel: "dev", componentLabel: componentQueue},
+				map[string]string{"endpoint": "logs.es"})),
+			wantDiff:   []string{`metadata.labels.fk-infra/component: "queue" => "elasticsearch"`},
+			wantMerged: liveObject(configMap(ownerLabels, map[string]string{"endpoint": "logs.es"})),
+		},
+		{
+			name:       "data key no longer written is removed",
+			desired:    configMap(ownerLabels, map[string]string{"endpoint": "logs.es"}),
+			live:       liveObject(configMap(ownerLabels, map[string]string{"endpoint": "logs.es", "arn": "arn:aws:es:logs"})),
+			wantDiff:   []string{`data.arn: "arn:aws:es:logs" => (unset)`},
+			wantMerged: liveObject(configMap(ownerLabels, map[string]string{"endpoint": "logs.es"})),
+		},
+		{
+			name: "secret values are never printed",
+			desired: &v1.Secret{
+				Metadata: &v12.ObjectMeta{Name: util.String("orders-db"), Namespace: util.String("default")},
+				Data:     map[string][]byte{"password": []byte("new"), "schema": []byte("orders")},
+			},
+			live: liveObject(&v1.Secret{
+				Metadata: &v12.ObjectMeta{Name: util.String("orders-db"), Namespace: util.String("default")},
+				Data:     map[string][]byte{"password": []byte("old"), "schema": []byte("orders"), "port": []byte("3306")},
+			}),
+			wantDiff: []string{"data.password: (sensitive value)", "data.port: (sensitive value)"},
+			wantMerged: liveObject(&v1.Secret{
+				Metadata: &v12.ObjectMeta{Name: util.String("orders-db"), Namespace: util.String("default")},
+				Data:     map[string][]byte{"password": []byte("new"), "schema": []byte("orders")},
+			}),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			desiredFields, liveFields := fieldsOf(test.desired), fieldsOf(test.live)
+			diff := diffFields(desiredFields, liveFields, isSecret(test.desired))
+			if !reflect.DeepEqual(diff, test.wantDiff) {
+				t.Errorf("expected diff %q, got %q", test.wantDiff, diff)
+			}
+			merged := mergeFields(liveFields, desiredFields)
+			if wantMerged := fieldsOf(test.wantMerged); !reflect.DeepEqual(merged, wantMerged) {
+				t.Errorf("expected merged fields %v, got %v", wantMerged, merged)
+			}
+		})
+	}
+}
+
+func TestDescribeChangeOfLongValues(t *testing.T) {
+	longValue := "[INPUT]\n    Name tail\n"
+	if got := describeChange("data.fluent-bit.conf", longValue, "[INPUT]\n    Name systemd\n", false); got != "data.fluent-bit.conf: (changed)" {
+		t.Errorf("expected a multi line value to be reported as changed, got %q", got)
+	}
+}
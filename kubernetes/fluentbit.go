@@ -2,274 +2,571 @@ package kubernetes
 
 import (
 	"fmt"
+	"github.com/ericchiang/k8s"
 	v12 "github.com/ericchiang/k8s/apis/apps/v1"
 	"github.com/ericchiang/k8s/apis/core/v1"
+	v14 "github.com/ericchiang/k8s/apis/meta/v1"
 	v13 "github.com/ericchiang/k8s/apis/rbac/v1"
-	"github.com/ghodss/yaml"
+	"github.com/ericchiang/k8s/apis/resource"
+	"github.com/infinityworks/fk-infra/model"
 	"github.com/infinityworks/fk-infra/util"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
-const fluentBitTemplate = `
-apiVersion: v1
-kind: Namespace
-metadata:
-  name: logging
-
----
-
-apiVersion: apps/v1
-kind: DaemonSet
-metadata:
-  labels:
-    k8s-app: fluent-bit-logging
-    kubernetes.io/cluster-service: "true"
-    version: v1
-  name: fluent-bit
-  namespace: logging
-spec:
-  revisionHistoryLimit: 10
-  selector:
-    matchLabels:
-      name: fluent-bit-logging
-  template:
-    metadata:
-      annotations:
-        prometheus.io/path: /api/v1/metrics/prometheus
-        prometheus.io/port: "2020"
-        prometheus.io/scrape: "true"
-      creationTimestamp: null
-      labels:
-        k8s-app: fluent-bit-logging
-        kubernetes.io/cluster-service: "true"
-        name: fluent-bit-logging
-        version: v1
-    spec:
-      containers:
-      - image: fluent/fluent-bit:1.0.5
-        imagePullPolicy: Always
-        name: fluent-bit
-        resources: {}
-        terminationMessagePath: /dev/termination-log
-        terminationMessagePolicy: File
-        volumeMounts:
-        - mountPath: /var/log
-          name: varlog
-        - mountPath: /var/log/containers
-          name: varlogcontainers
-          readOnly: true
-        - mountPath: /var/lib/docker/containers
-          name: varlibdockercontainers
-        - mountPath: /fluent-bit/etc/
-          name: fluent-bit-config
-      - args:
-        - -target
-        - http://logging
-        image: cllunsford/aws-signing-proxy:latest
-        imagePullPolicy: Always
-        name: aws-signing-proxy
-        resources: {}
-        terminationMessagePath: /dev/termination-log
-        terminationMessagePolicy: File
-      dnsPolicy: ClusterFirst
-      restartPolicy: Always
-      schedulerName: default-scheduler
-      securityContext: {}
-      serviceAccount: fluent-bit
-      serviceAccountName: fluent-bit
-      terminationGracePeriodSeconds: 10
-      tolerations:
-      - effect: NoSchedule
-        key: node-role.kubernetes.io/master
-        operator: Exists
-      - effect: NoExecute
-        operator: Exists
-      - effect: NoSchedule
-        operator: Exists
-      volumes:
-      - name: varlog
-        volumeSource:
-          hostPath:
-            path: /var/log
-      - name: varlogcontainers
-        volumeSource:
-          hostPath:
-            path: /var/log/containers
-      - name: varlibdockercontainers
-        volumeSource:
-          hostPath:
-            path: /var/lib/docker/containers
-      - name: fluent-bit-config
-        volumeSource:
-          configMap:
-            localObjectReference:
-              name: fluent-bit-config
-
----
-
-apiVersion: v1
-kind: ConfigMap
-metadata:
-  name: fluent-bit-config
-  namespace: logging
-  labels:
-    k8s-app: fluent-bit
-data:
-  # Configuration files: server, input, filters and output
-  # ======================================================
-  fluent-bit.conf: |
-    [SERVICE]
-        Flush         1
-        Log_Level     info
-        Daemon        off
-        Parsers_File  parsers.conf
-        HTTP_Server   On
-        HTTP_Listen   0.0.0.0
-        HTTP_Port     2020
-
-    @INCLUDE input-kubernetes.conf
-    @INCLUDE filter-kubernetes.conf
-    @INCLUDE output-elasticsearch.conf
-
-  input-kubernetes.conf: |
-    [INPUT]
-        Name              tail
-        Tag               kube.*
-        Path              /var/log/containers/*.log
-        Parser            docker
-        DB                /var/log/flb_kube.db
-        Mem_Buf_Limit     5MB
-        Skip_Long_Lines   On
-        Refresh_Interval  10
-
-  filter-kubernetes.conf: |
-    [FILTER]
-        Name                kubernetes
-        Match               kube.*
-        Merge_Log           On
-        K8S-Logging.Parser  On
-
-  output-elasticsearch.conf: |
-    [OUTPUT]
-        Name            es
-        Match           *
-        Host            127.0.0.1
-        Port            8080
-        Logstash_Format On
-        Replace_Dots    On
-        Retry_Limit     False
-
-  parsers.conf: |
-    [PARSER]
-        Name   apache
-        Format regex
-        Regex  ^(?<host>[^ ]*) [^ ]* (?<user>[^ ]*) \[(?<time>[^\]]*)\] "(?<method>\S+)(?: +(?<path>[^\"]*?)(?: +\S*)?)?" (?<code>[^ ]*) (?<size>[^ ]*)(?: "(?<referer>[^\"]*)" "(?<agent>[^\"]*)")?$
-        Time_Key time
-        Time_Format %d/%b/%Y:%H:%M:%S %z
-
-    [PARSER]
-        Name   apache2
-        Format regex
-        Regex  ^(?<host>[^ ]*) [^ ]* (?<user>[^ ]*) \[(?<time>[^\]]*)\] "(?<method>\S+)(?: +(?<path>[^ ]*) +\S*)?" (?<code>[^ ]*) (?<size>[^ ]*)(?: "(?<referer>[^\"]*)" "(?<agent>[^\"]*)")?$
-        Time_Key time
-        Time_Format %d/%b/%Y:%H:%M:%S %z
-
-    [PARSER]
-        Name   apache_error
-        Format regex
-        Regex  ^\[[^ ]* (?<time>[^\]]*)\] \[(?<level>[^\]]*)\](?: \[pid (?<pid>[^\]]*)\])?( \[client (?<client>[^\]]*)\])? (?<message>.*)$
-
-    [PARSER]
-        Name   nginx
-        Format regex
-        Regex ^(?<remote>[^ ]*) (?<host>[^ ]*) (?<user>[^ ]*) \[(?<time>[^\]]*)\] "(?<method>\S+)(?: +(?<path>[^\"]*?)(?: +\S*)?)?" (?<code>[^ ]*) (?<size>[^ ]*)(?: "(?<referer>[^\"]*)" "(?<agent>[^\"]*)")?$
-        Time_Key time
-        Time_Format %d/%b/%Y:%H:%M:%S %z
-
-    [PARSER]
-        Name   json
-        Format json
-        Time_Key time
-        Time_Format %d/%b/%Y:%H:%M:%S %z
-
-    [PARSER]
-        Name        docker
-        Format      json
-        Time_Key    time
-        Time_Format %Y-%m-%dT%H:%M:%S.%L
-        Time_Keep   On
-        # Command      |  Decoder | Field | Optional Action
-        # =============|==================|=================
-        Decode_Field_As   escaped    log
-
-    [PARSER]
-        Name        syslog
-        Format      regex
-        Regex       ^\<(?<pri>[0-9]+)\>(?<time>[^ ]* {1,2}[^ ]* [^ ]*) (?<host>[^ ]*) (?<ident>[a-zA-Z0-9_\/\.\-]*)(?:\[(?<pid>[0-9]+)\])?(?:[^\:]*\:)? *(?<message>.*)$
-        Time_Key    time
-        Time_Format %b %d %H:%M:%S
-
----
-
-apiVersion: v1
-kind: ServiceAccount
-metadata:
-  name: fluent-bit
-  namespace: logging
-
----
-
-apiVersion: rbac.authorization.k8s.io/v1beta1
-kind: ClusterRole
-metadata:
-  name: fluent-bit-read
-rules:
-- apiGroups: [""]
-  resources:
-  - namespaces
-  - pods
-  verbs: ["get", "list", "watch"]
-
----
-
-apiVersion: rbac.authorization.k8s.io/v1beta1
-kind: ClusterRoleBinding
-metadata:
-  name: fluent-bit-read
-roleRef:
-  apiGroup: rbac.authorization.k8s.io
-  kind: ClusterRole
-  name: fluent-bit-read
-subjects:
-- kind: ServiceAccount
-  name: fluent-bit
-  namespace: logging
+const (
+	defaultFluentBitImage    = "fluent/fluent-bit:1.8.15"
+	defaultMemoryBufferLimit = "5MB"
+	defaultLogLevel          = "info"
+
+	fluentBitNamespace     = "logging"
+	fluentBitName          = "fluent-bit"
+	fluentBitDaemonSetName = "fluent-bit"
+	fluentBitAppLabel      = "fluent-bit-logging"
+	fluentBitClusterRole   = "fluent-bit-read"
+	fluentBitConfigMap     = "fluent-bit-config"
+	fluentBitConfigFile    = "fluent-bit.conf"
+	fluentBitConfigPath    = "/fluent-bit/etc/"
+	fluentBitMetricsPort   = 2020
+	defaultForwardPort     = 24224
+	containerLogDirectory  = "/var/log/containers"
+)
+
+var (
+	imagePattern                = regexp.MustCompile(`^[a-z0-9]+([._/:@-][A-Za-z0-9_]+)*$`)
+	quantityPattern             = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?(m|k|Ki|M|Mi|G|Gi|T|Ti)?$`)
+	fluentBitSizePattern        = regexp.MustCompile(`^[0-9]+(K|KB|M|MB|G|GB)?$`)
+	fluentBitLogLevels          = []string{"off", "error", "warn", "info", "debug", "trace"}
+	resourceNames               = []string{"cpu", "memory"}
+	tolerationOperators         = []string{"", "Exists", "Equal"}
+	tolerationEffects           = []string{"", "NoSchedule", "PreferNoSchedule", "NoExecute"}
+	tolerationKeyPattern        = regexp.MustCompile(`^([a-z0-9]([-a-z0-9.]*[a-z0-9])?/)?[A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?$`)
+	tolerationValuePattern      = regexp.MustCompile(`^([A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?)?$`)
+	defaultFluentBitTolerations = []model.Toleration{
+		{Key: "node-role.kubernetes.io/master", Operator: "Exists", Effect: "NoSchedule"},
+		{Operator: "Exists", Effect: "NoExecute"},
+		{Operator: "Exists", Effect: "NoSchedule"},
+	}
+)
+
+// Host directories fluent-bit reads container logs from, it keeps its own position in /var/log.
+var fluentBitHostPaths = []struct {
+	name, path string
+	readOnly   bool
+}{
+	{"varlog", "/var/log", false},
+	{"varlogcontainers", containerLogDirectory, true},
+	{"varlibdockercontainers", "/var/lib/docker/containers", true},
+}
+
+const fluentBitParsers = `[PARSER]
+    Name   apache
+    Format regex
+    Regex  ^(?<host>[^ ]*) [^ ]* (?<user>[^ ]*) \[(?<time>[^\]]*)\] "(?<method>\S+)(?: +(?<path>[^\"]*?)(?: +\S*)?)?" (?<code>[^ ]*) (?<size>[^ ]*)(?: "(?<referer>[^\"]*)" "(?<agent>[^\"]*)")?$
+    Time_Key time
+    Time_Format %d/%b/%Y:%H:%M:%S %z
+
+[PARSER]
+    Name   apache2
+    Format regex
+    Regex  ^(?<host>[^ ]*) [^ ]* (?<user>[^ ]*) \[(?<time>[^\]]*)\] "(?<method>\S+)(?: +(?<path>[^ ]*) +\S*)?" (?<code>[^ ]*) (?<size>[^ ]*)(?: "(?<referer>[^\"]*)" "(?<agent>[^\"]*)")?$
+    Time_Key time
+    Time_Format %d/%b/%Y:%H:%M:%S %z
+
+[PARSER]
+    Name   apache_error
+    Format regex
+    Regex  ^\[[^ ]* (?<time>[^\]]*)\] \[(?<level>[^\]]*)\](?: \[pid (?<pid>[^\]]*)\])?( \[client (?<client>[^\]]*)\])? (?<message>.*)$
+
+[PARSER]
+    Name   nginx
+    Format regex
+    Regex ^(?<remote>[^ ]*) (?<host>[^ ]*) (?<user>[^ ]*) \[(?<time>[^\]]*)\] "(?<method>\S+)(?: +(?<path>[^\"]*?)(?: +\S*)?)?" (?<code>[^ ]*) (?<size>[^ ]*)(?: "(?<referer>[^\"]*)" "(?<agent>[^\"]*)")?$
+    Time_Key time
+    Time_Format %d/%b/%Y:%H:%M:%S %z
+
+[PARSER]
+    Name   json
+    Format json
+    Time_Key time
+    Time_Format %d/%b/%Y:%H:%M:%S %z
+
+[PARSER]
+    Name        docker
+    Format      json
+    Time_Key    time
+    Time_Format %Y-%m-%dT%H:%M:%S.%L
+    Time_Keep   On
+    # Command      |  Decoder | Field | Optional Action
+    # =============|==================|=================
+    Decode_Field_As   escaped    log
+
+[PARSER]
+    Name        syslog
+    Format      regex
+    Regex       ^\<(?<pri>[0-9]+)\>(?<time>[^ ]* {1,2}[^ ]* [^ ]*) (?<host>[^ ]*) (?<ident>[a-zA-Z0-9_\/\.\-]*)(?:\[(?<pid>[0-9]+)\])?(?:[^\:]*\:)? *(?<message>.*)$
+    Time_Key    time
+    Time_Format %b %d %H:%M:%S
 `
 
-func ApplyFluentBitLogging(elasticsearchEndpoint, region string) {
-	documentItems := strings.Split(fluentBitTemplate, "---")
-
-	var namespace v1.Namespace
-	var daemonSet v12.DaemonSet
-	var configMap v1.ConfigMap
-	var serviceAccount v1.ServiceAccount
-	var clusterRole v13.ClusterRole
-	var clusterRoleBinding v13.ClusterRoleBinding
-
-	util.CheckError(yaml.Unmarshal([]byte(documentItems[0]), &namespace))
-	util.CheckError(yaml.Unmarshal([]byte(documentItems[1]), &daemonSet))
-	util.CheckError(yaml.Unmarshal([]byte(documentItems[2]), &configMap))
-	util.CheckError(yaml.Unmarshal([]byte(documentItems[3]), &serviceAccount))
-	util.CheckError(yaml.Unmarshal([]byte(documentItems[4]), &clusterRole))
-	util.CheckError(yaml.Unmarshal([]byte(documentItems[5]), &clusterRoleBinding))
-
-	daemonSet.Spec.Template.Spec.Containers[1].Args[1] = fmt.Sprintf("https://%s", elasticsearchEndpoint)
-	daemonSet.Spec.Template.Spec.Containers[1].Env = []*v1.EnvVar{{Name: util.String("AWS_REGION"), Value: util.String(region)}}
-
-	CreateOrUpdate(&namespace)
-	CreateOrUpdate(&daemonSet)
-	CreateOrUpdate(&configMap)
-	CreateOrUpdate(&serviceAccount)
-	CreateOrUpdate(&clusterRole)
-	CreateOrUpdate(&clusterRoleBinding)
+// LogOutput is one of the places the logs of a cluster are shipped to, along with the endpoint of the
+// ElasticSearch domain when it is one.
+type LogOutput struct {
+	model.LogOutput
+	ElasticSearchEndpoint string
+}
+
+// ValidateFluentBit checks the fluent-bit settings of a cluster, nil settings keep every default.
+func ValidateFluentBit(clusterName string, settings *model.FluentBit) error {
+	if settings == nil {
+		return nil
+	}
+	owner := fmt.Sprintf("fluent-bit of cluster %s", clusterName)
+	if settings.Image != "" && !imagePattern.MatchString(settings.Image) {
+		return util.NewError(util.ConfigError, "%s has image %q, expected a reference such as %s", owner, settings.Image, defaultFluentBitImage)
+	}
+	if settings.Image != "" && !PinnedImage(settings.Image) {
+		return util.NewError(util.ConfigError, "%s has image %q, expected a tag other than latest or a digest so rollouts can be repeated", owner, settings.Image)
+	}
+	if settings.Resources != nil {
+		for _, quantities := range []map[string]string{settings.Resources.Requests, settings.Resources.Limits} {
+			for name, quantity := range quantities {
+				if !contains(resourceNames, name) {
+					return util.NewError(util.ConfigError, "%s has resource %s, expected one of %v", owner, name, resourceNames)
+				}
+				if !quantityPattern.MatchString(quantity) {
+					return util.NewError(util.ConfigError, "%s has %s quantity %q, expected a number with an optional unit such as 100m or 64Mi", owner, name, quantity)
+				}
+			}
+		}
+	}
+	if settings.MemoryBufferLimit != "" && !fluentBitSizePattern.MatchString(settings.MemoryBufferLimit) {
+		return util.NewError(util.ConfigError, "%s has memory-buffer-limit %q, expected a size such as 5MB", owner, settings.MemoryBufferLimit)
+	}
+	if settings.LogLevel != "" && !contains(fluentBitLogLevels, settings.LogLevel) {
+		return util.NewError(util.ConfigError, "%s has log-level %q, expected one of %v", owner, settings.LogLevel, fluentBitLogLevels)
+	}
+	for _, namespace := range settings.ExcludeNamespaces {
+		if len(namespace) > 63 || !namespaceNamePattern.MatchString(namespace) {
+			return util.NewError(util.ConfigError, "%s excludes namespace %q, expected lower case letters, digits and dashes", owner, namespace)
+		}
+	}
+	for _, toleration := range settings.Tolerations {
+		if err := validateToleration(owner, toleration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateToleration(owner string, toleration model.Toleration) error {
+	if !contains(tolerationOperators, toleration.Operator) {
+		return util.NewError(util.ConfigError, "%s has toleration operator %q, expected Exists or Equal", owner, toleration.Operator)
+	}
+	if !contains(tolerationEffects, toleration.Effect) {
+		return util.NewError(util.ConfigError, "%s has toleration effect %q, expected one of NoSchedule, PreferNoSchedule or NoExecute", owner, toleration.Effect)
+	}
+	if toleration.Key != "" && !tolerationKeyPattern.MatchString(toleration.Key) {
+		return util.NewError(util.ConfigError, "%s has toleration key %q, expected a label key", owner, toleration.Key)
+	}
+	if !tolerationValuePattern.MatchString(toleration.Value) {
+		return util.NewError(util.ConfigError, "%s has toleration value %q, expected a label value", owner, toleration.Value)
+	}
+	if toleration.Operator == "Exists" && toleration.Value != "" {
+		return util.NewError(util.ConfigError, "%s has a toleration for key %s with operator Exists and a value", owner, toleration.Key)
+	}
+	if toleration.Operator != "Exists" && toleration.Key == "" {
+		return util.NewError(util.ConfigError, "%s has a toleration without a key, which needs operator Exists", owner)
+	}
+	return nil
+}
+
+// ApplyFluentBitLogging ships the logs of every pod to each of the outputs. fluent-bit signs the requests to
+// ElasticSearch, CloudWatch and S3 itself with the role of the node it runs on.
+func ApplyFluentBitLogging(applier *Applier, clusterName, region string, settings *model.FluentBit, logOutputs []LogOutput) error {
+	if err := ValidateFluentBit(clusterName, settings); err != nil {
+		return err
+	}
+	manifest, err := newFluentBitManifest(clusterName, region, fluentBitDefaults(settings), logOutputs)
+	if err != nil {
+		return err
+	}
+	for _, resource := range manifest.resources() {
+		if err := applier.Apply(componentLogging, resource); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PinnedImage is true for an image reference with a digest or a tag other than latest.
+func PinnedImage(image string) bool {
+	if strings.Contains(image, "@") {
+		return true
+	}
+	name := image[strings.LastIndex(image, "/")+1:]
+	tagStart := strings.LastIndex(name, ":")
+	return tagStart >= 0 && name[tagStart+1:] != "latest"
+}
+
+// fluentBitDefaults fills in whatever the settings leave out.
+func fluentBitDefaults(settings *model.FluentBit) model.FluentBit {
+	var withDefaults model.FluentBit
+	if settings != nil {
+		withDefaults = *settings
+	}
+	if withDefaults.Image == "" {
+		withDefaults.Image = defaultFluentBitImage
+	}
+	if withDefaults.MemoryBufferLimit == "" {
+		withDefaults.MemoryBufferLimit = defaultMemoryBufferLimit
+	}
+	if withDefaults.LogLevel == "" {
+		withDefaults.LogLevel = defaultLogLevel
+	}
+	if len(withDefaults.Tolerations) == 0 {
+		withDefaults.Tolerations = defaultFluentBitTolerations
+	}
+	return withDefaults
+}
+
+// fluentBitManifest is everything the add-on needs, in the order it is applied.
+type fluentBitManifest struct {
+	namespace          *v1.Namespace
+	serviceAccount     *v1.ServiceAccount
+	clusterRole        *v13.ClusterRole
+	clusterRoleBinding *v13.ClusterRoleBinding
+	configMap          *v1.ConfigMap
+	daemonSet          *v12.DaemonSet
+}
+
+func (manifest fluentBitManifest) resources() []k8s.Resource {
+	return []k8s.Resource{manifest.namespace, manifest.serviceAccount, manifest.clusterRole, manifest.clusterRoleBinding, manifest.configMap, manifest.daemonSet}
+}
+
+func newFluentBitManifest(clusterName, region string, settings model.FluentBit, logOutputs []LogOutput) (fluentBitManifest, error) {
+	configFiles, err := fluentBitConfigFiles(clusterName, region, settings, logOutputs)
+	if err != nil {
+		return fluentBitManifest{}, err
+	}
+
+	return fluentBitManifest{
+		namespace: &v1.Namespace{
+			Metadata: &v14.ObjectMeta{Name: util.String(fluentBitNamespace)},
+		},
+		serviceAccount: &v1.ServiceAccount{
+			Metadata: fluentBitMetadata(fluentBitName),
+		},
+		clusterRole: &v13.ClusterRole{
+			Metadata: &v14.ObjectMeta{Name: util.String(fluentBitClusterRole)},
+			Rules: []*v13.PolicyRule{{
+				ApiGroups: []string{""},
+				Resources: []string{"namespaces", "pods"},
+				Verbs:     []string{"get", "list", "watch"},
+			}},
+		},
+		clusterRoleBinding: &v13.ClusterRoleBinding{
+			Metadata: &v14.ObjectMeta{Name: util.String(fluentBitClusterRole)},
+			RoleRef: &v13.RoleRef{
+				ApiGroup: util.String("rbac.authorization.k8s.io"),
+				Kind:     util.String("ClusterRole"),
+				Name:     util.String(fluentBitClusterRole),
+			},
+			Subjects: []*v13.Subject{{
+				Kind:      util.String("ServiceAccount"),
+				Name:      util.String(fluentBitName),
+				Namespace: util.String(fluentBitNamespace),
+			}},
+		},
+		configMap: &v1.ConfigMap{
+			Metadata: fluentBitMetadata(fluentBitConfigMap),
+			Data:     configFiles,
+		},
+		daemonSet: &v12.DaemonSet{
+			Metadata: fluentBitMetadata(fluentBitDaemonSetName),
+			Spec: &v12.DaemonSetSpec{
+				Selector: &v14.LabelSelector{MatchLabels: map[string]string{"name": fluentBitAppLabel}},
+				Template: &v1.PodTemplateSpec{
+					Metadata: &v14.ObjectMeta{
+						Labels: map[string]string{"name": fluentBitAppLabel, "k8s-app": fluentBitAppLabel},
+						Annotations: map[string]string{
+							"prometheus.io/path":   "/api/v1/metrics/prometheus",
+							"prometheus.io/port":   strconv.Itoa(fluentBitMetricsPort),
+							"prometheus.io/scrape": "true",
+						},
+					},
+					Spec: &v1.PodSpec{
+						Containers: []*v1.Container{{
+							Name:         util.String(fluentBitName),
+							Image:        util.String(settings.Image),
+							Resources:    resourceRequirements(settings.Resources),
+							VolumeMounts: fluentBitVolumeMounts(),
+						}},
+						ServiceAccountName:            util.String(fluentBitName),
+						TerminationGracePeriodSeconds: util.Int64(10),
+						Tolerations:                   tolerations(settings.Tolerations),
+						Volumes:                       fluentBitVolumes(),
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+func fluentBitMetadata(name string) *v14.ObjectMeta {
+	return &v14.ObjectMeta{
+		Name:      util.String(name),
+		Namespace: util.String(fluentBitNamespace),
+		Labels:    map[string]string{"k8s-app": fluentBitAppLabel},
+	}
+}
+
+func fluentBitVolumeMounts() []*v1.VolumeMount {
+	var volumeMounts []*v1.VolumeMount
+	for _, hostPath := range fluentBitHostPaths {
+		volumeMount := &v1.VolumeMount{
+			Name:      util.String(hostPath.name),
+			MountPath: util.String(hostPath.path),
+		}
+		if hostPath.readOnly {
+			volumeMount.ReadOnly = util.Bool(true)
+		}
+		volumeMounts = append(volumeMounts, volumeMount)
+	}
+	return append(volumeMounts, &v1.VolumeMount{
+		Name:      util.String(fluentBitConfigMap),
+		MountPath: util.String(fluentBitConfigPath),
+	})
+}
+
+func fluentBitVolumes() []*v1.Volume {
+	var volumes []*v1.Volume
+	for _, hostPath := range fluentBitHostPaths {
+		volumes = append(volumes, &v1.Volume{
+			Name: util.String(hostPath.name),
+			VolumeSource: &v1.VolumeSource{
+				HostPath: &v1.HostPathVolumeSource{Path: util.String(hostPath.path)},
+			},
+		})
+	}
+	return append(volumes, &v1.Volume{
+		Name: util.String(fluentBitConfigMap),
+		VolumeSource: &v1.VolumeSource{
+			ConfigMap: &v1.ConfigMapVolumeSource{
+				LocalObjectReference: &v1.LocalObjectReference{Name: util.String(fluentBitConfigMap)},
+			},
+		},
+	})
+}
+
+func resourceRequirements(resources *model.Resources) *v1.ResourceRequirements {
+	requirements := &v1.ResourceRequirements{}
+	if resources == nil {
+		return requirements
+	}
+	if len(resources.Requests) > 0 {
+		requirements.Requests = quantities(resources.Requests)
+	}
+	if len(resources.Limits) > 0 {
+		requirements.Limits = quantities(resources.Limits)
+	}
+	return requirements
+}
+
+func quantities(values map[string]string) map[string]*resource.Quantity {
+	quantities := make(map[string]*resource.Quantity)
+	for name, value := range values {
+		quantities[name] = &resource.Quantity{String_: util.String(value)}
+	}
+	return quantities
+}
+
+func tolerations(modelTolerations []model.Toleration) []*v1.Toleration {
+	var tolerations []*v1.Toleration
+	for _, toleration := range modelTolerations {
+		tolerations = append(tolerations, &v1.Toleration{
+			Key:      optionalString(toleration.Key),
+			Operator: optionalString(toleration.Operator),
+			Value:    optionalString(toleration.Value),
+			Effect:   optionalString(toleration.Effect),
+		})
+	}
+	return tolerations
+}
+
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return util.String(value)
+}
+
+// fluentBitConfigFiles renders the configuration, with outputs of the same type sharing a file.
+func fluentBitConfigFiles(clusterName, region string, settings model.FluentBit, logOutputs []LogOutput) (map[string]string, error) {
+	input := []string{
+		"Name", "tail",
+		"Tag", "kube.*",
+		"Path", containerLogDirectory + "/*.log",
+		"Parser", "docker",
+		"DB", "/var/log/flb_kube.db",
+		"Mem_Buf_Limit", settings.MemoryBufferLimit,
+		"Skip_Long_Lines", "On",
+		"Refresh_Interval", "10",
+	}
+	if len(settings.ExcludeNamespaces) > 0 {
+		// Container log files are named <pod>_<namespace>_<container>-<id>.log
+		var excludePaths []string
+		for _, namespace := range settings.ExcludeNamespaces {
+			excludePaths = append(excludePaths, fmt.Sprintf("%s/*_%s_*.log", containerLogDirectory, namespace))
+		}
+		input = append(input, "Exclude_Path", strings.Join(excludePaths, ","))
+	}
+
+	configFiles := map[string]string{
+		"input-kubernetes.conf": configSection("INPUT", input...),
+		"filter-kubernetes.conf": configSection("FILTER",
+			"Name", "kubernetes",
+			"Match", "kube.*",
+			"Merge_Log", "On",
+			"K8S-Logging.Parser", "On"),
+		"parsers.conf": fluentBitParsers,
+	}
+	includes := []string{"input-kubernetes.conf", "filter-kubernetes.conf"}
+
+	for _, logOutput := range logOutputs {
+		var section string
+		switch logOutput.Type {
+		case model.LogOutputElasticSearch:
+			section = elasticSearchOutput(logOutput.ElasticSearchEndpoint, region)
+		case model.LogOutputCloudWatch:
+			section = cloudWatchOutput(logOutput.LogOutput, clusterName, region)
+		case model.LogOutputS3:
+			section = s3Output(logOutput.LogOutput, region)
+		case model.LogOutputHttp:
+			section = httpOutput(logOutput.LogOutput)
+		case model.LogOutputForward:
+			section = forwardOutput(logOutput.LogOutput)
+		default:
+			return nil, util.NewError(util.ConfigError, "cluster %s has log output of unknown type %s", clusterName, logOutput.Type)
+		}
+
+		fileName := fmt.Sprintf("output-%s.conf", logOutput.Type)
+		if _, ok := configFiles[fileName]; ok {
+			configFiles[fileName] += "\n" + section
+		} else {
+			configFiles[fileName] = section
+			includes = append(includes, fileName)
+		}
+	}
+
+	service := configSection("SERVICE",
+		"Flush", "1",
+		"Log_Level", settings.LogLevel,
+		"Daemon", "off",
+		"Parsers_File", "parsers.conf",
+		"HTTP_Server", "On",
+		"HTTP_Listen", "0.0.0.0",
+		"HTTP_Port", strconv.Itoa(fluentBitMetricsPort))
+	var includeLines []string
+	for _, include := range includes {
+		includeLines = append(includeLines, "@INCLUDE "+include)
+	}
+	configFiles[fluentBitConfigFile] = service + "\n" + strings.Join(includeLines, "\n") + "\n"
+	return configFiles, nil
+}
+
+// ElasticSearch domains only accept requests signed with AWS credentials, over TLS.
+func elasticSearchOutput(endpoint, region string) string {
+	return configSection("OUTPUT",
+		"Name", "es",
+		"Match", "*",
+		"Host", endpoint,
+		"Port", strconv.Itoa(elasticSearchPort),
+		"tls", "On",
+		"AWS_Auth", "On",
+		"AWS_Region", region,
+		"Logstash_Format", "On",
+		"Replace_Dots", "On",
+		"Retry_Limit", "False")
+}
+
+// Each container logs to its own stream, named after the cluster and the tag fluent-bit reads it with.
+func cloudWatchOutput(logOutput model.LogOutput, clusterName, region string) string {
+	return configSection("OUTPUT",
+		"Name", "cloudwatch_logs",
+		"Match", "*",
+		"region", region,
+		"log_group_name", logOutput.LogGroup,
+		"log_stream_prefix", clusterName+".",
+		"auto_create_group", "On")
+}
+
+func s3Output(logOutput model.LogOutput, region string) string {
+	return configSection("OUTPUT",
+		"Name", "s3",
+		"Match", "*",
+		"region", region,
+		"bucket", logOutput.Bucket,
+		"s3_key_format", fmt.Sprintf("/%s/$TAG/%%Y/%%m/%%d/%%H/%%M/%%S", logOutput.Prefix),
+		"total_file_size", "50M",
+		"upload_timeout", "10m")
+}
+
+func httpOutput(logOutput model.LogOutput) string {
+	port := logOutput.Port
+	if port == 0 && logOutput.Tls {
+		port = 443
+	} else if port == 0 {
+		port = 80
+	}
+	uri := logOutput.Uri
+	if uri == "" {
+		uri = "/"
+	}
+	return configSection("OUTPUT",
+		"Name", "http",
+		"Match", "*",
+		"Host", logOutput.Host,
+		"Port", strconv.Itoa(port),
+		"URI", uri,
+		"Format", "json",
+		"tls", onOff(logOutput.Tls))
+}
+
+func forwardOutput(logOutput model.LogOutput) string {
+	port := logOutput.Port
+	if port == 0 {
+		port = defaultForwardPort
+	}
+	return configSection("OUTPUT",
+		"Name", "forward",
+		"Match", "*",
+		"Host", logOutput.Host,
+		"Port", strconv.Itoa(port),
+		"tls", onOff(logOutput.Tls))
+}
+
+// configSection writes a section of fluent-bit configuration from pairs of keys and values, lined up the way
+// fluent-bit's own examples are.
+func configSection(name string, keysAndValues ...string) string {
+	width := 0
+	for i := 0; i < len(keysAndValues); i += 2 {
+		if len(keysAndValues[i]) > width {
+			width = len(keysAndValues[i])
+		}
+	}
+	var section strings.Builder
+	fmt.Fprintf(&section, "[%s]\n", name)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		fmt.Fprintf(&section, "    %-*s %s\n", width, keysAndValues[i], keysAndValues[i+1])
+	}
+	return section.String()
+}
+
+func onOff(value bool) string {
+	if value {
+		return "On"
+	}
+	return "Off"
 }
@@ -0,0 +1,66 @@
+package kubernetes
+
+import (
+	"github.com/infinityworks/fk-infra/model"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestPinnedImage(t *testing.T) {
+	tests := []struct {
+		image string
+		want  bool
+	}{
+		{image: "fluent/fluent-bit:1.8.15", want: true},
+		{image: "registry.example.com:5000/fluent-bit:1.8.15", want: true},
+		{image: "fluent-bit@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", want: true},
+		{image: "fluent/fluent-bit", want: false},
+		{image: "fluent/fluent-bit:latest", want: false},
+		{image: "registry.example.com:5000/fluent-bit", want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.image, func(t *testing.T) {
+			if got := PinnedImage(test.image); got != test.want {
+				t.Errorf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestValidateFluentBitRefusesUnpinnedImages(t *testing.T) {
+	err := ValidateFluentBit("dev", &model.FluentBit{Image: "fluent/fluent-bit:latest"})
+	if err == nil || !strings.Contains(err.Error(), `has image "fluent/fluent-bit:latest", expected a tag other than latest or a digest`) {
+		t.Fatalf("expected an error about the unpinned image, got %v", err)
+	}
+}
+
+func TestFluentBitSignsElasticSearchRequests(t *testing.T) {
+	logOutputs := []LogOutput{
+		{LogOutput: model.LogOutput{Type: model.LogOutputElasticSearch, ElasticSearchName: "logging"}, ElasticSearchEndpoint: "logging.es.amazonaws.com"},
+		{LogOutput: model.LogOutput{Type: model.LogOutputElasticSearch, ElasticSearchName: "audit"}, ElasticSearchEndpoint: "audit.es.amazonaws.com"},
+	}
+	manifest, err := newFluentBitManifest("dev.k8s.local", "eu-west-1", fluentBitDefaults(nil), logOutputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if containers := manifest.daemonSet.Spec.Template.Spec.Containers; len(containers) != 1 || containers[0].GetImage() != defaultFluentBitImage {
+		t.Errorf("expected fluent-bit alone in the pod with image %s, got %v", defaultFluentBitImage, containers)
+	}
+	output := manifest.configMap.Data["output-elasticsearch.conf"]
+	for _, endpoint := range []string{"logging.es.amazonaws.com", "audit.es.amazonaws.com"} {
+		if settingCount(output, "Host", endpoint) != 1 {
+			t.Errorf("expected an output for %s, got\n%s", endpoint, output)
+		}
+	}
+	for _, setting := range [][2]string{{"Port", "443"}, {"tls", "On"}, {"AWS_Auth", "On"}, {"AWS_Region", "eu-west-1"}} {
+		if settingCount(output, setting[0], setting[1]) != 2 {
+			t.Errorf("expected %s %s in both outputs, got\n%s", setting[0], setting[1], output)
+		}
+	}
+}
+
+func settingCount(section, key, value string) int {
+	return len(regexp.MustCompile(`(?m)^\s+`+regexp.QuoteMeta(key)+`\s+`+regexp.QuoteMeta(value)+`$`).FindAllString(section, -1))
+}
@@ -6,37 +6,70 @@ import (
 	"github.com/ghodss/yaml"
 	"github.com/infinityworks/fk-infra/util"
 	"io/ioutil"
-	"log"
 	"net/http"
-	"os/user"
-	"path/filepath"
-	"reflect"
 )
 
-func CreateOrUpdate(req k8s.Resource) {
-	client := newClient()
-	err := client.Create(context.TODO(), req)
-
-	if apiErr, ok := err.(*k8s.APIError); ok {
-		if apiErr.Code == http.StatusConflict {
-			err = client.Update(context.TODO(), req)
+// CreateOrUpdate compares the object with the one in the cluster, creating it if it doesn't exist and otherwise
+// updating the fields it sets when any differ. The update starts from the live object so its resourceVersion and
+// the fields set by the cluster are kept. In a dry run nothing is written. It returns whether the object is
+// created and the fields that change.
+func CreateOrUpdate(client *k8s.Client, desired k8s.Resource, dryRun bool) (bool, []string, error) {
+	live := newResource(desired)
+	err := client.Get(context.TODO(), desired.GetMetadata().GetNamespace(), desired.GetMetadata().GetName(), live)
+	if apiErr, ok := err.(*k8s.APIError); ok && apiErr.Code == http.StatusNotFound {
+		if dryRun {
+			return true, nil, nil
 		}
+		return true, nil, util.WrapError(util.CloudError, client.Create(context.TODO(), desired), "unable to create %s", resourceId(desired))
+	}
+	if err != nil {
+		return false, nil, util.WrapError(util.CloudError, err, "unable to read %s", resourceId(desired))
+	}
+
+	desiredFields, err := resourceFields(desired)
+	if err != nil {
+		return false, nil, err
+	}
+	liveFields, err := resourceFields(live)
+	if err != nil {
+		return false, nil, err
+	}
+	diff := diffFields(desiredFields, liveFields, isSecret(desired))
+	if len(diff) == 0 || dryRun {
+		return false, diff, nil
 	}
 
+	updated, err := fieldsResource(desired, mergeFields(liveFields, desiredFields))
 	if err != nil {
-		log.Printf("Error applying %s %+v", reflect.TypeOf(req).String(), err)
-	} else {
-		log.Printf("Applied %s %s", reflect.TypeOf(req).String(), *req.GetMetadata().Name)
+		return false, nil, err
 	}
+	return false, diff, util.WrapError(util.CloudError, client.Update(context.TODO(), updated), "unable to update %s", resourceId(desired))
 }
 
-func newClient() *k8s.Client {
-	currentUser, err := user.Current()
-	util.CheckError(err)
-	data, err := ioutil.ReadFile(filepath.Join(currentUser.HomeDir, ".kube", "config"))
+// NewClient talks to the cluster behind the named context rather than whichever context happens to be current,
+// so resources for one environment can't end up in another's cluster.
+func NewClient(kubeConfigFile, contextName string) (*k8s.Client, error) {
+	data, err := ioutil.ReadFile(kubeConfigFile)
+	if err != nil {
+		return nil, util.WrapError(util.ConfigError, err, "unable to read %s", kubeConfigFile)
+	}
 	var config k8s.Config
-	util.CheckError(yaml.Unmarshal(data, &config))
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, util.WrapError(util.ConfigError, err, "invalid kubeconfig %s", kubeConfigFile)
+	}
+	if !hasContext(config, contextName) {
+		return nil, util.NewError(util.ConfigError, "kubeconfig %s has no context %s", kubeConfigFile, contextName)
+	}
+	config.CurrentContext = contextName
 	client, err := k8s.NewClient(&config)
-	util.CheckError(err)
-	return client
+	return client, util.WrapError(util.ConfigError, err, "unable to create kubernetes client for %s from %s", contextName, kubeConfigFile)
+}
+
+func hasContext(config k8s.Config, contextName string) bool {
+	for _, namedContext := range config.Contexts {
+		if namedContext.Name == contextName {
+			return true
+		}
+	}
+	return false
 }
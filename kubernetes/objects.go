@@ -0,0 +1,139 @@
+package kubernetes
+
+import (
+	"context"
+	"github.com/ericchiang/k8s"
+	"github.com/ericchiang/k8s/apis/core/v1"
+	"github.com/infinityworks/fk-infra/model"
+	"github.com/infinityworks/fk-infra/util"
+	"regexp"
+	"sort"
+)
+
+const defaultNamespace = "default"
+
+var (
+	databaseSecretKeys         = []string{"schema", "engine", "port", "endpoint", "password"}
+	elasticSearchConfigMapKeys = []string{"endpoint"}
+
+	objectNamePattern    = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+	namespaceNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+	dataKeyPattern       = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+	serviceNamePattern   = regexp.MustCompile(`^[a-z]([-a-z0-9]*[a-z0-9])?$`)
+)
+
+// ValidateObjects checks the services, Secrets and ConfigMaps written for every database, ElasticSearch
+// domain and queue, so a mistake is reported before anything is applied rather than part way through a cluster.
+func ValidateObjects(spec model.Spec) error {
+	for _, database := range spec.Databases {
+		if err := validateServiceName("database "+database.Name, databaseServiceName(database.Name)); err != nil {
+			return err
+		}
+		if err := validateObject("secret", "database "+database.Name, database.Secret, databaseSecretKeys); err != nil {
+			return err
+		}
+	}
+	for _, elasticSearch := range spec.ElasticSearch {
+		if err := validateServiceName("ElasticSearch "+elasticSearch.Name, elasticSearchServiceName(elasticSearch.Name)); err != nil {
+			return err
+		}
+		if err := validateObject("config-map", "ElasticSearch "+elasticSearch.Name, elasticSearch.ConfigMap, elasticSearchConfigMapKeys); err != nil {
+			return err
+		}
+	}
+	for _, queue := range spec.Queues {
+		if name := queueConfigMapName(queue.Name); len(name) > 253 || !objectNamePattern.MatchString(name) {
+			return util.NewError(util.ConfigError, "queue %s would have config-map %s, expected a name of lower case letters, digits and dashes", queue.Name, name)
+		}
+	}
+	return nil
+}
+
+func validateServiceName(owner, name string) error {
+	if len(name) > 63 || !serviceNamePattern.MatchString(name) {
+		return util.NewError(util.ConfigError, "%s would have service %s, expected a name of lower case letters, digits and dashes starting with a letter", owner, name)
+	}
+	return nil
+}
+
+func validateObject(field, owner string, object *model.KubernetesObject, keys []string) error {
+	if object == nil {
+		return nil
+	}
+	if object.Name != "" && (len(object.Name) > 253 || !objectNamePattern.MatchString(object.Name)) {
+		return util.NewError(util.ConfigError, "%s of %s has name %q, expected lower case letters, digits, dashes and dots", field, owner, object.Name)
+	}
+	for _, namespace := range object.Namespaces {
+		if len(namespace) > 63 || !namespaceNamePattern.MatchString(namespace) {
+			return util.NewError(util.ConfigError, "%s of %s has namespace %q, expected lower case letters, digits and dashes", field, owner, namespace)
+		}
+	}
+
+	renamedKeys := make(map[string]string)
+	for key, renamedKey := range object.Keys {
+		if !contains(keys, key) {
+			return util.NewError(util.ConfigError, "%s of %s renames unknown key %s, expected one of %v", field, owner, key, keys)
+		}
+		if !dataKeyPattern.MatchString(renamedKey) {
+			return util.NewError(util.ConfigError, "%s of %s renames %s to %q, expected letters, digits, dashes, underscores and dots", field, owner, key, renamedKey)
+		}
+		if otherKey, ok := renamedKeys[renamedKey]; ok {
+			return util.NewError(util.ConfigError, "%s of %s renames both %s and %s to %s", field, owner, otherKey, key, renamedKey)
+		}
+		renamedKeys[renamedKey] = key
+	}
+	for _, key := range keys {
+		if _, renamed := object.Keys[key]; !renamed {
+			if otherKey, ok := renamedKeys[key]; ok {
+				return util.NewError(util.ConfigError, "%s of %s renames %s to %s which is already a key", field, owner, otherKey, key)
+			}
+		}
+	}
+	return nil
+}
+
+func objectName(object *model.KubernetesObject, defaultName string) string {
+	if object == nil || object.Name == "" {
+		return defaultName
+	}
+	return object.Name
+}
+
+func objectKey(object *model.KubernetesObject, key string) string {
+	if object == nil || object.Keys[key] == "" {
+		return key
+	}
+	return object.Keys[key]
+}
+
+// objectNamespaces are those listed along with any selected by label, namespaces created after an apply only
+// receive the object on the next one.
+func objectNamespaces(client *k8s.Client, object *model.KubernetesObject) ([]string, error) {
+	if object == nil || (len(object.Namespaces) == 0 && object.NamespaceSelector == "") {
+		return []string{defaultNamespace}, nil
+	}
+
+	namespaces := append([]string{}, object.Namespaces...)
+	if object.NamespaceSelector != "" {
+		var namespaceList v1.NamespaceList
+		if err := client.List(context.TODO(), "", &namespaceList, k8s.QueryParam("labelSelector", object.NamespaceSelector)); err != nil {
+			return nil, util.WrapError(util.CloudError, err, "unable to list namespaces matching %s", object.NamespaceSelector)
+		}
+		for _, namespace := range namespaceList.Items {
+			if name := namespace.GetMetadata().GetName(); !contains(namespaces, name) {
+				namespaces = append(namespaces, name)
+			}
+		}
+	}
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package kubernetes
+
+import (
+	"github.com/infinityworks/fk-infra/model"
+	"strings"
+	"testing"
+)
+
+func TestValidateObjects(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    model.Spec
+		wantErr string
+	}{
+		{
+			name: "valid names",
+			spec: model.Spec{
+				Databases:     []model.Database{{Name: "orders"}},
+				ElasticSearch: []model.ElasticSearch{{Name: "logging"}},
+				Queues:        []model.Queue{{Name: "order-events"}},
+			},
+		},
+		{
+			name:    "database name with upper case letters",
+			spec:    model.Spec{Databases: []model.Database{{Name: "Orders"}}},
+			wantErr: "database Orders would have service",
+		},
+		{
+			name:    "ElasticSearch name with underscores",
+			spec:    model.Spec{ElasticSearch: []model.ElasticSearch{{Name: "audit_logs"}}},
+			wantErr: "ElasticSearch audit_logs would have service",
+		},
+		{
+			name:    "queue name with underscores",
+			spec:    model.Spec{Queues: []model.Queue{{Name: "order_events"}}},
+			wantErr: "queue order_events would have config-map order_events-queue",
+		},
+		{
+			name:    "queue name with upper case letters",
+			spec:    model.Spec{Queues: []model.Queue{{Name: "OrderEvents"}}},
+			wantErr: "queue OrderEvents would have config-map OrderEvents-queue",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateObjects(test.spec)
+			if test.wantErr == "" && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Fatalf("expected an error containing %q, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestQueueConfigMapsDoNotShareElasticSearchNames(t *testing.T) {
+	if name := queueConfigMapName("logging"); name == objectName(nil, "logging") {
+		t.Errorf("expected queue logging to have a different config-map from ElasticSearch logging, both are %s", name)
+	}
+}
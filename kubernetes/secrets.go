@@ -1,25 +1,147 @@
 package kubernetes
 
 import (
+	"context"
+	"github.com/ericchiang/k8s"
+	appsv1 "github.com/ericchiang/k8s/apis/apps/v1"
 	"github.com/ericchiang/k8s/apis/core/v1"
 	v12 "github.com/ericchiang/k8s/apis/meta/v1"
+	"github.com/infinityworks/fk-infra/aws"
+	"github.com/infinityworks/fk-infra/model"
 	"github.com/infinityworks/fk-infra/terraform"
 	"github.com/infinityworks/fk-infra/util"
+	"log"
+	"time"
 )
 
-func ApplySecrets(outputs terraform.Outputs) {
-	for _, databaseConfig := range outputs.DatabaseConfig() {
-		CreateOrUpdate(&v1.Secret{
-			Metadata: &v12.ObjectMeta{
-				Name:      util.String(databaseConfig.Name),
-				Namespace: util.String("default"),
-			},
-			Type: util.String("Opaque"),
-			Data: map[string][]byte{
-				"schema":   []byte(databaseConfig.Name),
-				"endpoint": []byte(databaseConfig.Endpoint),
-				"password": []byte(databaseConfig.Password),
-			},
-		})
+const restartedAtAnnotation = "fk-infra/restarted-at"
+
+// ApplySecrets writes the connection details of each database into a Secret in each of its namespaces, reading
+// its password from Secrets Manager.
+func ApplySecrets(applier *Applier, databases []model.Database, outputs terraform.Outputs, region string) error {
+	databaseConfigs, err := outputs.DatabaseConfig()
+	if err != nil {
+		return err
+	}
+	for _, databaseConfig := range databaseConfigs {
+		password, err := databasePassword(databaseConfig, region)
+		if err != nil {
+			return err
+		}
+
+		secret := databaseSecret(databases, databaseConfig.Name)
+		namespaces, err := objectNamespaces(applier.client, secret)
+		if err != nil {
+			return err
+		}
+		data := map[string][]byte{
+			objectKey(secret, "schema"):   []byte(databaseConfig.Name),
+			objectKey(secret, "engine"):   []byte(databaseConfig.Engine),
+			objectKey(secret, "port"):     []byte(databaseConfig.Port),
+			objectKey(secret, "endpoint"): []byte(databaseConfig.Endpoint),
+			objectKey(secret, "password"): []byte(password),
+		}
+		for _, namespace := range namespaces {
+			if err := applier.Apply(componentDatabase, &v1.Secret{
+				Metadata: &v12.ObjectMeta{
+					Name:      util.String(objectName(secret, databaseConfig.Name)),
+					Namespace: util.String(namespace),
+				},
+				Type: util.String("Opaque"),
+				Data: data,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RestartDeploymentsUsingDatabaseSecret restarts the deployments reading the Secret of a database in any of the
+// namespaces it is written to.
+func RestartDeploymentsUsingDatabaseSecret(client *k8s.Client, database model.Database) error {
+	namespaces, err := objectNamespaces(client, database.Secret)
+	if err != nil {
+		return err
+	}
+	for _, namespace := range namespaces {
+		if err := restartDeploymentsUsingSecret(client, namespace, objectName(database.Secret, database.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Databases applied before passwords moved to Secrets Manager keep theirs in the terraform outputs until the
+// next apply moves it across.
+func databasePassword(databaseConfig terraform.DatabaseOutput, region string) (string, error) {
+	if databaseConfig.PasswordSecret == "" {
+		return databaseConfig.LegacyPassword, nil
+	}
+	password, exists, err := aws.FetchSecret(databaseConfig.PasswordSecret, region)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", util.NewError(util.CloudError, "password secret %s of database %s is missing", databaseConfig.PasswordSecret, databaseConfig.Name)
+	}
+	return password, nil
+}
+
+func databaseSecret(databases []model.Database, databaseName string) *model.KubernetesObject {
+	for _, database := range databases {
+		if database.Name == databaseName {
+			return database.Secret
+		}
+	}
+	return nil
+}
+
+// restartDeploymentsUsingSecret annotates the pod template of every deployment in the namespace that reads the
+// secret, which rolls its pods the same way kubectl rollout restart does so they pick up the new values.
+func restartDeploymentsUsingSecret(client *k8s.Client, namespace, secretName string) error {
+	var deployments appsv1.DeploymentList
+	if err := client.List(context.TODO(), namespace, &deployments); err != nil {
+		return util.WrapError(util.CloudError, err, "unable to list deployments in %s", namespace)
+	}
+	restartedAt := time.Now().UTC().Format(time.RFC3339)
+	for _, deployment := range deployments.Items {
+		template := deployment.GetSpec().GetTemplate()
+		if !podUsesSecret(template.GetSpec(), secretName) {
+			continue
+		}
+		if template.Metadata == nil {
+			template.Metadata = &v12.ObjectMeta{}
+		}
+		if template.Metadata.Annotations == nil {
+			template.Metadata.Annotations = make(map[string]string)
+		}
+		template.Metadata.Annotations[restartedAtAnnotation] = restartedAt
+		if err := client.Update(context.TODO(), deployment); err != nil {
+			return util.WrapError(util.CloudError, err, "unable to restart deployment %s", deployment.GetMetadata().GetName())
+		}
+		log.Printf("Restarting deployment %s to pick up secret %s", deployment.GetMetadata().GetName(), secretName)
+	}
+	return nil
+}
+
+func podUsesSecret(podSpec *v1.PodSpec, secretName string) bool {
+	for _, volume := range podSpec.GetVolumes() {
+		if volume.GetVolumeSource().GetSecret().GetSecretName() == secretName {
+			return true
+		}
+	}
+	for _, container := range append(podSpec.GetInitContainers(), podSpec.GetContainers()...) {
+		for _, envFrom := range container.GetEnvFrom() {
+			if envFrom.GetSecretRef().GetLocalObjectReference().GetName() == secretName {
+				return true
+			}
+		}
+		for _, env := range container.GetEnv() {
+			if env.GetValueFrom().GetSecretKeyRef().GetLocalObjectReference().GetName() == secretName {
+				return true
+			}
+		}
 	}
+	return false
 }
@@ -1,8 +1,76 @@
 package kubernetes
 
 import (
+	"github.com/ericchiang/k8s/apis/core/v1"
+	v12 "github.com/ericchiang/k8s/apis/meta/v1"
 	"github.com/infinityworks/fk-infra/terraform"
+	"github.com/infinityworks/fk-infra/util"
+	"net"
+	"strconv"
 )
 
-func ApplyServices(outputs terraform.Outputs) {
+const elasticSearchPort = 443
+
+// ApplyServices gives each database and ElasticSearch domain a stable name in the default namespace, e.g.
+// orders-db.default.svc, as an ExternalName service pointing at the AWS endpoint. Replacing an instance then
+// only changes where the service points.
+func ApplyServices(applier *Applier, outputs terraform.Outputs) error {
+	databaseConfigs, err := outputs.DatabaseConfig()
+	if err != nil {
+		return err
+	}
+	for _, databaseConfig := range databaseConfigs {
+		port, err := strconv.Atoi(databaseConfig.Port)
+		if err != nil {
+			return util.WrapError(util.ExecutionError, err, "database %s has port %q, expected a number", databaseConfig.Name, databaseConfig.Port)
+		}
+		// The RDS endpoint includes the port, which an ExternalName can't
+		if err := applyExternalNameService(applier, componentDatabase, databaseServiceName(databaseConfig.Name), endpointHost(databaseConfig.Endpoint), databaseConfig.Engine, port); err != nil {
+			return err
+		}
+	}
+
+	elasticSearchConfigs, err := outputs.ElasticSearchConfig()
+	if err != nil {
+		return err
+	}
+	for _, elasticSearchConfig := range elasticSearchConfigs {
+		if err := applyExternalNameService(applier, componentElasticSearch, elasticSearchServiceName(elasticSearchConfig.Name), elasticSearchConfig.Endpoint, "https", elasticSearchPort); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyExternalNameService(applier *Applier, component, name, externalName, portName string, port int) error {
+	return applier.Apply(component, &v1.Service{
+		Metadata: &v12.ObjectMeta{
+			Name:      util.String(name),
+			Namespace: util.String(defaultNamespace),
+		},
+		Spec: &v1.ServiceSpec{
+			Type:         util.String("ExternalName"),
+			ExternalName: util.String(externalName),
+			Ports: []*v1.ServicePort{{
+				Name:     util.String(portName),
+				Protocol: util.String("TCP"),
+				Port:     util.Int32(int32(port)),
+			}},
+		},
+	})
+}
+
+func databaseServiceName(databaseName string) string {
+	return databaseName + "-db"
+}
+
+func elasticSearchServiceName(domainName string) string {
+	return domainName + "-es"
+}
+
+func endpointHost(endpoint string) string {
+	if host, _, err := net.SplitHostPort(endpoint); err == nil {
+		return host
+	}
+	return endpoint
 }
@@ -0,0 +1,81 @@
+package kubernetes
+
+import (
+	"context"
+	"github.com/ericchiang/k8s"
+	v12 "github.com/ericchiang/k8s/apis/apps/v1"
+	"github.com/ericchiang/k8s/apis/core/v1"
+	"github.com/infinityworks/fk-infra/util"
+	"net/http"
+)
+
+const roleLabel = "kubernetes.io/role"
+
+type NodeCounts struct {
+	Masters      int `json:"masters"`
+	ReadyMasters int `json:"ready-masters"`
+	Nodes        int `json:"nodes"`
+	ReadyNodes   int `json:"ready-nodes"`
+}
+
+type Rollout struct {
+	Deployed  bool  `json:"deployed"`
+	Desired   int32 `json:"desired"`
+	Updated   int32 `json:"updated"`
+	Available int32 `json:"available"`
+}
+
+func (rollout Rollout) Complete() bool {
+	return rollout.Deployed && rollout.Updated == rollout.Desired && rollout.Available == rollout.Desired
+}
+
+// FetchNodeCounts tells masters and nodes apart by the role label kops gives them.
+func FetchNodeCounts(client *k8s.Client) (NodeCounts, error) {
+	var counts NodeCounts
+	var nodes v1.NodeList
+	if err := client.List(context.TODO(), k8s.AllNamespaces, &nodes); err != nil {
+		return counts, util.WrapError(util.CloudError, err, "unable to list nodes")
+	}
+	for _, node := range nodes.Items {
+		ready := nodeIsReady(node)
+		if node.GetMetadata().GetLabels()[roleLabel] == "master" {
+			counts.Masters++
+			if ready {
+				counts.ReadyMasters++
+			}
+		} else {
+			counts.Nodes++
+			if ready {
+				counts.ReadyNodes++
+			}
+		}
+	}
+	return counts, nil
+}
+
+func nodeIsReady(node *v1.Node) bool {
+	for _, condition := range node.GetStatus().GetConditions() {
+		if condition.GetType() == "Ready" {
+			return condition.GetStatus() == "True"
+		}
+	}
+	return false
+}
+
+func FetchFluentBitRollout(client *k8s.Client) (Rollout, error) {
+	var daemonSet v12.DaemonSet
+	err := client.Get(context.TODO(), fluentBitNamespace, fluentBitDaemonSetName, &daemonSet)
+	if apiErr, ok := err.(*k8s.APIError); ok && apiErr.Code == http.StatusNotFound {
+		return Rollout{}, nil
+	}
+	if err != nil {
+		return Rollout{}, util.WrapError(util.CloudError, err, "unable to look up %s daemon set", fluentBitDaemonSetName)
+	}
+	status := daemonSet.GetStatus()
+	return Rollout{
+		Deployed:  true,
+		Desired:   status.GetDesiredNumberScheduled(),
+		Updated:   status.GetUpdatedNumberScheduled(),
+		Available: status.GetNumberAvailable(),
+	}, nil
+}
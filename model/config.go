@@ -6,12 +6,23 @@ import (
 	"io/ioutil"
 )
 
-func FetchConfig() *Config {
+const configFile = "./fk-infra.yml"
+
+func FetchConfig() (*Config, error) {
+	configBytes, err := FetchConfigBytes()
+	if err != nil {
+		return nil, err
+	}
 	var config Config
-	configBytes, err := ioutil.ReadFile("./fk-infra.yml")
-	util.CheckError(err)
-	util.CheckError(yaml.Unmarshal(configBytes, &config))
-	return &config
+	if err := yaml.Unmarshal(configBytes, &config); err != nil {
+		return nil, util.WrapError(util.ConfigError, err, "invalid %s", configFile)
+	}
+	return &config, nil
+}
+
+func FetchConfigBytes() ([]byte, error) {
+	configBytes, err := ioutil.ReadFile(configFile)
+	return configBytes, util.WrapError(util.ConfigError, err, "unable to read %s", configFile)
 }
 
 type Config struct {
@@ -19,26 +30,154 @@ type Config struct {
 }
 
 type Kubernetes struct {
-	Name          string `json:"name"`
-	LoggingElasticSearchName string `json:"logging-elasticsearch-name"`
+	Name string `json:"name"`
+	// LoggingElasticSearchName is short for logging to a single elasticsearch output
+	LoggingElasticSearchName string          `json:"logging-elasticsearch-name"`
+	Logging                  []LogOutput     `json:"logging,omitempty"`
+	FluentBit                *FluentBit      `json:"fluent-bit,omitempty"`
+	InstanceGroups           []InstanceGroup `json:"instance-groups,omitempty"`
+}
+
+// LogOutputs are everywhere the logs of the cluster are shipped to.
+func (kubernetes Kubernetes) LogOutputs() []LogOutput {
+	if kubernetes.LoggingElasticSearchName == "" {
+		return kubernetes.Logging
+	}
+	return append([]LogOutput{{Type: LogOutputElasticSearch, ElasticSearchName: kubernetes.LoggingElasticSearchName}}, kubernetes.Logging...)
+}
+
+// FluentBit tunes the fluent-bit add-on that ships the logs, anything left out keeps its default. The image can
+// be moved to another version or registry here, pinned to a tag or digest. Tolerations replace the defaults, which
+// let it run on every node.
+type FluentBit struct {
+	Image             string       `json:"image,omitempty"`
+	Resources         *Resources   `json:"resources,omitempty"`
+	MemoryBufferLimit string       `json:"memory-buffer-limit,omitempty"`
+	LogLevel          string       `json:"log-level,omitempty"`
+	ExcludeNamespaces []string     `json:"exclude-namespaces,omitempty"`
+	Tolerations       []Toleration `json:"tolerations,omitempty"`
+}
+
+// Resources are the cpu and memory requested and limited for a container, as kubernetes quantities.
+type Resources struct {
+	Requests map[string]string `json:"requests,omitempty"`
+	Limits   map[string]string `json:"limits,omitempty"`
+}
+
+type Toleration struct {
+	Key      string `json:"key,omitempty"`
+	Operator string `json:"operator,omitempty"`
+	Value    string `json:"value,omitempty"`
+	Effect   string `json:"effect,omitempty"`
+}
+
+const (
+	LogOutputElasticSearch = "elasticsearch"
+	LogOutputCloudWatch    = "cloudwatch"
+	LogOutputS3            = "s3"
+	LogOutputHttp          = "http"
+	LogOutputForward       = "forward"
+)
+
+// LogOutput is somewhere the fluent-bit add-on ships the logs of a cluster, every output receives every record.
+// Which fields are used depends on the type: elasticsearch needs elasticsearch-name, cloudwatch a log-group
+// which is created if missing, s3 a bucket and optionally a key prefix, and http and forward a host.
+type LogOutput struct {
+	Type              string `json:"type"`
+	ElasticSearchName string `json:"elasticsearch-name,omitempty"`
+	LogGroup          string `json:"log-group,omitempty"`
+	Bucket            string `json:"bucket,omitempty"`
+	Prefix            string `json:"prefix,omitempty"`
+	Host              string `json:"host,omitempty"`
+	Port              int    `json:"port,omitempty"`
+	Uri               string `json:"uri,omitempty"`
+	Tls               bool   `json:"tls,omitempty"`
+}
+
+type InstanceGroup struct {
+	Name           string            `json:"name"`
+	MachineType    string            `json:"machine-type,omitempty"`
+	MinSize        int               `json:"min-size,omitempty"`
+	MaxSize        int               `json:"max-size,omitempty"`
+	Image          string            `json:"image,omitempty"`
+	NodeLabels     map[string]string `json:"node-labels,omitempty"`
+	Taints         []string          `json:"taints,omitempty"`
+	RootVolumeSize int               `json:"root-volume-size,omitempty"`
+	Subnets        []string          `json:"subnets,omitempty"`
 }
 
 type Database struct {
-	Name string `json:"name"`
+	Name                  string              `json:"name"`
+	Engine                string              `json:"engine,omitempty"`
+	EngineVersion         string              `json:"engine-version,omitempty"`
+	InstanceClass         string              `json:"instance-class,omitempty"`
+	AllocatedStorage      int                 `json:"allocated-storage,omitempty"`
+	StorageType           string              `json:"storage-type,omitempty"`
+	Iops                  int                 `json:"iops,omitempty"`
+	MultiAz               *bool               `json:"multi-az,omitempty"`
+	BackupRetentionPeriod *int                `json:"backup-retention-period,omitempty"`
+	Parameters            []DatabaseParameter `json:"parameters,omitempty"`
+	Secret                *KubernetesObject   `json:"secret,omitempty"`
 }
 
+type DatabaseParameter struct {
+	Name        string `json:"name"`
+	Value       string `json:"value"`
+	ApplyMethod string `json:"apply-method,omitempty"`
+}
+
+const (
+	QueueTypeSqs = "sqs"
+	QueueTypeSns = "sns"
+)
+
 type Queue struct {
 	Type string `json:"type"`
 	Name string `json:"name"`
 }
 
 type PeeringConnection struct {
-	Name        string `json:"name"`
-	PeerVpcCidr string `json:"peer-vpc-cidr"`
+	Name          string `json:"name"`
+	PeerVpcCidr   string `json:"peer-vpc-cidr"`
+	PeerVpcId     string `json:"peer-vpc-id"`
+	PeerAccountId string `json:"peer-account-id,omitempty"`
+	PeerRegion    string `json:"peer-region,omitempty"`
+	PeerRoleArn   string `json:"peer-role-arn,omitempty"`
+}
+
+type Network struct {
+	VpcCidr               string   `json:"vpc-cidr,omitempty"`
+	AvailabilityZones     []string `json:"availability-zones,omitempty"`
+	AvailabilityZoneCount int      `json:"availability-zone-count,omitempty"`
+	PrivateSubnetSize     int      `json:"private-subnet-size,omitempty"`
+	UtilitySubnetSize     int      `json:"utility-subnet-size,omitempty"`
 }
 
 type ElasticSearch struct {
-	Name string `json:"name"`
+	Name                 string            `json:"name"`
+	Version              string            `json:"version,omitempty"`
+	InstanceType         string            `json:"instance-type,omitempty"`
+	InstanceCount        int               `json:"instance-count,omitempty"`
+	VolumeSize           int               `json:"volume-size,omitempty"`
+	VolumeType           string            `json:"volume-type,omitempty"`
+	DedicatedMasterCount int               `json:"dedicated-master-count,omitempty"`
+	DedicatedMasterType  string            `json:"dedicated-master-type,omitempty"`
+	ZoneAwareness        *bool             `json:"zone-awareness,omitempty"`
+	EncryptionAtRest     bool              `json:"encryption-at-rest,omitempty"`
+	NodeToNodeEncryption bool              `json:"node-to-node-encryption,omitempty"`
+	SnapshotHour         *int              `json:"snapshot-hour,omitempty"`
+	AccessPrincipals     []string          `json:"access-principals,omitempty"`
+	ConfigMap            *KubernetesObject `json:"config-map,omitempty"`
+}
+
+// KubernetesObject describes the Secret or ConfigMap the connection details of a database or ElasticSearch domain
+// are written to in each cluster. It is named after the database or domain and written to the default namespace
+// unless namespaces are listed or selected by label, keys can be renamed from their defaults.
+type KubernetesObject struct {
+	Name              string            `json:"name,omitempty"`
+	Namespaces        []string          `json:"namespaces,omitempty"`
+	NamespaceSelector string            `json:"namespace-selector,omitempty"`
+	Keys              map[string]string `json:"keys,omitempty"`
 }
 
 type Spec struct {
@@ -46,6 +185,7 @@ type Spec struct {
 	Region             string              `json:"region"`
 	EncryptionKey      string              `json:"encryption-key"`
 	ConfigBucket       string              `json:"config-bucket"`
+	Network            *Network            `json:"network,omitempty"`
 	Kubernetes         []Kubernetes        `json:"kubernetes,omitempty"`
 	Databases          []Database          `json:"databases,omitempty"`
 	Queues             []Queue             `json:"queues,omitempty"`
@@ -0,0 +1,129 @@
+package plan
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/infinityworks/fk-infra/terraform"
+	"github.com/infinityworks/fk-infra/util"
+	"io/ioutil"
+)
+
+// Summary describes a plan. A plan with StateMigration only migrates terraform state, the environment is planned
+// once that has been applied.
+type Summary struct {
+	ConfigChecksum string                            `json:"config-checksum"`
+	StateMigration []string                          `json:"state-migration,omitempty"`
+	States         map[string]terraform.StateVersion `json:"states"`
+	Terraform      map[string]*ResourceChanges       `json:"terraform"`
+	Kubernetes     []KubernetesChanges               `json:"kubernetes"`
+}
+
+type ResourceChanges struct {
+	Add     []string `json:"add"`
+	Change  []string `json:"change"`
+	Destroy []string `json:"destroy"`
+}
+
+// KubernetesChanges holds what kops would change in a cluster and, once it is running, the objects fk-infra
+// writes to it that would be created, updated or pruned.
+type KubernetesChanges struct {
+	Name             string           `json:"name"`
+	Pending          bool             `json:"pending"`
+	TemplateChecksum string           `json:"template-checksum,omitempty"`
+	Changes          string           `json:"changes,omitempty"`
+	Objects          *ResourceChanges `json:"objects,omitempty"`
+	ObjectDiff       []string         `json:"object-diff,omitempty"`
+}
+
+func SummaryFilename(planFile string) string {
+	return fmt.Sprintf("%s.json", planFile)
+}
+
+func WriteSummary(planFile string, summary Summary) error {
+	summaryBytes, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return util.WrapError(util.UnknownError, err, "unable to encode plan summary")
+	}
+	return util.WriteFile(SummaryFilename(planFile), summaryBytes)
+}
+
+func ReadSummary(planFile string) (Summary, error) {
+	var summary Summary
+	summaryBytes, err := ioutil.ReadFile(SummaryFilename(planFile))
+	if err != nil {
+		return summary, util.WrapError(util.ConfigError, err, "unable to read plan summary for %s", planFile)
+	}
+	err = json.Unmarshal(summaryBytes, &summary)
+	return summary, util.WrapError(util.ConfigError, err, "invalid plan summary for %s", planFile)
+}
+
+func Checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// terraformPlan is the part of the JSON terraform show writes for a plan file that lists what happens to each
+// resource and the variables it was planned with.
+type terraformPlan struct {
+	Variables map[string]struct {
+		Value interface{} `json:"value"`
+	} `json:"variables"`
+	ResourceChanges []struct {
+		Address string `json:"address"`
+		Mode    string `json:"mode"`
+		Change  struct {
+			Actions []string `json:"actions"`
+		} `json:"change"`
+	} `json:"resource_changes"`
+}
+
+// TerraformChanges groups the resources planned by each component, read from the JSON terraform show writes for
+// its plan file. Replaced resources are counted as both an addition and a destruction the same way terraform
+// counts them, data sources read during apply aren't changes.
+func TerraformChanges(plans map[string][]byte) (map[string]*ResourceChanges, error) {
+	changes := make(map[string]*ResourceChanges)
+	for component, planJson := range plans {
+		var componentPlan terraformPlan
+		if err := json.Unmarshal(planJson, &componentPlan); err != nil {
+			return nil, util.WrapError(util.ExecutionError, err, "unable to read terraform plan for %s", component)
+		}
+		componentChanges := &ResourceChanges{}
+		for _, resourceChange := range componentPlan.ResourceChanges {
+			if resourceChange.Mode == "data" {
+				continue
+			}
+			for _, action := range resourceChange.Change.Actions {
+				switch action {
+				case "create":
+					componentChanges.Add = append(componentChanges.Add, resourceChange.Address)
+				case "update":
+					componentChanges.Change = append(componentChanges.Change, resourceChange.Address)
+				case "delete":
+					componentChanges.Destroy = append(componentChanges.Destroy, resourceChange.Address)
+				}
+			}
+		}
+		changes[component] = componentChanges
+	}
+	return changes, nil
+}
+
+// PlannedVariable returns the value a string variable had when the plan was made, from the JSON terraform show
+// writes for its plan file. Applying the plan uses the same value.
+func PlannedVariable(planJson []byte, name string) (string, bool, error) {
+	var componentPlan terraformPlan
+	if err := json.Unmarshal(planJson, &componentPlan); err != nil {
+		return "", false, util.WrapError(util.ExecutionError, err, "unable to read terraform plan")
+	}
+	variable, ok := componentPlan.Variables[name]
+	if !ok {
+		return "", false, nil
+	}
+	value, ok := variable.Value.(string)
+	if !ok {
+		return "", false, util.NewError(util.ExecutionError, "terraform plan has variable %s set to %v, expected a string", name, variable.Value)
+	}
+	return value, true, nil
+}
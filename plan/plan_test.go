@@ -0,0 +1,111 @@
+package plan
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTerraformChanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		plan    string
+		want    *ResourceChanges
+		wantErr bool
+	}{
+		{
+			name: "no changes",
+			plan: `{"format_version": "0.1", "resource_changes": [
+				{"address": "aws_vpc.dev", "mode": "managed", "change": {"actions": ["no-op"]}}
+			]}`,
+			want: &ResourceChanges{},
+		},
+		{
+			name: "created, updated and destroyed",
+			plan: `{"format_version": "0.1", "resource_changes": [
+				{"address": "aws_sqs_queue.dev-orders", "mode": "managed", "change": {"actions": ["create"]}},
+				{"address": "aws_db_instance.dev-orders", "mode": "managed", "change": {"actions": ["update"]}},
+				{"address": "aws_sns_topic.dev-events", "mode": "managed", "change": {"actions": ["delete"]}}
+			]}`,
+			want: &ResourceChanges{
+				Add:     []string{"aws_sqs_queue.dev-orders"},
+				Change:  []string{"aws_db_instance.dev-orders"},
+				Destroy: []string{"aws_sns_topic.dev-events"},
+			},
+		},
+		{
+			name: "replaced either way round",
+			plan: `{"format_version": "0.1", "resource_changes": [
+				{"address": "aws_subnet.dev-a", "mode": "managed", "change": {"actions": ["delete", "create"]}},
+				{"address": "aws_subnet.dev-b", "mode": "managed", "change": {"actions": ["create", "delete"]}}
+			]}`,
+			want: &ResourceChanges{
+				Add:     []string{"aws_subnet.dev-a", "aws_subnet.dev-b"},
+				Destroy: []string{"aws_subnet.dev-a", "aws_subnet.dev-b"},
+			},
+		},
+		{
+			name: "data sources read during apply",
+			plan: `{"format_version": "0.1", "resource_changes": [
+				{"address": "data.aws_secretsmanager_secret_version.dev-orders-password", "mode": "data", "change": {"actions": ["read"]}}
+			]}`,
+			want: &ResourceChanges{},
+		},
+		{
+			name:    "not JSON",
+			plan:    `Plan: 1 to add, 0 to change, 0 to destroy.`,
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changes, err := TerraformChanges(map[string][]byte{"network": []byte(test.plan)})
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", changes)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(changes["network"], test.want) {
+				t.Errorf("expected %+v, got %+v", test.want, changes["network"])
+			}
+		})
+	}
+}
+
+func TestPlannedVariable(t *testing.T) {
+	planJson := []byte(`{"format_version": "0.1", "variables": {
+		"database_password_orders": {"value": "s3cret"},
+		"instance_count": {"value": 3}
+	}}`)
+	tests := []struct {
+		name      string
+		variable  string
+		want      string
+		wantFound bool
+		wantErr   bool
+	}{
+		{name: "string variable", variable: "database_password_orders", want: "s3cret", wantFound: true},
+		{name: "variable not in the plan", variable: "database_password_users"},
+		{name: "variable that isn't a string", variable: "instance_count", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, found, err := PlannedVariable(planJson, test.variable)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if value != test.want || found != test.wantFound {
+				t.Errorf("expected %q found %v, got %q found %v", test.want, test.wantFound, value, found)
+			}
+		})
+	}
+}
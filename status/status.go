@@ -0,0 +1,232 @@
+package status
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/infinityworks/fk-infra/aws"
+	"github.com/infinityworks/fk-infra/kubernetes"
+	"github.com/infinityworks/fk-infra/model"
+	"github.com/infinityworks/fk-infra/templates"
+	"github.com/infinityworks/fk-infra/terraform"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// Report describes the environment as it is, against what fk-infra.yml says it should be. Problems reaching
+// a single component are recorded against it rather than failing the whole report.
+type Report struct {
+	EnvironmentName string                `json:"environment-name"`
+	Network         NetworkStatus         `json:"network"`
+	Clusters        []ClusterStatus       `json:"clusters"`
+	Databases       []DatabaseStatus      `json:"databases"`
+	ElasticSearch   []ElasticSearchStatus `json:"elasticsearch"`
+}
+
+type NetworkStatus struct {
+	VpcId                 string   `json:"vpc-id"`
+	VpcCidr               string   `json:"vpc-cidr"`
+	PrivateSubnetIds      []string `json:"private-subnet-ids"`
+	UtilitySubnetIds      []string `json:"utility-subnet-ids"`
+	MasterSecurityGroupId string   `json:"master-security-group-id"`
+	WorkerSecurityGroupId string   `json:"worker-security-group-id"`
+}
+
+type ClusterStatus struct {
+	Name       string                 `json:"name"`
+	Valid      bool                   `json:"valid"`
+	Validation string                 `json:"validation"`
+	Nodes      *kubernetes.NodeCounts `json:"nodes,omitempty"`
+	Logging    *kubernetes.Rollout    `json:"logging,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+type DatabaseStatus struct {
+	Name     string `json:"name"`
+	Engine   string `json:"engine"`
+	Endpoint string `json:"endpoint"`
+	State    string `json:"state"`
+	Error    string `json:"error,omitempty"`
+}
+
+type ElasticSearchStatus struct {
+	Name     string `json:"name"`
+	Endpoint string `json:"endpoint"`
+	Health   string `json:"health"`
+	Error    string `json:"error,omitempty"`
+}
+
+func Collect(config *model.Config, outputs terraform.Outputs) (Report, error) {
+	report := Report{
+		EnvironmentName: config.Spec.EnvironmentName,
+		Network: NetworkStatus{
+			VpcId:                 outputs.VpcId.Value,
+			VpcCidr:               outputs.VpcCidr.Value,
+			PrivateSubnetIds:      outputs.PrivateSubnets(),
+			UtilitySubnetIds:      outputs.UtilitySubnets(),
+			MasterSecurityGroupId: outputs.MasterSecurityGroupId.Value,
+			WorkerSecurityGroupId: outputs.WorkerSecurityGroupId.Value,
+		},
+	}
+
+	for _, kubernetesCluster := range config.Spec.Kubernetes {
+		report.Clusters = append(report.Clusters, clusterStatus(config, kubernetesCluster))
+	}
+
+	// Unreadable outputs are reported against every database or domain, their state is still looked up
+	databaseOutputs, databaseOutputsErr := outputs.DatabaseConfig()
+	for _, database := range config.Spec.Databases {
+		report.Databases = append(report.Databases, databaseStatus(config, database, databaseOutputs, databaseOutputsErr))
+	}
+
+	elasticSearchOutputs, elasticSearchOutputsErr := outputs.ElasticSearchConfig()
+	for _, elasticSearch := range config.Spec.ElasticSearch {
+		report.ElasticSearch = append(report.ElasticSearch, elasticSearchStatus(config, elasticSearch, elasticSearchOutputs, elasticSearchOutputsErr))
+	}
+	return report, nil
+}
+
+func clusterStatus(config *model.Config, kubernetesCluster model.Kubernetes) ClusterStatus {
+	status := ClusterStatus{Name: kubernetesCluster.Name, Validation: "unknown"}
+
+	client, err := templates.KubernetesClient(config, kubernetesCluster.Name)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+
+	if err := templates.CheckKubernetesCluster(config, kubernetesCluster.Name); err != nil {
+		status.Validation = "failed"
+	} else {
+		status.Valid = true
+		status.Validation = "passed"
+	}
+
+	nodeCounts, err := kubernetes.FetchNodeCounts(client)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Nodes = &nodeCounts
+
+	if len(kubernetesCluster.LogOutputs()) > 0 {
+		rollout, err := kubernetes.FetchFluentBitRollout(client)
+		if err != nil {
+			status.Error = err.Error()
+			return status
+		}
+		status.Logging = &rollout
+	}
+	return status
+}
+
+func databaseStatus(config *model.Config, database model.Database, databaseOutputs []terraform.DatabaseOutput, outputsErr error) DatabaseStatus {
+	status := DatabaseStatus{Name: database.Name, Error: errorMessage(outputsErr)}
+	for _, databaseOutput := range databaseOutputs {
+		if databaseOutput.Name == database.Name {
+			status.Engine = databaseOutput.Engine
+			status.Endpoint = databaseOutput.Endpoint
+		}
+	}
+
+	state, err := aws.DatabaseInstanceState(fmt.Sprintf("%s-%s", config.Spec.EnvironmentName, database.Name), config.Spec.Region)
+	if err != nil {
+		status.State = "unknown"
+		status.Error = errorMessage(outputsErr, err)
+		return status
+	}
+	status.State = state
+	return status
+}
+
+func elasticSearchStatus(config *model.Config, elasticSearch model.ElasticSearch, elasticSearchOutputs []terraform.ElasticSearchOutput, outputsErr error) ElasticSearchStatus {
+	status := ElasticSearchStatus{Name: elasticSearch.Name, Error: errorMessage(outputsErr)}
+	for _, elasticSearchOutput := range elasticSearchOutputs {
+		if elasticSearchOutput.Name == elasticSearch.Name {
+			status.Endpoint = elasticSearchOutput.Endpoint
+		}
+	}
+
+	health, err := aws.ElasticSearchDomainHealth(fmt.Sprintf("%s-%s", config.Spec.EnvironmentName, elasticSearch.Name), config.Spec.Region)
+	if err != nil {
+		status.Health = "unknown"
+		status.Error = errorMessage(outputsErr, err)
+		return status
+	}
+	status.Health = health
+	return status
+}
+
+func errorMessage(errs ...error) string {
+	var messages []string
+	for _, err := range errs {
+		if err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (report Report) WriteJson(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+func (report Report) WriteTable(writer io.Writer) error {
+	table := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(table, "ENVIRONMENT\t%s\n\n", report.EnvironmentName)
+
+	fmt.Fprintln(table, "NETWORK\t")
+	fmt.Fprintf(table, "vpc\t%s\n", orNone(report.Network.VpcId))
+	fmt.Fprintf(table, "cidr\t%s\n", orNone(report.Network.VpcCidr))
+	fmt.Fprintf(table, "private subnets\t%s\n", orNone(strings.Join(report.Network.PrivateSubnetIds, ", ")))
+	fmt.Fprintf(table, "utility subnets\t%s\n", orNone(strings.Join(report.Network.UtilitySubnetIds, ", ")))
+	fmt.Fprintf(table, "master security group\t%s\n", orNone(report.Network.MasterSecurityGroupId))
+	fmt.Fprintf(table, "worker security group\t%s\n\n", orNone(report.Network.WorkerSecurityGroupId))
+
+	fmt.Fprintln(table, "CLUSTER\tVALIDATION\tMASTERS\tNODES\tLOGGING\tERROR")
+	for _, cluster := range report.Clusters {
+		masters, nodes := "-", "-"
+		if cluster.Nodes != nil {
+			masters = fmt.Sprintf("%d/%d", cluster.Nodes.ReadyMasters, cluster.Nodes.Masters)
+			nodes = fmt.Sprintf("%d/%d", cluster.Nodes.ReadyNodes, cluster.Nodes.Nodes)
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n", cluster.Name, cluster.Validation, masters, nodes, rolloutSummary(cluster.Logging), cluster.Error)
+	}
+	fmt.Fprintln(table)
+
+	fmt.Fprintln(table, "DATABASE\tENGINE\tENDPOINT\tSTATE\tERROR")
+	for _, database := range report.Databases {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", database.Name, orNone(database.Engine), orNone(database.Endpoint), database.State, database.Error)
+	}
+	fmt.Fprintln(table)
+
+	fmt.Fprintln(table, "ELASTICSEARCH\tENDPOINT\tHEALTH\tERROR")
+	for _, elasticSearch := range report.ElasticSearch {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", elasticSearch.Name, orNone(elasticSearch.Endpoint), elasticSearch.Health, elasticSearch.Error)
+	}
+
+	return table.Flush()
+}
+
+func rolloutSummary(rollout *kubernetes.Rollout) string {
+	switch {
+	case rollout == nil:
+		return "-"
+	case !rollout.Deployed:
+		return "not deployed"
+	case rollout.Complete():
+		return fmt.Sprintf("rolled out %d/%d", rollout.Available, rollout.Desired)
+	default:
+		return fmt.Sprintf("rolling out %d/%d updated, %d available", rollout.Updated, rollout.Desired, rollout.Available)
+	}
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
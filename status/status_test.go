@@ -0,0 +1,115 @@
+package status
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/infinityworks/fk-infra/kubernetes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testReport() Report {
+	return Report{
+		EnvironmentName: "dev",
+		Network: NetworkStatus{
+			VpcId:            "vpc-0a1b2c3d",
+			VpcCidr:          "10.0.0.0/16",
+			PrivateSubnetIds: []string{"subnet-1", "subnet-2"},
+		},
+		Clusters: []ClusterStatus{{
+			Name:       "dev.k8s.local",
+			Valid:      true,
+			Validation: "valid",
+			Nodes:      &kubernetes.NodeCounts{Masters: 3, ReadyMasters: 3, Nodes: 4, ReadyNodes: 3},
+			Logging:    &kubernetes.Rollout{Deployed: true, Desired: 4, Updated: 2, Available: 3},
+		}},
+		Databases: []DatabaseStatus{
+			{Name: "orders", Engine: "postgres", Endpoint: "orders.rds.amazonaws.com", State: "available"},
+			{Name: "payments", State: "not applied", Error: "unable to describe"},
+		},
+		ElasticSearch: []ElasticSearchStatus{
+			{Name: "logging", Endpoint: "logging.es.amazonaws.com", Health: "green"},
+		},
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testReport().WriteTable(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"ENVIRONMENT  dev",
+		"",
+		"NETWORK",
+		"vpc                    vpc-0a1b2c3d",
+		"cidr                   10.0.0.0/16",
+		"private subnets        subnet-1, subnet-2",
+		"utility subnets        -",
+		"master security group  -",
+		"worker security group  -",
+		"",
+		"CLUSTER        VALIDATION  MASTERS  NODES  LOGGING                               ERROR",
+		"dev.k8s.local  valid       3/3      3/4    rolling out 2/4 updated, 3 available",
+		"",
+		"DATABASE  ENGINE    ENDPOINT                  STATE        ERROR",
+		"orders    postgres  orders.rds.amazonaws.com  available",
+		"payments  -         -                         not applied  unable to describe",
+		"",
+		"ELASTICSEARCH  ENDPOINT                  HEALTH  ERROR",
+		"logging        logging.es.amazonaws.com  green",
+	}
+	// columns without an error are padded, so trailing spaces are ignored
+	var got []string
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		got = append(got, strings.TrimRight(line, " "))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestWriteJson(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testReport().WriteJson(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var report Report
+	if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatalf("expected the report as JSON, got %v\n%s", err, buf.String())
+	}
+	if !reflect.DeepEqual(report, testReport()) {
+		t.Errorf("expected %+v, got %+v", testReport(), report)
+	}
+	for _, field := range []string{`"environment-name": "dev"`, `"ready-masters": 3`, `"error": "unable to describe"`} {
+		if !strings.Contains(buf.String(), field) {
+			t.Errorf("expected %s in\n%s", field, buf.String())
+		}
+	}
+	if strings.Count(buf.String(), `"error"`) != 1 {
+		t.Errorf("expected error to be left out where there is none, got\n%s", buf.String())
+	}
+}
+
+func TestRolloutSummary(t *testing.T) {
+	tests := []struct {
+		name    string
+		rollout *kubernetes.Rollout
+		want    string
+	}{
+		{name: "unknown", rollout: nil, want: "-"},
+		{name: "not deployed", rollout: &kubernetes.Rollout{}, want: "not deployed"},
+		{name: "rolled out", rollout: &kubernetes.Rollout{Deployed: true, Desired: 3, Updated: 3, Available: 3}, want: "rolled out 3/3"},
+		{name: "rolling out", rollout: &kubernetes.Rollout{Deployed: true, Desired: 3, Updated: 1, Available: 2}, want: "rolling out 1/3 updated, 2 available"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := rolloutSummary(test.rollout); got != test.want {
+				t.Errorf("expected %q, got %q", test.want, got)
+			}
+		})
+	}
+}
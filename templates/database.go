@@ -1,138 +1,531 @@
 package templates
 
 import (
-	"bytes"
+	"fmt"
+	"github.com/infinityworks/fk-infra/aws"
+	"github.com/infinityworks/fk-infra/hcl"
+	"github.com/infinityworks/fk-infra/kubernetes"
 	"github.com/infinityworks/fk-infra/model"
+	"github.com/infinityworks/fk-infra/plan"
 	"github.com/infinityworks/fk-infra/terraform"
 	"github.com/infinityworks/fk-infra/util"
-	"text/template"
+	"log"
+	"strconv"
+	"strings"
+	"time"
 )
 
-const databaseTemplate = `
-terraform {
-  backend "s3" {
-    bucket = "{{$.ConfigBucket}}"
-    key    = "terraform/database/terraform.tfstate"
-    region = "{{$.Region}}"
-  }
-
-  required_version = ">= 0.9.3"
-}
-
-{{range .Databases}}
-output "database_output_{{.Name}}" {
-  value = "{\"name\":\"{{.Name}}\",\"endpoint\":\"${aws_db_instance.{{$.EnvironmentName}}-{{.Name}}.endpoint}\",\"password\":\"${aws_db_instance.{{$.EnvironmentName}}-{{.Name}}.password}\"}"
-}
-
-resource "aws_security_group" "{{$.EnvironmentName}}-{{.Name}}-database-access" {
-  vpc_id = "${aws_vpc.{{$.EnvironmentName}}.id}"
-  name = "{{$.EnvironmentName}}-{{.Name}}-database-access"
-  description = "Allow access to database"
-  ingress {
-      from_port = 3306
-      to_port = 3306
-      protocol = "tcp"
-      security_groups = ["${aws_security_group.k8s-nodes-{{$.EnvironmentName}}.id}"]
-  }
-  egress {
-      from_port = 0
-      to_port = 0
-      protocol = "-1"
-      cidr_blocks = ["0.0.0.0/0"]
-      self = true
-  }
-  tags {
-    Name = "{{.Name}}-database-access"
-  }
-}
-
-resource "aws_db_subnet_group" "{{$.EnvironmentName}}-{{.Name}}" {
-    name = "{{.Name}}-subnet"
-    description = "RDS subnet group"
-    subnet_ids = ["${aws_subnet.{{$.Region}}a-{{$.EnvironmentName}}.id}","${aws_subnet.{{$.Region}}b-{{$.EnvironmentName}}.id}"]
-}
-
-resource "aws_db_parameter_group" "{{$.EnvironmentName}}-{{.Name}}" {
-    name = "{{$.EnvironmentName}}-{{.Name}}"
-    family = "mysql8.0"
-    description = "Mysql parameter group"
-
-    parameter {
-      name = "slow_query_log"
-      value = 1
-   }
-
-}
-
-resource "aws_db_instance" "{{$.EnvironmentName}}-{{.Name}}" {
-  allocated_storage    = 120
-  engine               = "mysql"
-  engine_version       = "8.0"
-  instance_class       = "db.t3.small"
-  identifier           = "{{$.EnvironmentName}}-{{.Name}}"
-  name                 = "{{.Name}}"
-  username             = "{{.Name}}"
-  password             = "{{.Password}}"
-  db_subnet_group_name = "${aws_db_subnet_group.{{$.EnvironmentName}}-{{.Name}}.name}"
-  parameter_group_name = "${aws_db_parameter_group.{{$.EnvironmentName}}-{{.Name}}.name}"
-  multi_az             = "true"
-  vpc_security_group_ids = ["${aws_security_group.{{$.EnvironmentName}}-{{.Name}}-database-access.id}"]
-  storage_type         = "gp2"
-  backup_retention_period = 30
-  final_snapshot_identifier = "{{$.EnvironmentName}}-{{.Name}}-final-snapshot"
-  tags {
-      Name = "{{$.EnvironmentName}}-{{.Name}}"
-  }
-}
-
-{{end}}
-`
-
-func RenderDatabases(config *model.Config) {
+const (
+	defaultDatabaseEngine                = "mysql"
+	defaultDatabaseInstanceClass         = "db.t3.small"
+	defaultDatabaseAllocatedStorage      = 120
+	defaultDatabaseStorageType           = "gp2"
+	defaultDatabaseBackupRetentionPeriod = 30
+	databasePasswordLength               = 32
+	passwordPromotionAttempts            = 5
+)
+
+var databaseEngines = map[string]databaseEngine{
+	"mysql": {
+		port:                    3306,
+		defaultVersion:          "8.0",
+		defaultParameters:       []model.DatabaseParameter{{Name: "slow_query_log", Value: "1"}},
+		familyVersionComponents: func([]string) int { return 2 },
+	},
+	"mariadb": {
+		port:                    3306,
+		defaultVersion:          "10.3",
+		defaultParameters:       []model.DatabaseParameter{{Name: "slow_query_log", Value: "1"}},
+		familyVersionComponents: func([]string) int { return 2 },
+	},
+	"postgres": {
+		port:           5432,
+		defaultVersion: "10.6",
+		familyVersionComponents: func(versionComponents []string) int {
+			if major, err := strconv.Atoi(versionComponents[0]); err == nil && major >= 10 {
+				return 1
+			}
+			return 2
+		},
+	},
+}
+
+func RenderDatabases(config *model.Config) error {
+	databaseOutputs, err := fetchDatabaseOutputs()
+	if err != nil {
+		return err
+	}
+	return renderDatabases(config, false, func(databaseName string) (string, string, error) {
+		secretName, err := storeDatabasePassword(config, databaseName, databaseOutputs)
+		return secretName, "", err
+	})
+}
+
+// renderDatabasesForPlan creates nothing. Databases without a password secret yet read their password from a
+// variable instead, which is saved in the plan and stored in the secret once the plan is approved.
+func renderDatabasesForPlan(config *model.Config) error {
+	databaseOutputs, err := fetchDatabaseOutputs()
+	if err != nil {
+		return err
+	}
+	return renderDatabases(config, false, func(databaseName string) (string, string, error) {
+		secretName := databasePasswordSecret(config.Spec.EnvironmentName, databaseName)
+		_, exists, err := aws.FetchSecret(secretName, config.Spec.Region)
+		if err != nil || exists {
+			return secretName, "", err
+		}
+		password, err := initialDatabasePassword(databaseName, databaseOutputs)
+		if err != nil {
+			return "", "", err
+		}
+		log.Printf("Database %s has no password secret yet, %s will be created once the plan is approved", databaseName, secretName)
+		variable := databasePasswordVariable(databaseName)
+		terraform.SetVariable(terraform.ComponentDatabase, variable, password)
+		return secretName, variable, nil
+	})
+}
+
+// renderDatabasesForDestroy leaves the passwords out, terraform doesn't need them to destroy a database and
+// their secrets may already be gone.
+func renderDatabasesForDestroy(config *model.Config) error {
+	return renderDatabases(config, true, func(databaseName string) (string, string, error) {
+		return databasePasswordSecret(config.Spec.EnvironmentName, databaseName), "", nil
+	})
+}
+
+func fetchDatabaseOutputs() ([]terraform.DatabaseOutput, error) {
+	outputs, err := terraform.FetchTerraformOutputs()
+	if err != nil {
+		return nil, err
+	}
+	return outputs.DatabaseConfig()
+}
+
+// renderDatabases writes the databases, password returns the secret of each and the variable its password is
+// read from when the secret doesn't exist yet.
+func renderDatabases(config *model.Config, destroying bool, password func(databaseName string) (string, string, error)) error {
 	var databaseTemplates []DatabaseTemplate
-	databaseOutputs := terraform.FetchTerraformOutputs().DatabaseConfig()
 	for _, database := range config.Spec.Databases {
-		databaseTemplates = append(databaseTemplates, DatabaseTemplate{
-			Name:     database.Name,
-			Password: fetchOrGeneratePassword(databaseOutputs, database.Name),
-		})
+		secretName, passwordVariable, err := password(database.Name)
+		if err != nil {
+			return err
+		}
+		databaseTemplate, err := databaseTemplateFor(
+			database,
+			secretName,
+			finalSnapshotIdentifier(config.Spec.EnvironmentName, database.Name))
+		if err != nil {
+			return err
+		}
+		databaseTemplate.PasswordVariable = passwordVariable
+		databaseTemplates = append(databaseTemplates, databaseTemplate)
 	}
 
-	databaseTemplate := parseDatabasesTemplate(DatabasesTemplate{
+	return writeComponent(terraform.ComponentDatabase, databaseConfiguration(DatabasesTemplate{
 		ConfigBucket:    config.Spec.ConfigBucket,
 		Region:          config.Spec.Region,
 		EnvironmentName: config.Spec.EnvironmentName,
+		Destroying:      destroying,
 		Databases:       databaseTemplates,
-	})
+	}))
+}
+
+func databaseTemplateFor(database model.Database, passwordSecret, finalSnapshotIdentifier string) (DatabaseTemplate, error) {
+	engineName := defaultString(database.Engine, defaultDatabaseEngine)
+	engine, ok := databaseEngines[engineName]
+	if !ok {
+		return DatabaseTemplate{}, util.NewError(util.ConfigError, "database %s has unsupported engine %s, expected one of mysql, postgres or mariadb", database.Name, engineName)
+	}
+	engineVersion := defaultString(database.EngineVersion, engine.defaultVersion)
 
-	util.WriteFile("./databases.tf", databaseTemplate)
+	storageType := defaultString(database.StorageType, defaultDatabaseStorageType)
+	if storageType == "io1" && database.Iops == 0 {
+		return DatabaseTemplate{}, util.NewError(util.ConfigError, "database %s uses io1 storage which requires iops to be set", database.Name)
+	}
+
+	multiAz := true
+	if database.MultiAz != nil {
+		multiAz = *database.MultiAz
+	}
+
+	backupRetentionPeriod := defaultDatabaseBackupRetentionPeriod
+	if database.BackupRetentionPeriod != nil {
+		backupRetentionPeriod = *database.BackupRetentionPeriod
+	}
+
+	parameters := database.Parameters
+	if parameters == nil {
+		parameters = engine.defaultParameters
+	}
+
+	return DatabaseTemplate{
+		Name:                      database.Name,
+		PasswordSecret:            passwordSecret,
+		FinalSnapshotIdentifier:   finalSnapshotIdentifier,
+		Engine:                    engineName,
+		EngineVersion:             engineVersion,
+		Port:                      engine.port,
+		ParameterGroupFamily:      engine.parameterGroupFamily(engineName, engineVersion),
+		ParameterGroupDescription: fmt.Sprintf("%s%s parameter group", strings.ToUpper(engineName[:1]), engineName[1:]),
+		Parameters:                parameters,
+		InstanceClass:             defaultString(database.InstanceClass, defaultDatabaseInstanceClass),
+		AllocatedStorage:          defaultInt(database.AllocatedStorage, defaultDatabaseAllocatedStorage),
+		StorageType:               storageType,
+		Iops:                      database.Iops,
+		MultiAz:                   multiAz,
+		BackupRetentionPeriod:     backupRetentionPeriod,
+	}, nil
 }
 
-func parseDatabasesTemplate(databasesTemplate DatabasesTemplate) []byte {
-	var buf bytes.Buffer
-	tmpl, err := template.New("databaseTemplate").Parse(databaseTemplate)
-	util.CheckError(err)
-	util.CheckError(tmpl.Execute(&buf, databasesTemplate))
-	return buf.Bytes()
+// ValidateFinalSnapshots makes sure terraform will be able to take a final snapshot of each database when destroying it,
+// RDS refuses to overwrite an existing snapshot and leaves the instance running if the identifier is taken.
+func ValidateFinalSnapshots(config *model.Config) error {
+	for _, database := range config.Spec.Databases {
+		snapshotIdentifier := finalSnapshotIdentifier(config.Spec.EnvironmentName, database.Name)
+		exists, err := aws.DatabaseSnapshotExists(snapshotIdentifier, config.Spec.Region)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return util.NewError(util.ConfigError, "final snapshot %s already exists for database %s, copy it elsewhere and delete it before destroying", snapshotIdentifier, database.Name)
+		}
+		log.Printf("Database %s will be snapshotted to %s", database.Name, snapshotIdentifier)
+	}
+	return nil
+}
+
+func finalSnapshotIdentifier(environmentName, databaseName string) string {
+	return fmt.Sprintf("%s-%s-final-snapshot", environmentName, databaseName)
 }
 
-func fetchOrGeneratePassword(databaseOutputs []terraform.DatabaseOutput, databaseName string) string {
+// databaseConfiguration builds an RDS instance for each database in the private subnets of the network,
+// reachable from the kubernetes nodes.
+func databaseConfiguration(values DatabasesTemplate) []byte {
+	file := componentFile(terraform.ComponentDatabase, values.Region, values.ConfigBucket)
+	networkRemoteState(file, values.Region, values.ConfigBucket)
+
+	for _, database := range values.Databases {
+		name := values.EnvironmentName + "-" + database.Name
+		securityGroupName := name + "-database-access"
+
+		output(file, terraform.DatabaseOutputName(database.Name), hcl.Object{
+			"name":            hcl.String(database.Name),
+			"engine":          hcl.String(database.Engine),
+			"port":            hcl.String(strconv.Itoa(database.Port)),
+			"endpoint":        hcl.Reference("aws_db_instance", name, "endpoint"),
+			"password_secret": hcl.String(database.PasswordSecret),
+		})
+
+		// The password is read from Secrets Manager, or from a variable until its secret exists, when terraform
+		// runs so it is never written into the configuration
+		password := hcl.Reference("data.aws_secretsmanager_secret_version", name+"-password", "secret_string")
+		if database.PasswordVariable != "" {
+			file.Block("variable", database.PasswordVariable).
+				Set("type", hcl.Reference("string"))
+			password = hcl.Reference("var", database.PasswordVariable)
+		} else if !values.Destroying {
+			file.Block("data", "aws_secretsmanager_secret_version", name+"-password").
+				Set("secret_id", hcl.String(database.PasswordSecret))
+		}
+
+		securityGroup := file.Block("resource", "aws_security_group", securityGroupName)
+		securityGroup.Set("vpc_id", networkOutput("vpc_id")).
+			Set("name", hcl.String(securityGroupName)).
+			Set("description", hcl.String("Allow access to database"))
+		securityGroup.Block("ingress").
+			Set("from_port", hcl.Number(database.Port)).
+			Set("to_port", hcl.Number(database.Port)).
+			Set("protocol", hcl.String("tcp")).
+			Set("security_groups", hcl.List(networkOutput("worker_security_group_id")))
+		securityGroup.Block("egress").
+			Set("from_port", hcl.Number(0)).
+			Set("to_port", hcl.Number(0)).
+			Set("protocol", hcl.String("-1")).
+			Set("cidr_blocks", hcl.Strings("0.0.0.0/0")).
+			Set("self", hcl.Bool(true))
+		securityGroup.Set("tags", nameTag(database.Name+"-database-access"))
+
+		file.Block("resource", "aws_db_subnet_group", name).
+			Set("name", hcl.String(database.Name+"-subnet")).
+			Set("description", hcl.String("RDS subnet group")).
+			Set("subnet_ids", networkOutput("private_subnet_ids"))
+
+		// A new engine version can need a new family, which replaces the parameter group while the instance
+		// still uses it, so each group gets a unique name and the replacement is created first.
+		parameterGroup := file.Block("resource", "aws_db_parameter_group", name)
+		parameterGroup.Set("name_prefix", hcl.String(name+"-")).
+			Set("family", hcl.String(database.ParameterGroupFamily)).
+			Set("description", hcl.String(database.ParameterGroupDescription))
+		for _, parameter := range database.Parameters {
+			parameterBlock := parameterGroup.Block("parameter")
+			parameterBlock.Set("name", hcl.String(parameter.Name)).
+				Set("value", hcl.String(parameter.Value))
+			if parameter.ApplyMethod != "" {
+				parameterBlock.Set("apply_method", hcl.String(parameter.ApplyMethod))
+			}
+		}
+		parameterGroup.Block("lifecycle").
+			Set("create_before_destroy", hcl.Bool(true))
+
+		instance := file.Block("resource", "aws_db_instance", name)
+		instance.Set("allocated_storage", hcl.Number(database.AllocatedStorage)).
+			Set("engine", hcl.String(database.Engine)).
+			Set("engine_version", hcl.String(database.EngineVersion)).
+			Set("instance_class", hcl.String(database.InstanceClass)).
+			Set("identifier", hcl.String(name)).
+			Set("name", hcl.String(database.Name)).
+			Set("username", hcl.String(database.Name))
+		if !values.Destroying {
+			instance.Set("password", password)
+		}
+		instance.Set("db_subnet_group_name", hcl.Reference("aws_db_subnet_group", name, "name")).
+			Set("parameter_group_name", hcl.Reference("aws_db_parameter_group", name, "name")).
+			Set("multi_az", hcl.Bool(database.MultiAz)).
+			Set("vpc_security_group_ids", hcl.List(hcl.Reference("aws_security_group", securityGroupName, "id"))).
+			Set("storage_type", hcl.String(database.StorageType))
+		if database.Iops > 0 {
+			instance.Set("iops", hcl.Number(database.Iops))
+		}
+		instance.Set("backup_retention_period", hcl.Number(database.BackupRetentionPeriod)).
+			Set("skip_final_snapshot", hcl.Bool(false)).
+			Set("final_snapshot_identifier", hcl.String(database.FinalSnapshotIdentifier)).
+			Set("tags", nameTag(name))
+		// Passwords are changed by rotate, terraform only sets the password a database is created with
+		instance.Block("lifecycle").
+			Set("ignore_changes", hcl.List(hcl.Reference("password")))
+	}
+	return file.Bytes()
+}
+
+// storeDatabasePassword makes sure the password of a database is kept in Secrets Manager, encrypted with the
+// environment key, and returns the name of its secret.
+func storeDatabasePassword(config *model.Config, databaseName string, databaseOutputs []terraform.DatabaseOutput) (string, error) {
+	secretName := databasePasswordSecret(config.Spec.EnvironmentName, databaseName)
+	_, exists, err := aws.FetchSecret(secretName, config.Spec.Region)
+	if err != nil || exists {
+		return secretName, err
+	}
+	password, err := initialDatabasePassword(databaseName, databaseOutputs)
+	if err != nil {
+		return "", err
+	}
+	return secretName, createDatabasePassword(config, databaseName, password)
+}
+
+// initialDatabasePassword is the password a database gets its secret created with. Databases applied before
+// passwords were kept there still have theirs in the terraform outputs, which is moved across rather than replaced.
+func initialDatabasePassword(databaseName string, databaseOutputs []terraform.DatabaseOutput) (string, error) {
 	for _, databaseOutput := range databaseOutputs {
-		if databaseOutput.Name == databaseName {
-			return databaseOutput.Password
+		if databaseOutput.Name == databaseName && databaseOutput.LegacyPassword != "" {
+			return databaseOutput.LegacyPassword, nil
+		}
+	}
+	return util.RandomAlphaNumeric(databasePasswordLength)
+}
+
+func createDatabasePassword(config *model.Config, databaseName, password string) error {
+	secretName := databasePasswordSecret(config.Spec.EnvironmentName, databaseName)
+	log.Printf("Storing the password of database %s in secret %s", databaseName, secretName)
+	description := fmt.Sprintf("Password of the %s database in %s", databaseName, config.Spec.EnvironmentName)
+	return aws.CreateSecret(secretName, description, config.Spec.EncryptionKey, password, config.Spec.Region)
+}
+
+// CreatePlannedDatabasePasswords creates the secrets of the databases planned without one, holding the passwords
+// the plan uses. A secret created since the plan was made would no longer match the plan.
+func CreatePlannedDatabasePasswords(config *model.Config, planFile string) error {
+	planJson, planned, err := terraform.ShowPlan(planFile, terraform.ComponentDatabase)
+	if err != nil || !planned {
+		return err
+	}
+	for _, database := range config.Spec.Databases {
+		password, found, err := plan.PlannedVariable(planJson, databasePasswordVariable(database.Name))
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+		secretName := databasePasswordSecret(config.Spec.EnvironmentName, database.Name)
+		if _, exists, err := aws.FetchSecret(secretName, config.Spec.Region); err != nil {
+			return err
+		} else if exists {
+			return util.NewError(util.ConfigError, "secret %s has been created since %s was planned, run plan again", secretName, planFile)
+		}
+		if err := createDatabasePassword(config, database.Name, password); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RotateDatabasePassword replaces the password of a database in a single step. The new password is added to its
+// secret as a pending version and made current as soon as RDS accepts it, which is the point of no return. If RDS
+// rejects it the old password stays current and running it again starts over with a fresh password. Once RDS has
+// applied it the Secret in each cluster is updated and the deployments reading it are restarted.
+func RotateDatabasePassword(config *model.Config, databaseName string) error {
+	database := databaseInConfig(config, databaseName)
+	if database == nil {
+		return util.NewError(util.ConfigError, "database %s is not in fk-infra.yml", databaseName)
+	}
+	outputs, err := terraform.FetchTerraformOutputs()
+	if err != nil {
+		return err
+	}
+	databaseOutputs, err := outputs.DatabaseConfig()
+	if err != nil {
+		return err
+	}
+	var databaseOutput *terraform.DatabaseOutput
+	for i := range databaseOutputs {
+		if databaseOutputs[i].Name == databaseName {
+			databaseOutput = &databaseOutputs[i]
+		}
+	}
+	if databaseOutput == nil {
+		return util.NewError(util.ConfigError, "database %s has not been applied yet", databaseName)
+	}
+	if databaseOutput.PasswordSecret == "" {
+		return util.NewError(util.ConfigError, "database %s still has its password in terraform state, run apply before rotating it", databaseName)
+	}
+
+	password, err := util.RandomAlphaNumeric(databasePasswordLength)
+	if err != nil {
+		return err
+	}
+	versionId, err := aws.PutPendingSecretValue(databaseOutput.PasswordSecret, password, config.Spec.Region)
+	if err != nil {
+		return err
+	}
+	identifier := fmt.Sprintf("%s-%s", config.Spec.EnvironmentName, databaseName)
+	log.Printf("Changing the password of database %s", identifier)
+	if err := aws.ModifyDatabasePassword(identifier, password, config.Spec.Region); err != nil {
+		log.Printf("The previous password of database %s is still current in %s, rotate again to retry", databaseName, databaseOutput.PasswordSecret)
+		return err
+	}
+
+	// RDS has accepted the new password, so the secret has to follow whatever else fails from here on
+	if err := promoteDatabasePassword(databaseOutput.PasswordSecret, versionId, config.Spec.Region); err != nil {
+		return err
+	}
+	if err := aws.WaitForDatabasePassword(identifier, config.Spec.Region); err != nil {
+		log.Printf("The new password of database %s is current in %s, run apply once RDS has applied it to update the clusters", databaseName, databaseOutput.PasswordSecret)
+		return err
+	}
+	log.Printf("Password of database %s rotated", databaseName)
+
+	for _, kubernetesCluster := range config.Spec.Kubernetes {
+		client, err := KubernetesClient(config, kubernetesCluster.Name)
+		if err != nil {
+			return err
+		}
+		if err := kubernetes.ApplySecrets(kubernetes.NewApplier(client, config.Spec.EnvironmentName, false), config.Spec.Databases, outputs, config.Spec.Region); err != nil {
+			return err
+		}
+		if err := kubernetes.RestartDeploymentsUsingDatabaseSecret(client, *database); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// promoteDatabasePassword retries making the new password current, as RDS is already switching to it.
+func promoteDatabasePassword(secretName, versionId, region string) error {
+	var err error
+	for attempt := 1; attempt <= passwordPromotionAttempts; attempt++ {
+		if err = aws.PromoteSecretVersion(secretName, versionId, region); err == nil {
+			return nil
 		}
+		log.Printf("Unable to make the new password current in %s, attempt %d of %d: %v", secretName, attempt, passwordPromotionAttempts, err)
+		time.Sleep(time.Duration(attempt) * 5 * time.Second)
 	}
-	return util.RandomAlphaNumeric(32)
+	return util.WrapError(util.CloudError, err, "the database now uses version %s of secret %s but it could not be made current, "+
+		"move AWSCURRENT to it with aws secretsmanager update-secret-version-stage", versionId, secretName)
+}
+
+func databaseInConfig(config *model.Config, databaseName string) *model.Database {
+	for i := range config.Spec.Databases {
+		if config.Spec.Databases[i].Name == databaseName {
+			return &config.Spec.Databases[i]
+		}
+	}
+	return nil
+}
+
+func databasePasswordSecret(environmentName, databaseName string) string {
+	return fmt.Sprintf("%s%s/password", databasePasswordSecretPrefix(environmentName), databaseName)
+}
+
+// databasePasswordVariable holds the password of a database that has no secret yet while it is planned.
+func databasePasswordVariable(databaseName string) string {
+	return "database_password_" + databaseName
+}
+
+func databasePasswordSecretPrefix(environmentName string) string {
+	return fmt.Sprintf("%s/database/", environmentName)
+}
+
+// DeleteDatabasePasswords schedules deletion of the password secrets of every database in the environment,
+// including databases no longer in the config. They are created outside terraform so destroy doesn't remove
+// them. Until they are deleted they can be restored to open the final snapshots, which keep the same passwords.
+func DeleteDatabasePasswords(config *model.Config, approved bool) error {
+	secretNames, err := aws.ListSecrets(databasePasswordSecretPrefix(config.Spec.EnvironmentName), config.Spec.Region)
+	if err != nil {
+		return err
+	}
+	for _, secretName := range secretNames {
+		if !approved {
+			log.Printf("Database password secret %s would be scheduled for deletion", secretName)
+			continue
+		}
+		if err := aws.DeleteSecret(secretName, config.Spec.Region); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 type DatabasesTemplate struct {
 	Region          string
 	ConfigBucket    string
 	EnvironmentName string
-	Databases       []DatabaseTemplate
+	// Destroying leaves out the passwords, which terraform only needs to create a database
+	Destroying bool
+	Databases  []DatabaseTemplate
 }
 
 type DatabaseTemplate struct {
-	Name     string
-	Password string
+	Name                      string
+	PasswordSecret            string
+	PasswordVariable          string
+	FinalSnapshotIdentifier   string
+	Engine                    string
+	EngineVersion             string
+	Port                      int
+	ParameterGroupFamily      string
+	ParameterGroupDescription string
+	Parameters                []model.DatabaseParameter
+	InstanceClass             string
+	AllocatedStorage          int
+	StorageType               string
+	Iops                      int
+	MultiAz                   bool
+	BackupRetentionPeriod     int
+}
+
+type databaseEngine struct {
+	port              int
+	defaultVersion    string
+	defaultParameters []model.DatabaseParameter
+	// Number of leading version components that make up the parameter group family, postgres
+	// dropped the minor version from its family from version 10 onwards
+	familyVersionComponents func(versionComponents []string) int
+}
+
+func (engine databaseEngine) parameterGroupFamily(engineName, engineVersion string) string {
+	versionComponents := strings.Split(engineVersion, ".")
+	familyComponents := engine.familyVersionComponents(versionComponents)
+	if familyComponents > len(versionComponents) {
+		familyComponents = len(versionComponents)
+	}
+	return engineName + strings.Join(versionComponents[:familyComponents], ".")
 }
@@ -0,0 +1,97 @@
+package templates
+
+import (
+	"github.com/infinityworks/fk-infra/model"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestDatabasePasswords(t *testing.T) {
+	tests := []struct {
+		name             string
+		destroying       bool
+		passwordVariable string
+		want             []string
+		wantAbsent       []string
+	}{
+		{
+			name: "read from the secret",
+			want: []string{
+				`data "aws_secretsmanager_secret_version" "dev-orders-password" {`,
+				`secret_id = "dev/database/orders/password"`,
+				`password                  = data.aws_secretsmanager_secret_version.dev-orders-password.secret_string`,
+			},
+			wantAbsent: []string{`variable "`},
+		},
+		{
+			name:             "planned before the secret exists",
+			passwordVariable: "database_password_orders",
+			want: []string{
+				`variable "database_password_orders" {`,
+				`type = string`,
+				`password                  = var.database_password_orders`,
+			},
+			wantAbsent: []string{`aws_secretsmanager_secret_version`},
+		},
+		{
+			name:       "destroyed",
+			destroying: true,
+			wantAbsent: []string{`aws_secretsmanager_secret_version`, `variable "`, `password `},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			database, err := databaseTemplateFor(model.Database{Name: "orders"}, "dev/database/orders/password", "dev-orders-final-snapshot")
+			if err != nil {
+				t.Fatal(err)
+			}
+			database.PasswordVariable = test.passwordVariable
+			configuration := string(databaseConfiguration(DatabasesTemplate{
+				Region:          "eu-west-1",
+				ConfigBucket:    "dev-config",
+				EnvironmentName: "dev",
+				Destroying:      test.destroying,
+				Databases:       []DatabaseTemplate{database},
+			}))
+
+			for _, want := range test.want {
+				if !strings.Contains(configuration, want) {
+					t.Errorf("expected %q in\n%s", want, configuration)
+				}
+			}
+			for _, absent := range test.wantAbsent {
+				if strings.Contains(configuration, absent) {
+					t.Errorf("expected no %q in\n%s", absent, configuration)
+				}
+			}
+		})
+	}
+}
+
+func TestDatabaseParameterGroupIsReplacedBeforeItIsDestroyed(t *testing.T) {
+	database, err := databaseTemplateFor(model.Database{Name: "orders"}, "dev/database/orders/password", "dev-orders-final-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configuration := string(databaseConfiguration(DatabasesTemplate{
+		Region:          "eu-west-1",
+		ConfigBucket:    "dev-config",
+		EnvironmentName: "dev",
+		Databases:       []DatabaseTemplate{database},
+	}))
+
+	start := strings.Index(configuration, `resource "aws_db_parameter_group" "dev-orders" {`)
+	if start < 0 {
+		t.Fatalf("expected a parameter group in\n%s", configuration)
+	}
+	parameterGroup := configuration[start : start+strings.Index(configuration[start:], "\n}\n")]
+	for _, want := range []string{`name_prefix = "dev-orders-"`, "create_before_destroy = true"} {
+		if !strings.Contains(parameterGroup, want) {
+			t.Errorf("expected %q in\n%s", want, parameterGroup)
+		}
+	}
+	if regexp.MustCompile(`(?m)^  name\s+=`).MatchString(parameterGroup) {
+		t.Errorf("expected no fixed name in\n%s", parameterGroup)
+	}
+}
@@ -1,157 +1,276 @@
 package templates
 
 import (
-	"bytes"
+	"fmt"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/infinityworks/fk-infra/aws"
+	"github.com/infinityworks/fk-infra/hcl"
 	"github.com/infinityworks/fk-infra/model"
+	"github.com/infinityworks/fk-infra/terraform"
 	"github.com/infinityworks/fk-infra/util"
 	"log"
-	"text/template"
+	"sort"
 )
 
-const elasticSearchTemplate = `
-terraform {
-  backend "s3" {
-    bucket = "{{$.ConfigBucket}}"
-    key    = "terraform/elasticsearch/terraform.tfstate"
-    region = "{{$.Region}}"
-  }
+const (
+	defaultElasticSearchVersion       = "6.4"
+	defaultElasticSearchInstanceType  = "m3.medium.elasticsearch"
+	defaultElasticSearchInstanceCount = 4
+	defaultElasticSearchVolumeSize    = 100
+	defaultElasticSearchVolumeType    = "gp2"
+	defaultElasticSearchSnapshotHour  = 3
+)
 
-  required_version = ">= 0.9.3"
+func RenderElasticSearch(config *model.Config) error {
+	if err := createAwsElasticSearchServiceRole(config.Spec.Region); err != nil {
+		return err
+	}
+	roleArns, err := kopsRoleArns(config)
+	if err != nil {
+		return err
+	}
+	clusters, err := clusterTemplates(config.Spec.EnvironmentName, roleArns, config.Spec.ElasticSearch)
+	if err != nil {
+		return err
+	}
+	return writeComponent(terraform.ComponentElasticSearch, elasticSearchConfiguration(ElasticSearchTemplate{
+		EnvironmentName: config.Spec.EnvironmentName,
+		Region:          config.Spec.Region,
+		ConfigBucket:    config.Spec.ConfigBucket,
+		Clusters:        clusters,
+	}))
 }
 
-data "aws_region" "current" {}
-
-data "aws_caller_identity" "current" {}
-
-{{range .Clusters}}
-resource "aws_security_group" "{{$.EnvironmentName}}-{{.Name}}-elasticsearch" {
-  name = "{{$.EnvironmentName}}-{{.Name}}-elasticsearch"
-  description = "Managed by Terraform"
-  vpc_id = "${aws_vpc.{{$.EnvironmentName}}.id}"
-
-  ingress {
-    from_port = 443
-    to_port = 443
-    protocol = "tcp"
-
-    security_groups = [
-      "${aws_security_group.k8s-masters-{{$.EnvironmentName}}.id}",
-      "${aws_security_group.k8s-nodes-{{$.EnvironmentName}}.id}"
-    ]
-  }
+// kopsRoleArns returns the roles kops creates for the masters and nodes of every cluster, along with whether
+// each one exists yet. Domains are created before the clusters so the roles are missing on a first apply.
+func kopsRoleArns(config *model.Config) (map[string]bool, error) {
+	roleArns := make(map[string]bool)
+	for _, kubernetesCluster := range config.Spec.Kubernetes {
+		for _, roleName := range []string{"masters." + kubernetesCluster.Name, "nodes." + kubernetesCluster.Name} {
+			exists, err := aws.IamRoleExists(roleName, config.Spec.Region)
+			if err != nil {
+				return nil, err
+			}
+			roleArns[iamRoleArn(roleName)] = exists
+		}
+	}
+	return roleArns, nil
 }
 
-resource "aws_elasticsearch_domain" "{{$.EnvironmentName}}-{{.Name}}" {
-  domain_name = "{{$.EnvironmentName}}-{{.Name}}"
-  elasticsearch_version = "6.4"
-
-  cluster_config {
-    instance_type = "m3.medium.elasticsearch"
-    dedicated_master_enabled = false
-    zone_awareness_enabled = true
-    instance_count = 4
-  }
-
-  ebs_options {
-    ebs_enabled = true
-    volume_size = 100
-    volume_type = "gp2"
-  }
-
-  vpc_options {
-    subnet_ids = [
-      "${aws_subnet.{{$.Region}}a-{{$.EnvironmentName}}.id}",
-      "${aws_subnet.{{$.Region}}b-{{$.EnvironmentName}}.id}"
-    ]
-
-    security_group_ids = [
-      "${aws_security_group.{{$.EnvironmentName}}-{{.Name}}-elasticsearch.id}"]
-  }
-
-  advanced_options {
-    "rest.action.multi.allow_explicit_index" = "true"
-  }
-
-  access_policies = <<CONFIG
-{
-    "Version": "2012-10-17",
-    "Statement": [
-        {
-            "Action": "es:*",
-            "Principal": "*",
-            "Effect": "Allow",
-            "Resource": "arn:aws:es:${data.aws_region.current.name}:${data.aws_caller_identity.current.account_id}:domain/{{.Name}}/*"
-        }
-    ]
+func iamRoleArn(roleName string) string {
+	return fmt.Sprintf("arn:aws:iam::${data.aws_caller_identity.current.account_id}:role/%s", roleName)
 }
-CONFIG
 
-  snapshot_options {
-    automated_snapshot_start_hour = 3
-  }
+// AWS rejects a domain policy naming a principal that doesn't exist, so until the kops roles have been created
+// access is granted to the account and narrowed down to the expected roles with a condition instead. The policy
+// is written as a heredoc so the principals from the config are escaped, only the role ARNs are interpolated.
+func elasticSearchAccessPolicy(environmentName, domainName string, kopsRoleArns map[string]bool, accessPrincipals []string) (string, error) {
+	var existingPrincipals, expectedPrincipals []string
+	for roleArn, exists := range kopsRoleArns {
+		if exists {
+			existingPrincipals = append(existingPrincipals, roleArn)
+		}
+		expectedPrincipals = append(expectedPrincipals, roleArn)
+	}
+	for _, accessPrincipal := range accessPrincipals {
+		existingPrincipals = append(existingPrincipals, hcl.EscapeTemplate(accessPrincipal))
+		expectedPrincipals = append(expectedPrincipals, hcl.EscapeTemplate(accessPrincipal))
+	}
+	sort.Strings(existingPrincipals)
+	sort.Strings(expectedPrincipals)
 
-  tags {
-    Name = "{{.Name}}"
-    Domain = "{{.Name}}"
-  }
-}
+	policy := NewAllowIamPolicy().
+		Actions("es:*").
+		Resources(fmt.Sprintf("arn:aws:es:${data.aws_region.current.name}:${data.aws_caller_identity.current.account_id}:domain/%s-%s/*", environmentName, domainName))
 
-output "elasticsearch_output_{{.Name}}" {
-  value = "{\"name\":\"{{.Name}}\",\"endpoint\":\"${aws_elasticsearch_domain.{{$.EnvironmentName}}-{{.Name}}.endpoint}\",\"arn\":\"${aws_elasticsearch_domain.{{$.EnvironmentName}}-{{.Name}}.arn}\"}"
-}
-{{end}}
-`
-
-func RenderElasticSearch(config *model.Config) {
-	createAwsElasticSearchServiceRole(config.Spec.Region)
-	terraformTemplate := parseElasticSearchTemplate(
-		config.Spec.EnvironmentName,
-		config.Spec.Region,
-		config.Spec.ConfigBucket,
-		config.Spec.ElasticSearch)
-	util.WriteFile("./elasticsearch.tf", terraformTemplate)
+	accountPrincipal := "arn:aws:iam::${data.aws_caller_identity.current.account_id}:root"
+	if len(expectedPrincipals) == 0 {
+		// Without any clusters or extra principals access is left to the IAM policies within the account
+		policy.Principals("AWS", accountPrincipal)
+	} else if len(existingPrincipals) == len(expectedPrincipals) {
+		policy.Principals("AWS", existingPrincipals...)
+	} else {
+		log.Printf("Not all roles for ElasticSearch %s exist yet, granting access by condition until the next apply", domainName)
+		policy.Principals("AWS", accountPrincipal).
+			Conditions("ArnLike", "aws:PrincipalArn", expectedPrincipals...)
+	}
+
+	return IamPolicyDocumentJsonString([]*IamPolicy{policy})
 }
 
-func createAwsElasticSearchServiceRole(region string) {
-	_, err := iam.New(aws.NewSession(region)).CreateServiceLinkedRole(&iam.CreateServiceLinkedRoleInput{
+func createAwsElasticSearchServiceRole(region string) error {
+	awsSession, err := aws.NewSession(region)
+	if err != nil {
+		return err
+	}
+	_, err = iam.New(awsSession).CreateServiceLinkedRole(&iam.CreateServiceLinkedRoleInput{
 		AWSServiceName: util.String("es.amazonaws.com"),
 	})
 	if errWithCode, ok := err.(awserr.Error); ok && iam.ErrCodeInvalidInputException == errWithCode.Code() {
 		log.Println("ElasticSearch service role already exists")
-	} else {
-		util.CheckError(errWithCode)
+		return nil
 	}
+	return util.WrapError(util.CloudError, err, "unable to create ElasticSearch service role")
 }
 
-func parseElasticSearchTemplate(environmentName, region, configBucket string, elasticSearchSpec []model.ElasticSearch) []byte {
-	var buf bytes.Buffer
-	tmpl, err := template.New("elasticSearchTemplate").Parse(elasticSearchTemplate)
-	util.CheckError(err)
-	err = tmpl.Execute(&buf, ElasticSearchTemplate{
-		EnvironmentName: environmentName,
-		Region:          region,
-		ConfigBucket:    configBucket,
-		Clusters:        clusterTemplates(elasticSearchSpec),
-	})
-	util.CheckError(err)
-	return buf.Bytes()
+// elasticSearchConfiguration builds a domain for each cluster inside the VPC, reachable from the kubernetes
+// masters and nodes.
+func elasticSearchConfiguration(values ElasticSearchTemplate) []byte {
+	file := componentFile(terraform.ComponentElasticSearch, values.Region, values.ConfigBucket)
+	networkRemoteState(file, values.Region, values.ConfigBucket)
+	file.Block("data", "aws_region", "current")
+	file.Block("data", "aws_caller_identity", "current")
+
+	for _, cluster := range values.Clusters {
+		name := values.EnvironmentName + "-" + cluster.Name
+		securityGroupName := name + "-elasticsearch"
+
+		securityGroup := file.Block("resource", "aws_security_group", securityGroupName)
+		securityGroup.Set("name", hcl.String(securityGroupName)).
+			Set("description", hcl.String("Managed by Terraform")).
+			Set("vpc_id", networkOutput("vpc_id"))
+		securityGroup.Block("ingress").
+			Set("from_port", hcl.Number(443)).
+			Set("to_port", hcl.Number(443)).
+			Set("protocol", hcl.String("tcp")).
+			Set("security_groups", hcl.List(networkOutput("master_security_group_id"), networkOutput("worker_security_group_id")))
+
+		domain := file.Block("resource", "aws_elasticsearch_domain", name)
+		domain.Set("domain_name", hcl.String(name)).
+			Set("elasticsearch_version", hcl.String(cluster.Version))
+
+		clusterConfig := domain.Block("cluster_config")
+		clusterConfig.Set("instance_type", hcl.String(cluster.InstanceType)).
+			Set("dedicated_master_enabled", hcl.Bool(cluster.DedicatedMasterCount > 0))
+		if cluster.DedicatedMasterCount > 0 {
+			clusterConfig.Set("dedicated_master_count", hcl.Number(cluster.DedicatedMasterCount)).
+				Set("dedicated_master_type", hcl.String(cluster.DedicatedMasterType))
+		}
+		clusterConfig.Set("zone_awareness_enabled", hcl.Bool(cluster.ZoneAwareness)).
+			Set("instance_count", hcl.Number(cluster.InstanceCount))
+
+		domain.Block("ebs_options").
+			Set("ebs_enabled", hcl.Bool(true)).
+			Set("volume_size", hcl.Number(cluster.VolumeSize)).
+			Set("volume_type", hcl.String(cluster.VolumeType))
+		if cluster.EncryptionAtRest {
+			domain.Block("encrypt_at_rest").
+				Set("enabled", hcl.Bool(true))
+		}
+		if cluster.NodeToNodeEncryption {
+			domain.Block("node_to_node_encryption").
+				Set("enabled", hcl.Bool(true))
+		}
+
+		domain.Block("vpc_options").
+			Set("subnet_ids", hcl.Call("slice", networkOutput("private_subnet_ids"), hcl.Number(0), hcl.Number(cluster.SubnetCount))).
+			Set("security_group_ids", hcl.List(hcl.Reference("aws_security_group", securityGroupName, "id")))
+
+		// The policy refers to the region and account through interpolation so it is kept as a template
+		domain.Set("advanced_options", hcl.Object{"rest.action.multi.allow_explicit_index": hcl.String("true")}).
+			Set("access_policies", hcl.Heredoc(cluster.AccessPolicy))
+
+		domain.Block("snapshot_options").
+			Set("automated_snapshot_start_hour", hcl.Number(cluster.SnapshotHour))
+
+		domain.Set("tags", hcl.Object{
+			"Name":   hcl.String(cluster.Name),
+			"Domain": hcl.String(cluster.Name),
+		})
+
+		output(file, terraform.ElasticSearchOutputName(cluster.Name), hcl.Object{
+			"name":     hcl.String(cluster.Name),
+			"endpoint": hcl.Reference("aws_elasticsearch_domain", name, "endpoint"),
+			"arn":      hcl.Reference("aws_elasticsearch_domain", name, "arn"),
+		})
+	}
+	return file.Bytes()
 }
 
-func clusterTemplates(elasticSearchClusters []model.ElasticSearch) []ElasticSearchClusterTemplate {
+// validateElasticSearch rejects what AWS would only reject part way through an apply.
+func validateElasticSearch(elasticSearchClusters []model.ElasticSearch) error {
+	for _, cluster := range elasticSearchClusters {
+		// AWS only accepts 3 or 5 dedicated masters
+		if count := cluster.DedicatedMasterCount; count != 0 && count != 3 && count != 5 {
+			return util.NewError(util.ConfigError, "ElasticSearch %s has dedicated master count %d, expected 3 or 5, or 0 for no dedicated masters", cluster.Name, count)
+		}
+	}
+	return nil
+}
+
+func clusterTemplates(environmentName string, kopsRoleArns map[string]bool, elasticSearchClusters []model.ElasticSearch) ([]ElasticSearchClusterTemplate, error) {
 	var elasticSearchClusterTemplates []ElasticSearchClusterTemplate
 	for _, cluster := range elasticSearchClusters {
+		zoneAwareness := true
+		if cluster.ZoneAwareness != nil {
+			zoneAwareness = *cluster.ZoneAwareness
+		}
+
+		instanceCount := defaultInt(cluster.InstanceCount, defaultElasticSearchInstanceCount)
+		if zoneAwareness && instanceCount%2 != 0 {
+			return nil, util.NewError(util.ConfigError, "ElasticSearch %s has zone awareness enabled which requires an even instance count, found %d", cluster.Name, instanceCount)
+		}
+
+		dedicatedMasterType := ""
+		if cluster.DedicatedMasterCount > 0 {
+			dedicatedMasterType = defaultString(cluster.DedicatedMasterType, defaultString(cluster.InstanceType, defaultElasticSearchInstanceType))
+		}
+
+		snapshotHour := defaultElasticSearchSnapshotHour
+		if cluster.SnapshotHour != nil {
+			snapshotHour = *cluster.SnapshotHour
+		}
+		if snapshotHour < 0 || snapshotHour > 23 {
+			return nil, util.NewError(util.ConfigError, "ElasticSearch %s has snapshot hour %d, expected 0 to 23", cluster.Name, snapshotHour)
+		}
+
+		// Zone awareness spreads a domain over exactly two subnets, whatever number of zones the network has
+		subnetCount := 1
+		if zoneAwareness {
+			subnetCount = 2
+		}
+
+		accessPolicy, err := elasticSearchAccessPolicy(environmentName, cluster.Name, kopsRoleArns, cluster.AccessPrincipals)
+		if err != nil {
+			return nil, err
+		}
+
 		elasticSearchClusterTemplates = append(elasticSearchClusterTemplates, ElasticSearchClusterTemplate{
-			Name: cluster.Name,
+			Name:                 cluster.Name,
+			Version:              defaultString(cluster.Version, defaultElasticSearchVersion),
+			InstanceType:         defaultString(cluster.InstanceType, defaultElasticSearchInstanceType),
+			InstanceCount:        instanceCount,
+			VolumeSize:           defaultInt(cluster.VolumeSize, defaultElasticSearchVolumeSize),
+			VolumeType:           defaultString(cluster.VolumeType, defaultElasticSearchVolumeType),
+			DedicatedMasterCount: cluster.DedicatedMasterCount,
+			DedicatedMasterType:  dedicatedMasterType,
+			ZoneAwareness:        zoneAwareness,
+			EncryptionAtRest:     cluster.EncryptionAtRest,
+			NodeToNodeEncryption: cluster.NodeToNodeEncryption,
+			SnapshotHour:         snapshotHour,
+			SubnetCount:          subnetCount,
+			AccessPolicy:         accessPolicy,
 		})
 	}
-	return elasticSearchClusterTemplates
+	return elasticSearchClusterTemplates, nil
 }
 
 type ElasticSearchClusterTemplate struct {
-	Name string
+	Name                 string
+	Version              string
+	InstanceType         string
+	InstanceCount        int
+	VolumeSize           int
+	VolumeType           string
+	DedicatedMasterCount int
+	DedicatedMasterType  string
+	ZoneAwareness        bool
+	EncryptionAtRest     bool
+	NodeToNodeEncryption bool
+	SnapshotHour         int
+	SubnetCount          int
+	AccessPolicy         string
 }
 
 type ElasticSearchTemplate struct {
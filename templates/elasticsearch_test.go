@@ -0,0 +1,37 @@
+package templates
+
+import (
+	"github.com/infinityworks/fk-infra/model"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestValidateDedicatedMasterCount(t *testing.T) {
+	tests := []struct {
+		count   int
+		wantErr string
+	}{
+		{count: 0},
+		{count: 3},
+		{count: 5},
+		{count: 1, wantErr: "ElasticSearch logging has dedicated master count 1, expected 3 or 5"},
+		{count: 2, wantErr: "ElasticSearch logging has dedicated master count 2, expected 3 or 5"},
+		{count: 4, wantErr: "ElasticSearch logging has dedicated master count 4, expected 3 or 5"},
+		{count: -3, wantErr: "ElasticSearch logging has dedicated master count -3, expected 3 or 5"},
+	}
+	for _, test := range tests {
+		t.Run(strconv.Itoa(test.count), func(t *testing.T) {
+			err := ValidateConfig(model.Spec{
+				EnvironmentName: "dev",
+				ElasticSearch:   []model.ElasticSearch{{Name: "logging", DedicatedMasterCount: test.count}},
+			})
+			if test.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Fatalf("expected error containing %q, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
@@ -5,10 +5,19 @@ import (
 	"github.com/infinityworks/fk-infra/util"
 )
 
+const iamPolicyVersion = "2012-10-17"
+
 type IamPolicy struct {
-	Effect   string   `json:"Effect"`
-	Action   []string `json:"Action"`
-	Resource []string `json:"Resource"`
+	Effect    string                         `json:"Effect"`
+	Principal map[string][]string            `json:"Principal,omitempty"`
+	Action    []string                       `json:"Action"`
+	Resource  []string                       `json:"Resource"`
+	Condition map[string]map[string][]string `json:"Condition,omitempty"`
+}
+
+type IamPolicyDocument struct {
+	Version   string       `json:"Version"`
+	Statement []*IamPolicy `json:"Statement"`
 }
 
 func NewAllowIamPolicy() *IamPolicy {
@@ -27,11 +36,37 @@ func (policy *IamPolicy) Resources(resources ...string) *IamPolicy {
 	return policy
 }
 
-func IamPolicyJsonString(policies []*IamPolicy) string {
+func (policy *IamPolicy) Principals(principalType string, principals ...string) *IamPolicy {
+	if policy.Principal == nil {
+		policy.Principal = make(map[string][]string)
+	}
+	policy.Principal[principalType] = principals
+	return policy
+}
+
+func (policy *IamPolicy) Conditions(operator, key string, values ...string) *IamPolicy {
+	if policy.Condition == nil {
+		policy.Condition = make(map[string]map[string][]string)
+	}
+	if policy.Condition[operator] == nil {
+		policy.Condition[operator] = make(map[string][]string)
+	}
+	policy.Condition[operator][key] = values
+	return policy
+}
+
+func IamPolicyJsonString(policies []*IamPolicy) (string, error) {
 	if policies != nil {
 		bytes, err := json.Marshal(policies)
-		util.CheckError(err)
-		return string(bytes)
+		return string(bytes), util.WrapError(util.UnknownError, err, "unable to encode IAM policies")
 	}
-	return ""
+	return "", nil
+}
+
+func IamPolicyDocumentJsonString(policies []*IamPolicy) (string, error) {
+	bytes, err := json.MarshalIndent(IamPolicyDocument{
+		Version:   iamPolicyVersion,
+		Statement: policies,
+	}, "", "  ")
+	return string(bytes), util.WrapError(util.UnknownError, err, "unable to encode IAM policy document")
 }
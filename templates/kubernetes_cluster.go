@@ -2,14 +2,24 @@ package templates
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"github.com/ericchiang/k8s"
+	"github.com/ghodss/yaml"
+	"github.com/infinityworks/fk-infra/aws"
 	"github.com/infinityworks/fk-infra/crypto"
 	"github.com/infinityworks/fk-infra/kops"
 	"github.com/infinityworks/fk-infra/kubernetes"
 	"github.com/infinityworks/fk-infra/model"
+	"github.com/infinityworks/fk-infra/plan"
 	"github.com/infinityworks/fk-infra/terraform"
 	"github.com/infinityworks/fk-infra/util"
-	"text/template"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
 )
 
@@ -29,20 +39,16 @@ spec:
   configBase: s3://{{.ConfigBucket}}/kops
   etcdClusters:
   - etcdMembers:
-    - instanceGroup: master-{{.Region}}a-1
-      name: a-1
-    - instanceGroup: master-{{.Region}}b-1
-      name: b-1
-    - instanceGroup: master-{{.Region}}a-2
-      name: a-2
+{{- range .Masters}}
+    - instanceGroup: {{.Name}}
+      name: {{.EtcdName}}
+{{- end}}
     name: main
   - etcdMembers:
-    - instanceGroup: master-{{.Region}}a-1
-      name: a-1
-    - instanceGroup: master-{{.Region}}b-1
-      name: b-1
-    - instanceGroup: master-{{.Region}}a-2
-      name: a-2
+{{- range .Masters}}
+    - instanceGroup: {{.Name}}
+      name: {{.EtcdName}}
+{{- end}}
     name: events
   iam:
     allowContainerRegistry: true
@@ -71,52 +77,20 @@ spec:
   sshAccess:
   - 0.0.0.0/0
   subnets:
-  - cidr: 172.20.32.0/19
-    id: {{index .Subnets 0}}
-    name: {{.Region}}a
-    type: Private
-    zone: {{.Region}}a
-  - cidr: 172.20.64.0/19
-    id: {{index .Subnets 1}}
-    name: {{.Region}}b
-    type: Private
-    zone: {{.Region}}b
-  - cidr: 172.20.0.0/22
-    id: {{index .UtilitySubnets 0}}
-    name: utility-{{.Region}}a
-    type: Utility
-    zone: {{.Region}}a
-  - cidr: 172.20.4.0/22
-    id: {{index .UtilitySubnets 1}}
-    name: utility-{{.Region}}b
-    type: Utility
-    zone: {{.Region}}b
+{{- range .Subnets}}
+  - cidr: {{.Cidr}}
+    id: {{.Id}}
+    name: {{.Name}}
+    type: {{.Type}}
+    zone: {{.Zone}}
+{{- end}}
   topology:
     dns:
       type: Public
     masters: private
     nodes: private
 
----
-
-apiVersion: kops/v1alpha2
-kind: InstanceGroup
-metadata:
-  labels:
-    kops.k8s.io/cluster: {{.ClusterName}}
-  name: master-{{.Region}}a-1
-spec:
-  image: kope.io/k8s-1.11-debian-stretch-amd64-hvm-ebs-2018-08-17
-  machineType: m4.large
-  maxSize: 1
-  minSize: 1
-  nodeLabels:
-    kops.k8s.io/instancegroup: master-{{.Region}}a-1
-  role: Master
-  additionalSecurityGroups:
-  - {{.MasterSecurityGroupId}}
-  subnets:
-  - {{.Region}}a
+{{- range .Masters}}
 
 ---
 
@@ -124,41 +98,23 @@ apiVersion: kops/v1alpha2
 kind: InstanceGroup
 metadata:
   labels:
-    kops.k8s.io/cluster: {{.ClusterName}}
-  name: master-{{.Region}}a-2
+    kops.k8s.io/cluster: {{$.ClusterName}}
+  name: {{.Name}}
 spec:
-  image: kope.io/k8s-1.11-debian-stretch-amd64-hvm-ebs-2018-08-17
-  machineType: m4.large
+  image: {{$.MasterImage}}
+  machineType: {{$.MasterMachineType}}
   maxSize: 1
   minSize: 1
   nodeLabels:
-    kops.k8s.io/instancegroup: master-{{.Region}}a-2
+    kops.k8s.io/instancegroup: {{.Name}}
   role: Master
   additionalSecurityGroups:
-  - {{.MasterSecurityGroupId}}
+  - {{$.MasterSecurityGroupId}}
   subnets:
-  - {{.Region}}a
+  - {{.Zone}}
+{{- end}}
 
----
-
-apiVersion: kops/v1alpha2
-kind: InstanceGroup
-metadata:
-  labels:
-    kops.k8s.io/cluster: {{.ClusterName}}
-  name: master-{{.Region}}b-1
-spec:
-  image: kope.io/k8s-1.11-debian-stretch-amd64-hvm-ebs-2018-08-17
-  machineType: m4.large
-  maxSize: 1
-  minSize: 1
-  nodeLabels:
-    kops.k8s.io/instancegroup: master-{{.Region}}b-1
-  role: Master
-  additionalSecurityGroups:
-  - {{.MasterSecurityGroupId}}
-  subnets:
-  - {{.Region}}b
+{{- range .InstanceGroups}}
 
 ---
 
@@ -166,69 +122,426 @@ apiVersion: kops/v1alpha2
 kind: InstanceGroup
 metadata:
   labels:
-    kops.k8s.io/cluster: {{.ClusterName}}
-  name: nodes
+    kops.k8s.io/cluster: {{$.ClusterName}}
+  name: {{.Name}}
 spec:
-  image: kope.io/k8s-1.11-debian-stretch-amd64-hvm-ebs-2018-08-17
-  machineType: m4.large
-  maxSize: 1
-  minSize: 1
+  image: {{.Image}}
+  machineType: {{.MachineType}}
+  maxSize: {{.MaxSize}}
+  minSize: {{.MinSize}}
+  {{- if .RootVolumeSize}}
+  rootVolumeSize: {{.RootVolumeSize}}
+  {{- end}}
   nodeLabels:
-    kops.k8s.io/instancegroup: nodes
+    kops.k8s.io/instancegroup: {{.Name}}
+  {{- range $key, $value := .NodeLabels}}
+    {{$key}}: {{printf "%q" $value}}
+  {{- end}}
+  {{- if .Taints}}
+  taints:
+  {{- range .Taints}}
+  - {{printf "%q" .}}
+  {{- end}}
+  {{- end}}
   role: Node
   additionalSecurityGroups:
-  - {{.WorkerSecurityGroupId}}
+  - {{$.WorkerSecurityGroupId}}
   subnets:
-  - {{.Region}}a
-  - {{.Region}}b
+  {{- range .Subnets}}
+  - {{.}}
+  {{- end}}
+{{- end}}
 `
 
+const (
+	mastersPerCluster        = 3
+	defaultImage             = "kope.io/k8s-1.11-debian-stretch-amd64-hvm-ebs-2018-08-17"
+	defaultMachineType       = "m4.large"
+	defaultInstanceGroupName = "nodes"
+	defaultInstanceGroupSize = 1
+)
+
 type ClusterTemplate struct {
 	ClusterName, Region, ConfigBucket, VpcId,
 	VpcCidr, MasterSecurityGroupId, WorkerSecurityGroupId, MasterPolicies,
-	NodePolicies string
-	Subnets, UtilitySubnets []string
+	NodePolicies, MasterImage, MasterMachineType string
+	Subnets        []ClusterSubnetTemplate
+	Masters        []MasterTemplate
+	InstanceGroups []InstanceGroupTemplate
+}
+
+type ClusterSubnetTemplate struct {
+	Name, Zone, Cidr, Id, Type string
+}
+
+type MasterTemplate struct {
+	Name, EtcdName, Zone string
+}
+
+type InstanceGroupTemplate struct {
+	Name, MachineType, Image string
+	MinSize, MaxSize         int
+	RootVolumeSize           int
+	NodeLabels               map[string]string
+	Taints, Subnets          []string
+}
+
+func ApplyKubernetesClusters(config *model.Config, outputs terraform.Outputs, approved bool) error {
+	renderedClusters, err := renderKubernetesClusters(config, outputs)
+	if err != nil {
+		return err
+	}
+	for _, kubernetesCluster := range config.Spec.Kubernetes {
+		if renderedCluster, ok := renderedClusters[kubernetesCluster.Name]; ok {
+			if _, _, err := replaceAndUpdateCluster(config, renderedCluster, outputs, approved); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// PlanKubernetesClusters records the changes kops would make to each cluster. Clusters waiting on the
+// network to be applied are marked as pending as there is nothing to compare them with yet.
+func PlanKubernetesClusters(config *model.Config, outputs terraform.Outputs) ([]plan.KubernetesChanges, error) {
+	renderedClusters, err := renderKubernetesClusters(config, outputs)
+	if err != nil {
+		return nil, err
+	}
+	var kubernetesChanges []plan.KubernetesChanges
+	for _, kubernetesCluster := range config.Spec.Kubernetes {
+		renderedCluster, ok := renderedClusters[kubernetesCluster.Name]
+		if !ok {
+			kubernetesChanges = append(kubernetesChanges, plan.KubernetesChanges{
+				Name:    kubernetesCluster.Name,
+				Pending: true,
+			})
+			continue
+		}
+		changes, objectChanges, err := replaceAndUpdateCluster(config, renderedCluster, outputs, false)
+		if err != nil {
+			return nil, err
+		}
+		clusterChanges := plan.KubernetesChanges{
+			Name:             kubernetesCluster.Name,
+			TemplateChecksum: plan.Checksum(renderedCluster.template),
+			Changes:          string(changes),
+		}
+		if objectChanges != nil {
+			clusterChanges.Objects = &plan.ResourceChanges{
+				Add:     objectChanges.Create,
+				Change:  objectChanges.Update,
+				Destroy: objectChanges.Delete,
+			}
+			clusterChanges.ObjectDiff = objectChanges.Diff
+		}
+		kubernetesChanges = append(kubernetesChanges, clusterChanges)
+	}
+	return kubernetesChanges, nil
 }
 
-func ApplyKubernetesClusters(config *model.Config, outputs terraform.Outputs, approved bool) {
-	if baseVPCExists(outputs) {
-		configBucket := config.Spec.ConfigBucket
+// ApplyPlannedKubernetesClusters only updates clusters whose specification is unchanged since they were planned.
+func ApplyPlannedKubernetesClusters(config *model.Config, outputs terraform.Outputs, plannedChanges []plan.KubernetesChanges) error {
+	renderedClusters, err := renderKubernetesClusters(config, outputs)
+	if err != nil {
+		return err
+	}
+	for _, plannedChange := range plannedChanges {
+		renderedCluster, ok := renderedClusters[plannedChange.Name]
+		if plannedChange.Pending || !ok {
+			log.Printf("Cluster %s was not planned, run plan again to include it", plannedChange.Name)
+			continue
+		}
+		if plan.Checksum(renderedCluster.template) != plannedChange.TemplateChecksum {
+			return util.NewError(util.ConfigError, "cluster %s has changed since it was planned, run plan again", plannedChange.Name)
+		}
+		if _, _, err := replaceAndUpdateCluster(config, renderedCluster, outputs, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-		elasticSearchMasterPolicy, elasticSearchNodePolicy := masterAndNodeIamPolicies(outputs)
+type renderedCluster struct {
+	cluster        model.Kubernetes
+	template       []byte
+	instanceGroups map[string]bool
+}
 
-		for _, kubernetesCluster := range config.Spec.Kubernetes {
-			clusterName := kubernetesCluster.Name
+func renderKubernetesClusters(config *model.Config, outputs terraform.Outputs) (map[string]renderedCluster, error) {
+	renderedClusters := make(map[string]renderedCluster)
+	if !baseVPCExists(outputs) {
+		return renderedClusters, nil
+	}
 
-			clusterTemplate := parseClusterTemplate(
-				clusterName,
-				elasticSearchMasterPolicy,
-				elasticSearchNodePolicy,
-				config,
-				outputs)
+	layout, err := networkLayout(config.Spec)
+	if err != nil {
+		return nil, err
+	}
+	if len(outputs.PrivateSubnets()) != len(layout.Zones) || len(outputs.UtilitySubnets()) != len(layout.Zones) {
+		log.Printf("Network has not been applied for availability zones %s, skipping kubernetes clusters", layout.ZoneNames())
+		return renderedClusters, nil
+	}
 
-			kopsFileName := kopsTemplateFilename(clusterName)
-			util.WriteFile(kopsFileName, clusterTemplate)
+	for _, kubernetesCluster := range config.Spec.Kubernetes {
+		masterPolicy, nodePolicy, err := masterAndNodeIamPolicies(outputs, kubernetesCluster, config.Spec.Region)
+		if err != nil {
+			return nil, err
+		}
+		instanceGroups, err := instanceGroupTemplates(kubernetesCluster, layout)
+		if err != nil {
+			return nil, err
+		}
+		clusterTemplate, err := parseClusterTemplate(
+			kubernetesCluster.Name,
+			masterPolicy,
+			nodePolicy,
+			config,
+			instanceGroups,
+			layout,
+			outputs)
+		if err != nil {
+			return nil, err
+		}
+		instanceGroupNames := make(map[string]bool)
+		for _, instanceGroup := range instanceGroups {
+			instanceGroupNames[instanceGroup.Name] = true
+		}
+		renderedClusters[kubernetesCluster.Name] = renderedCluster{
+			cluster:        kubernetesCluster,
+			template:       clusterTemplate,
+			instanceGroups: instanceGroupNames,
+		}
+	}
+	return renderedClusters, nil
+}
 
-			kops.ExecuteKops(kopsStateFlag(configBucket), "replace", "-f", kopsFileName, "--force")
-			kops.ExecuteKops(kopsStateFlag(configBucket), "create", "secret", kopsClusterNameFlag(clusterName), "sshpublickey", "admin", "-i", crypto.PublicKeyFile)
-			kops.ExecuteKops(kopsUpdateCluster(configBucket, clusterName, approved)...)
+// replaceAndUpdateCluster returns what kops changes along with the objects written to the cluster. Without
+// approval nothing is changed, not even the kops state store. The rendered spec is compared with the one kops
+// has, the update kops would make to the cloud is shown for the unchanged state, and the objects are compared
+// with those in the cluster if it is already running.
+func replaceAndUpdateCluster(config *model.Config, renderedCluster renderedCluster, outputs terraform.Outputs, approved bool) ([]byte, *kubernetes.Changes, error) {
+	configBucket := config.Spec.ConfigBucket
+	clusterName := renderedCluster.cluster.Name
+
+	kopsFileName := kopsTemplateFilename(clusterName)
+	if err := util.WriteFile(kopsFileName, renderedCluster.template); err != nil {
+		return nil, nil, err
+	}
 
-			if approved {
-				validateCluster(configBucket, clusterName)
+	var specOutput []byte
+	if approved {
+		if _, err := kops.ExecuteKops(kopsStateFlag(configBucket), "replace", "-f", kopsFileName, "--force"); err != nil {
+			return nil, nil, err
+		}
+		if _, err := kops.ExecuteKops(kopsStateFlag(configBucket), "create", "secret", kopsClusterNameFlag(clusterName), "sshpublickey", "admin", "-i", crypto.PublicKeyFile); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		exists, err := kopsClusterExists(config, clusterName)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !exists {
+			description := fmt.Sprintf("Cluster %s is not in the kops state store yet and will be created\n", clusterName)
+			log.Print(strings.TrimSpace(description))
+			return []byte(description), nil, nil
+		}
+		if specOutput, err = describeSpecChanges(configBucket, renderedCluster); err != nil {
+			return nil, nil, err
+		}
+	}
+	kubeConfigFile := kubeConfigFilename(clusterName)
+	pruneOutput, err := pruneInstanceGroups(configBucket, renderedCluster, approved)
+	if err != nil {
+		return nil, nil, err
+	}
+	updateOutput, err := kops.ExecuteKopsWithKubeConfig(kubeConfigFile, kopsUpdateCluster(configBucket, clusterName, approved)...)
+	updateOutput = append(append(specOutput, pruneOutput...), updateOutput...)
+	if err != nil {
+		return nil, nil, err
+	}
 
-				kubernetes.ApplyServices(outputs)
-				kubernetes.ApplyConfigMaps(outputs)
-				kubernetes.ApplySecrets(outputs)
-				applyLogging(kubernetesCluster, outputs, config)
-			}
+	if err := ExportKubeConfig(config, clusterName, kubeConfigFile); err != nil {
+		return nil, nil, err
+	}
+	if approved {
+		if err := validateCluster(configBucket, clusterName, kubeConfigFile); err != nil {
+			return nil, nil, err
 		}
+	} else if err := CheckKubernetesCluster(config, clusterName); err != nil {
+		log.Printf("Cluster %s is not running yet, its objects will be compared once it is", clusterName)
+		return updateOutput, nil, nil
 	}
+
+	client, err := kubernetes.NewClient(kubeConfigFile, clusterName)
+	if err != nil {
+		return nil, nil, err
+	}
+	objectChanges, err := applyKubernetesObjects(client, renderedCluster.cluster, outputs, config, !approved)
+	return updateOutput, objectChanges, err
 }
 
-func masterAndNodeIamPolicies(outputs terraform.Outputs) (masterPolicies string, nodePolicies string) {
-	elasticSearchMasterPolicies, elasticSearchNodePolicies := elasticSearchIamPolicies(outputs)
-	allNodePolicies := flattenIamPolicies(elasticSearchNodePolicies, route53NodePolicies())
-	return IamPolicyJsonString(elasticSearchMasterPolicies), IamPolicyJsonString(allNodePolicies)
+// applyKubernetesObjects writes everything fk-infra keeps in the cluster and prunes what it no longer configures.
+func applyKubernetesObjects(client *k8s.Client, kubernetesCluster model.Kubernetes, outputs terraform.Outputs, config *model.Config, dryRun bool) (*kubernetes.Changes, error) {
+	applier := kubernetes.NewApplier(client, config.Spec.EnvironmentName, dryRun)
+	if err := kubernetes.ApplyServices(applier, outputs); err != nil {
+		return nil, err
+	}
+	if err := kubernetes.ApplyConfigMaps(applier, config.Spec.ElasticSearch, outputs); err != nil {
+		return nil, err
+	}
+	if err := kubernetes.ApplySecrets(applier, config.Spec.Databases, outputs, config.Spec.Region); err != nil {
+		return nil, err
+	}
+	if err := applyLogging(applier, kubernetesCluster, outputs, config); err != nil {
+		return nil, err
+	}
+	if err := applier.Prune(); err != nil {
+		return nil, err
+	}
+	changes := applier.Changes()
+	return &changes, nil
+}
+
+// pruneInstanceGroups deletes the node instance groups kops has for the cluster that are no longer in the
+// config, which kops replace never does. Without approval they are only listed. Masters are left alone, they
+// follow the network zones rather than the config.
+func pruneInstanceGroups(configBucket string, renderedCluster renderedCluster, approved bool) ([]byte, error) {
+	clusterName := renderedCluster.cluster.Name
+	kubeConfigFile := kubeConfigFilename(clusterName)
+	instanceGroupsJson, err := kops.ExecuteKopsQuietly(kubeConfigFile, kopsStateFlag(configBucket), "get", "instancegroups", kopsClusterNameFlag(clusterName), "-o", "json")
+	if err != nil {
+		return nil, err
+	}
+	instanceGroups, err := parseKopsInstanceGroups(instanceGroupsJson)
+	if err != nil {
+		return nil, util.WrapError(util.ExecutionError, err, "unable to read instance groups of cluster %s", clusterName)
+	}
+
+	var staleInstanceGroups []string
+	var description bytes.Buffer
+	for _, instanceGroup := range instanceGroups {
+		if instanceGroup.Spec.Role == "Node" && !renderedCluster.instanceGroups[instanceGroup.Metadata.Name] {
+			staleInstanceGroups = append(staleInstanceGroups, instanceGroup.Metadata.Name)
+			fmt.Fprintf(&description, "Instance group %s of cluster %s is no longer in fk-infra.yml and will be deleted\n", instanceGroup.Metadata.Name, clusterName)
+		}
+	}
+	if len(staleInstanceGroups) == 0 {
+		return nil, nil
+	}
+	log.Print(strings.TrimSpace(description.String()))
+	if !approved {
+		return description.Bytes(), nil
+	}
+	for _, instanceGroup := range staleInstanceGroups {
+		if _, err := kops.ExecuteKopsWithKubeConfig(kubeConfigFile, kopsStateFlag(configBucket), "delete", "instancegroup", instanceGroup, kopsClusterNameFlag(clusterName), "--yes"); err != nil {
+			return nil, err
+		}
+	}
+	return description.Bytes(), nil
+}
+
+type kopsInstanceGroup struct {
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Spec struct {
+		Role string `json:"role"`
+	} `json:"spec"`
+}
+
+// kops writes a single instance group as an object and several as an array.
+func parseKopsInstanceGroups(instanceGroupsJson []byte) ([]kopsInstanceGroup, error) {
+	instanceGroupsJson = bytes.TrimSpace(instanceGroupsJson)
+	if len(instanceGroupsJson) == 0 {
+		return nil, nil
+	}
+	if instanceGroupsJson[0] != '[' {
+		var instanceGroup kopsInstanceGroup
+		err := json.Unmarshal(instanceGroupsJson, &instanceGroup)
+		return []kopsInstanceGroup{instanceGroup}, err
+	}
+	var instanceGroups []kopsInstanceGroup
+	err := json.Unmarshal(instanceGroupsJson, &instanceGroups)
+	return instanceGroups, err
+}
+
+// ExportKubeConfig writes credentials for one of the clusters in the config to kubeConfigFile, under a context
+// named after the cluster.
+func ExportKubeConfig(config *model.Config, clusterName, kubeConfigFile string) error {
+	if !clusterInConfig(config, clusterName) {
+		return util.NewError(util.ConfigError, "cluster %s is not in fk-infra.yml", clusterName)
+	}
+	if err := os.MkdirAll(filepath.Dir(kubeConfigFile), 0700); err != nil {
+		return util.WrapError(util.ConfigError, err, "unable to create directory for %s", kubeConfigFile)
+	}
+	_, err := kops.ExecuteKopsQuietly(kubeConfigFile, kopsStateFlag(config.Spec.ConfigBucket), "export", "kubecfg", kopsClusterNameFlag(clusterName))
+	return err
+}
+
+// KubernetesClient exports fresh credentials for the cluster and returns a client bound to its context.
+func KubernetesClient(config *model.Config, clusterName string) (*k8s.Client, error) {
+	kubeConfigFile := kubeConfigFilename(clusterName)
+	if err := ExportKubeConfig(config, clusterName, kubeConfigFile); err != nil {
+		return nil, err
+	}
+	return kubernetes.NewClient(kubeConfigFile, clusterName)
+}
+
+// CheckKubernetesCluster validates the cluster once, without waiting for it to become ready.
+func CheckKubernetesCluster(config *model.Config, clusterName string) error {
+	_, err := kops.ExecuteKopsQuietly(kubeConfigFilename(clusterName), kopsStateFlag(config.Spec.ConfigBucket), "validate", "cluster", kopsClusterNameFlag(clusterName))
+	return err
+}
+
+func clusterInConfig(config *model.Config, clusterName string) bool {
+	for _, kubernetesCluster := range config.Spec.Kubernetes {
+		if kubernetesCluster.Name == clusterName {
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteKubernetesClusters skips clusters already gone from the kops state store, so a destroy that failed
+// after deleting them can be run again.
+func DeleteKubernetesClusters(config *model.Config, approved bool) error {
+	configBucket := config.Spec.ConfigBucket
+	for _, kubernetesCluster := range config.Spec.Kubernetes {
+		exists, err := kopsClusterExists(config, kubernetesCluster.Name)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			log.Printf("Cluster %s is not in the kops state store, skipping it", kubernetesCluster.Name)
+			continue
+		}
+		if _, err := kops.ExecuteKopsWithKubeConfig(kubeConfigFilename(kubernetesCluster.Name), kopsDeleteCluster(configBucket, kubernetesCluster.Name, approved)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func masterAndNodeIamPolicies(outputs terraform.Outputs, kubernetesCluster model.Kubernetes, region string) (masterPolicies string, nodePolicies string, err error) {
+	elasticSearchMasterPolicies, elasticSearchNodePolicies, err := elasticSearchIamPolicies(outputs)
+	if err != nil {
+		return "", "", err
+	}
+	queuePolicies, err := queueNodePolicies(outputs)
+	if err != nil {
+		return "", "", err
+	}
+	loggingPolicies := loggingIamPolicies(kubernetesCluster, region)
+	allMasterPolicies := append(elasticSearchMasterPolicies, loggingPolicies...)
+	allNodePolicies := flattenIamPolicies(elasticSearchNodePolicies, queuePolicies, route53NodePolicies(), loggingPolicies)
+
+	if masterPolicies, err = IamPolicyJsonString(allMasterPolicies); err != nil {
+		return "", "", err
+	}
+	nodePolicies, err = IamPolicyJsonString(allNodePolicies)
+	return masterPolicies, nodePolicies, err
 }
 
 func flattenIamPolicies(policiesToFlatten ...[]*IamPolicy) []*IamPolicy {
@@ -251,35 +564,61 @@ func route53NodePolicies() []*IamPolicy {
 		Resources("*")}
 }
 
-func elasticSearchIamPolicies(outputs terraform.Outputs) (masterPolicies []*IamPolicy, nodePolicies []*IamPolicy) {
+func queueNodePolicies(outputs terraform.Outputs) ([]*IamPolicy, error) {
+	queueOutputs, err := outputs.QueueConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var queueArns, topicArns []string
+	for _, queue := range queueOutputs {
+		if queue.Type == model.QueueTypeSqs {
+			queueArns = append(queueArns, queue.Arn, queue.DeadLetterArn)
+		} else {
+			topicArns = append(topicArns, queue.Arn)
+		}
+	}
+
+	var nodeIamPolicies []*IamPolicy
+	if len(queueArns) > 0 {
+		nodeIamPolicies = append(nodeIamPolicies, NewAllowIamPolicy().
+			Actions("sqs:SendMessage",
+				"sqs:ReceiveMessage",
+				"sqs:DeleteMessage",
+				"sqs:ChangeMessageVisibility",
+				"sqs:GetQueueAttributes",
+				"sqs:GetQueueUrl").
+			Resources(queueArns...))
+	}
+	if len(topicArns) > 0 {
+		nodeIamPolicies = append(nodeIamPolicies, NewAllowIamPolicy().
+			Actions("sns:Publish",
+				"sns:GetTopicAttributes").
+			Resources(topicArns...))
+	}
+	return nodeIamPolicies, nil
+}
+
+func elasticSearchIamPolicies(outputs terraform.Outputs) (masterPolicies []*IamPolicy, nodePolicies []*IamPolicy, err error) {
+	elasticSearchOutputs, err := outputs.ElasticSearchConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
 	var masterIamPolicies []*IamPolicy
 	var nodeIamPolicies []*IamPolicy
-	for _, elasticSearchCluster := range outputs.ElasticSearchConfig() {
+	for _, elasticSearchCluster := range elasticSearchOutputs {
 		iamPolicy := NewAllowIamPolicy().
 			Actions("es:*").
 			Resources(fmt.Sprintf("%s/*", elasticSearchCluster.Arn))
 		masterIamPolicies = append(masterIamPolicies, iamPolicy)
 		nodeIamPolicies = append(nodeIamPolicies, iamPolicy)
 	}
-	return masterIamPolicies, nodeIamPolicies
-}
-
-func applyLogging(kubernetesCluster model.Kubernetes, outputs terraform.Outputs, config *model.Config) {
-	if kubernetesCluster.LoggingElasticSearchName != "" {
-		for _, elasticSearchCluster := range outputs.ElasticSearchConfig() {
-			if kubernetesCluster.LoggingElasticSearchName == elasticSearchCluster.Name {
-				kubernetes.ApplyFluentBitLogging(elasticSearchCluster.Endpoint, config.Spec.Region)
-				break
-			}
-		}
-	}
+	return masterIamPolicies, nodeIamPolicies, nil
 }
 
-func parseClusterTemplate(clusterName, masterPolicy, nodePolicy string, config *model.Config, outputs terraform.Outputs) []byte {
-	var buf bytes.Buffer
-	tmpl, err := template.New("clusterTemplate").Parse(clusterTemplate)
-	util.CheckError(err)
-	err = tmpl.Execute(&buf, ClusterTemplate{
+func parseClusterTemplate(clusterName, masterPolicy, nodePolicy string, config *model.Config, instanceGroups []InstanceGroupTemplate, layout NetworkLayout, outputs terraform.Outputs) ([]byte, error) {
+	return renderTemplate("clusterTemplate", clusterTemplate, ClusterTemplate{
 		ClusterName:           clusterName,
 		Region:                config.Spec.Region,
 		ConfigBucket:          config.Spec.ConfigBucket,
@@ -287,33 +626,118 @@ func parseClusterTemplate(clusterName, masterPolicy, nodePolicy string, config *
 		VpcCidr:               outputs.VpcCidr.Value,
 		MasterSecurityGroupId: outputs.MasterSecurityGroupId.Value,
 		WorkerSecurityGroupId: outputs.WorkerSecurityGroupId.Value,
-		Subnets:               outputs.PrivateSubnets(),
-		UtilitySubnets:        outputs.UtilitySubnets(),
+		MasterImage:           defaultImage,
+		MasterMachineType:     defaultMachineType,
+		Subnets:               clusterSubnetTemplates(layout, outputs),
+		Masters:               masterTemplates(layout),
+		InstanceGroups:        instanceGroups,
 		MasterPolicies:        masterPolicy,
 		NodePolicies:          nodePolicy,
 	})
-	util.CheckError(err)
-	return buf.Bytes()
 }
 
-func validateCluster(configBucket string, clusterName string) {
-	var clusterIsReady = func() (ready bool) {
-		ready = true
-		defer func() {
-			if recover() != nil {
-				ready = false
-				time.Sleep(15 * time.Second)
+func clusterSubnetTemplates(layout NetworkLayout, outputs terraform.Outputs) []ClusterSubnetTemplate {
+	var privateSubnets, utilitySubnets []ClusterSubnetTemplate
+	for i, zone := range layout.Zones {
+		privateSubnets = append(privateSubnets, ClusterSubnetTemplate{
+			Name: zone.Zone,
+			Zone: zone.Zone,
+			Cidr: zone.PrivateCidr,
+			Id:   outputs.PrivateSubnets()[i],
+			Type: "Private",
+		})
+		utilitySubnets = append(utilitySubnets, ClusterSubnetTemplate{
+			Name: fmt.Sprintf("utility-%s", zone.Zone),
+			Zone: zone.Zone,
+			Cidr: zone.UtilityCidr,
+			Id:   outputs.UtilitySubnets()[i],
+			Type: "Utility",
+		})
+	}
+	return append(privateSubnets, utilitySubnets...)
+}
+
+// Masters are spread round robin across the zones so etcd keeps quorum if a single zone is lost.
+func masterTemplates(layout NetworkLayout) []MasterTemplate {
+	var masters []MasterTemplate
+	for i := 0; i < mastersPerCluster; i++ {
+		zone := layout.Zones[i%len(layout.Zones)]
+		memberIndex := i/len(layout.Zones) + 1
+		masters = append(masters, MasterTemplate{
+			Name:     fmt.Sprintf("master-%s-%d", zone.Zone, memberIndex),
+			EtcdName: fmt.Sprintf("%s-%d", zone.Suffix, memberIndex),
+			Zone:     zone.Zone,
+		})
+	}
+	return masters
+}
+
+func instanceGroupTemplates(kubernetesCluster model.Kubernetes, layout NetworkLayout) ([]InstanceGroupTemplate, error) {
+	instanceGroups := kubernetesCluster.InstanceGroups
+	if len(instanceGroups) == 0 {
+		instanceGroups = []model.InstanceGroup{{Name: defaultInstanceGroupName}}
+	}
+
+	zones := make(map[string]bool)
+	for _, zone := range layout.ZoneNames() {
+		zones[zone] = true
+	}
+
+	names := make(map[string]bool)
+	var instanceGroupTemplates []InstanceGroupTemplate
+	for _, instanceGroup := range instanceGroups {
+		if instanceGroup.Name == "" || names[instanceGroup.Name] {
+			return nil, util.NewError(util.ConfigError, "instance groups in cluster %s need unique names, found %q", kubernetesCluster.Name, instanceGroup.Name)
+		}
+		names[instanceGroup.Name] = true
+
+		minSize, maxSize := instanceGroup.MinSize, instanceGroup.MaxSize
+		if minSize == 0 && maxSize == 0 {
+			minSize, maxSize = defaultInstanceGroupSize, defaultInstanceGroupSize
+		} else if maxSize == 0 {
+			maxSize = minSize
+		}
+		if minSize > maxSize {
+			return nil, util.NewError(util.ConfigError, "instance group %s has min-size %d larger than max-size %d", instanceGroup.Name, minSize, maxSize)
+		}
+
+		subnets := instanceGroup.Subnets
+		if len(subnets) == 0 {
+			subnets = layout.ZoneNames()
+		}
+		for _, subnet := range subnets {
+			if !zones[subnet] {
+				return nil, util.NewError(util.ConfigError, "instance group %s uses subnet %s which isn't one of the network zones %s", instanceGroup.Name, subnet, layout.ZoneNames())
 			}
-		}()
-		kops.ExecuteKops(kopsStateFlag(configBucket), "validate", "cluster", kopsClusterNameFlag(clusterName))
-		return true
+		}
+
+		instanceGroupTemplates = append(instanceGroupTemplates, InstanceGroupTemplate{
+			Name:           instanceGroup.Name,
+			MachineType:    defaultString(instanceGroup.MachineType, defaultMachineType),
+			Image:          defaultString(instanceGroup.Image, defaultImage),
+			MinSize:        minSize,
+			MaxSize:        maxSize,
+			RootVolumeSize: instanceGroup.RootVolumeSize,
+			NodeLabels:     instanceGroup.NodeLabels,
+			Taints:         instanceGroup.Taints,
+			Subnets:        subnets,
+		})
 	}
+	return instanceGroupTemplates, nil
+}
 
+// validateCluster waits for kops to report the cluster as healthy, giving up after ten minutes.
+func validateCluster(configBucket, clusterName, kubeConfigFile string) error {
 	inTenMins := time.Now().Add(10 * time.Minute)
-	for time.Now().Before(inTenMins) {
-		if clusterIsReady() {
-			return
+	for {
+		_, err := kops.ExecuteKopsWithKubeConfig(kubeConfigFile, kopsStateFlag(configBucket), "validate", "cluster", kopsClusterNameFlag(clusterName))
+		if err == nil {
+			return nil
 		}
+		if time.Now().After(inTenMins) {
+			return util.WrapError(util.TimeoutError, err, "cluster %s did not become ready within 10 minutes", clusterName)
+		}
+		time.Sleep(15 * time.Second)
 	}
 }
 
@@ -321,6 +745,10 @@ func baseVPCExists(terraformOutputs terraform.Outputs) bool {
 	return terraformOutputs.VpcId.Value != ""
 }
 
+func kubeConfigFilename(clusterName string) string {
+	return filepath.Join(".fk-infra", "kubeconfig", clusterName)
+}
+
 func kopsTemplateFilename(clusterName string) string {
 	return fmt.Sprintf("./%s.yml", clusterName)
 }
@@ -335,6 +763,110 @@ func kopsUpdateCluster(configBucket, clusterName string, approved bool) []string
 	return args
 }
 
+func kopsDeleteCluster(configBucket, clusterName string, approved bool) []string {
+	args := []string{kopsStateFlag(configBucket), "delete", "cluster", kopsClusterNameFlag(clusterName)}
+
+	if approved {
+		args = append(args, "--yes")
+	}
+
+	return args
+}
+
+// describeSpecChanges compares the rendered cluster and instance groups with the ones in the kops state store,
+// only looking at the fields fk-infra sets. Instance groups no longer configured are described by
+// pruneInstanceGroups.
+func describeSpecChanges(configBucket string, renderedCluster renderedCluster) ([]byte, error) {
+	clusterName := renderedCluster.cluster.Name
+	kubeConfigFile := kubeConfigFilename(clusterName)
+	clusterYaml, err := kops.ExecuteKopsQuietly(kubeConfigFile, kopsStateFlag(configBucket), "get", "cluster", kopsClusterNameFlag(clusterName), "-o", "yaml")
+	if err != nil {
+		return nil, err
+	}
+	instanceGroupsYaml, err := kops.ExecuteKopsQuietly(kubeConfigFile, kopsStateFlag(configBucket), "get", "instancegroups", kopsClusterNameFlag(clusterName), "-o", "yaml")
+	if err != nil {
+		return nil, err
+	}
+	liveDocuments, err := parseKopsDocuments(append(append(clusterYaml, "\n---\n"...), instanceGroupsYaml...))
+	if err != nil {
+		return nil, util.WrapError(util.ExecutionError, err, "unable to read the kops spec of cluster %s", clusterName)
+	}
+	desiredDocuments, err := parseKopsDocuments(renderedCluster.template)
+	if err != nil {
+		return nil, util.WrapError(util.UnknownError, err, "unable to read the rendered spec of cluster %s", clusterName)
+	}
+
+	live := make(map[string]map[string]interface{})
+	for _, document := range liveDocuments {
+		live[document.id] = document.fields
+	}
+	var description bytes.Buffer
+	for _, document := range desiredDocuments {
+		liveFields, ok := live[document.id]
+		if !ok {
+			fmt.Fprintf(&description, "%s of cluster %s will be created\n", document.id, clusterName)
+			continue
+		}
+		diff := kubernetes.DiffFields(document.fields, liveFields)
+		if len(diff) == 0 {
+			continue
+		}
+		fmt.Fprintf(&description, "%s of cluster %s will be updated\n", document.id, clusterName)
+		for _, field := range diff {
+			fmt.Fprintf(&description, "  %s\n", field)
+		}
+	}
+	if description.Len() > 0 {
+		log.Print(strings.TrimSpace(description.String()))
+	}
+	return description.Bytes(), nil
+}
+
+var kopsDocumentSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+type kopsDocument struct {
+	// id is the kind and name of the document, such as InstanceGroup nodes
+	id     string
+	fields map[string]interface{}
+}
+
+func parseKopsDocuments(documentsYaml []byte) ([]kopsDocument, error) {
+	var documents []kopsDocument
+	for _, documentYaml := range kopsDocumentSeparator.Split(string(documentsYaml), -1) {
+		if strings.TrimSpace(documentYaml) == "" {
+			continue
+		}
+		documentJson, err := yaml.YAMLToJSON([]byte(documentYaml))
+		if err != nil {
+			return nil, err
+		}
+		var document struct {
+			Kind     string `json:"kind"`
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal(documentJson, &document); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(documentJson, &fields); err != nil {
+			return nil, err
+		}
+		documents = append(documents, kopsDocument{
+			id:     fmt.Sprintf("%s %s", document.Kind, document.Metadata.Name),
+			fields: fields,
+		})
+	}
+	return documents, nil
+}
+
+// kops keeps the spec of each cluster in its state store at <cluster>/config.
+func kopsClusterExists(config *model.Config, clusterName string) (bool, error) {
+	_, exists, err := aws.FetchObject(config.Spec.ConfigBucket, path.Join("kops", clusterName, "config"), config.Spec.Region)
+	return exists, err
+}
+
 func kopsClusterNameFlag(clusterName string) string {
 	return fmt.Sprintf("--name=%s", clusterName)
 }
@@ -0,0 +1,147 @@
+package templates
+
+import (
+	"fmt"
+	"github.com/infinityworks/fk-infra/kubernetes"
+	"github.com/infinityworks/fk-infra/model"
+	"github.com/infinityworks/fk-infra/terraform"
+	"github.com/infinityworks/fk-infra/util"
+	"regexp"
+	"strings"
+)
+
+// Values end up in the fluent-bit configuration, which has no quoting, so they are kept to characters that
+// can't start another setting.
+var (
+	logGroupPattern  = regexp.MustCompile(`^[-._/#A-Za-z0-9]{1,512}$`)
+	bucketPattern    = regexp.MustCompile(`^[a-z0-9][-.a-z0-9]{1,61}[a-z0-9]$`)
+	keyPrefixPattern = regexp.MustCompile(`^[-_.!*'()/A-Za-z0-9]*$`)
+	hostPattern      = regexp.MustCompile(`^[-.A-Za-z0-9]{1,253}$`)
+	uriPattern       = regexp.MustCompile(`^/[-._~!$&'()*+,;=:@/?%A-Za-z0-9]*$`)
+)
+
+// validateLogOutputs checks the logging settings of every cluster before anything is applied.
+func validateLogOutputs(spec model.Spec) error {
+	for _, kubernetesCluster := range spec.Kubernetes {
+		if err := validateClusterLogOutputs(spec, kubernetesCluster); err != nil {
+			return err
+		}
+		if err := kubernetes.ValidateFluentBit(kubernetesCluster.Name, kubernetesCluster.FluentBit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateClusterLogOutputs(spec model.Spec, kubernetesCluster model.Kubernetes) error {
+	elasticSearchNames := make(map[string]bool)
+	for _, logOutput := range kubernetesCluster.LogOutputs() {
+		owner := fmt.Sprintf("%s log output of cluster %s", logOutput.Type, kubernetesCluster.Name)
+		switch logOutput.Type {
+		case model.LogOutputElasticSearch:
+			if !elasticSearchInConfig(spec, logOutput.ElasticSearchName) {
+				return util.NewError(util.ConfigError, "%s ships to ElasticSearch %q which is not in fk-infra.yml", owner, logOutput.ElasticSearchName)
+			}
+			if elasticSearchNames[logOutput.ElasticSearchName] {
+				return util.NewError(util.ConfigError, "%s ships to ElasticSearch %s more than once", owner, logOutput.ElasticSearchName)
+			}
+			elasticSearchNames[logOutput.ElasticSearchName] = true
+		case model.LogOutputCloudWatch:
+			if !logGroupPattern.MatchString(logOutput.LogGroup) {
+				return util.NewError(util.ConfigError, "%s has log-group %q, expected letters, digits and any of -._/#", owner, logOutput.LogGroup)
+			}
+		case model.LogOutputS3:
+			if !bucketPattern.MatchString(logOutput.Bucket) {
+				return util.NewError(util.ConfigError, "%s has bucket %q, expected an S3 bucket name", owner, logOutput.Bucket)
+			}
+			if !keyPrefixPattern.MatchString(logOutput.Prefix) {
+				return util.NewError(util.ConfigError, "%s has prefix %q, expected letters, digits and any of -_.!*'()/", owner, logOutput.Prefix)
+			}
+		case model.LogOutputHttp, model.LogOutputForward:
+			if !hostPattern.MatchString(logOutput.Host) {
+				return util.NewError(util.ConfigError, "%s has host %q, expected a host name or IP address", owner, logOutput.Host)
+			}
+			if logOutput.Port < 0 || logOutput.Port > 65535 {
+				return util.NewError(util.ConfigError, "%s has port %d, expected 1 to 65535", owner, logOutput.Port)
+			}
+			if logOutput.Uri != "" && (logOutput.Type != model.LogOutputHttp || !uriPattern.MatchString(logOutput.Uri)) {
+				return util.NewError(util.ConfigError, "%s has uri %q, expected a path starting with / on an http output", owner, logOutput.Uri)
+			}
+		default:
+			return util.NewError(util.ConfigError, "%s has unknown type, expected one of %s, %s, %s, %s or %s", owner,
+				model.LogOutputElasticSearch, model.LogOutputCloudWatch, model.LogOutputS3, model.LogOutputHttp, model.LogOutputForward)
+		}
+	}
+	return nil
+}
+
+func elasticSearchInConfig(spec model.Spec, elasticSearchName string) bool {
+	for _, elasticSearch := range spec.ElasticSearch {
+		if elasticSearch.Name == elasticSearchName {
+			return true
+		}
+	}
+	return false
+}
+
+func applyLogging(applier *kubernetes.Applier, kubernetesCluster model.Kubernetes, outputs terraform.Outputs, config *model.Config) error {
+	modelOutputs := kubernetesCluster.LogOutputs()
+	if len(modelOutputs) == 0 {
+		return nil
+	}
+	var logOutputs []kubernetes.LogOutput
+	for _, modelOutput := range modelOutputs {
+		logOutput := kubernetes.LogOutput{LogOutput: modelOutput}
+		if modelOutput.Type == model.LogOutputS3 {
+			logOutput.Prefix = s3KeyPrefix(kubernetesCluster, modelOutput)
+		}
+		if modelOutput.Type == model.LogOutputElasticSearch {
+			elasticSearchCluster, err := outputs.ElasticSearch(modelOutput.ElasticSearchName)
+			if err != nil {
+				return err
+			}
+			logOutput.ElasticSearchEndpoint = elasticSearchCluster.Endpoint
+		}
+		logOutputs = append(logOutputs, logOutput)
+	}
+	return kubernetes.ApplyFluentBitLogging(applier, kubernetesCluster.Name, config.Spec.Region, kubernetesCluster.FluentBit, logOutputs)
+}
+
+// loggingIamPolicies let fluent-bit write to the CloudWatch log groups and S3 buckets of the cluster. It runs on
+// masters as well as nodes so both need them, access to ElasticSearch is already granted for every domain.
+func loggingIamPolicies(kubernetesCluster model.Kubernetes, region string) []*IamPolicy {
+	var logGroupArns, objectArns []string
+	for _, logOutput := range kubernetesCluster.LogOutputs() {
+		switch logOutput.Type {
+		case model.LogOutputCloudWatch:
+			logGroupArn := fmt.Sprintf("arn:aws:logs:%s:*:log-group:%s", region, logOutput.LogGroup)
+			logGroupArns = append(logGroupArns, logGroupArn, logGroupArn+":*")
+		case model.LogOutputS3:
+			objectArns = append(objectArns, fmt.Sprintf("arn:aws:s3:::%s/%s/*", logOutput.Bucket, s3KeyPrefix(kubernetesCluster, logOutput)))
+		}
+	}
+
+	var iamPolicies []*IamPolicy
+	if len(logGroupArns) > 0 {
+		iamPolicies = append(iamPolicies, NewAllowIamPolicy().
+			Actions("logs:CreateLogGroup",
+				"logs:CreateLogStream",
+				"logs:DescribeLogStreams",
+				"logs:PutLogEvents").
+			Resources(logGroupArns...))
+	}
+	if len(objectArns) > 0 {
+		iamPolicies = append(iamPolicies, NewAllowIamPolicy().
+			Actions("s3:PutObject").
+			Resources(objectArns...))
+	}
+	return iamPolicies
+}
+
+// Logs are archived under the cluster name unless a prefix is given.
+func s3KeyPrefix(kubernetesCluster model.Kubernetes, logOutput model.LogOutput) string {
+	if keyPrefix := strings.Trim(logOutput.Prefix, "/"); keyPrefix != "" {
+		return keyPrefix
+	}
+	return kubernetesCluster.Name
+}
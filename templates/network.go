@@ -1,313 +1,282 @@
 package templates
 
 import (
-	"bytes"
+	"fmt"
+	"github.com/infinityworks/fk-infra/hcl"
 	"github.com/infinityworks/fk-infra/model"
+	"github.com/infinityworks/fk-infra/terraform"
 	"github.com/infinityworks/fk-infra/util"
-	"html/template"
+	"log"
 )
 
-const networkTemplate = `
-provider "aws" {
-  region = "{{.Region}}"
-}
-
-terraform {
-  backend "s3" {
-    bucket = "{{.ConfigBucket}}"
-    key    = "terraform/network/terraform.tfstate"
-    region = "{{.Region}}"
-  }
-
-  required_version = ">= 0.9.3"
-}
-
-locals = {
-  cluster_name                      = "{{.EnvironmentName}}"
-  node_subnet_ids                   = ["${aws_subnet.{{.Region}}a-{{.EnvironmentName}}.id}", "${aws_subnet.{{.Region}}b-{{.EnvironmentName}}.id}"]
-  region                            = "{{.Region}}"
-  route_table_private-{{.Region}}a_id = "${aws_route_table.private-{{.Region}}a-{{.EnvironmentName}}.id}"
-  route_table_private-{{.Region}}b_id = "${aws_route_table.private-{{.Region}}b-{{.EnvironmentName}}.id}"
-  route_table_public_id             = "${aws_route_table.{{.EnvironmentName}}.id}"
-  subnet_{{.Region}}a_id              = "${aws_subnet.{{.Region}}a-{{.EnvironmentName}}.id}"
-  subnet_{{.Region}}b_id              = "${aws_subnet.{{.Region}}b-{{.EnvironmentName}}.id}"
-  subnet_utility-{{.Region}}a_id      = "${aws_subnet.utility-{{.Region}}a-{{.EnvironmentName}}.id}"
-  subnet_utility-{{.Region}}b_id      = "${aws_subnet.utility-{{.Region}}b-{{.EnvironmentName}}.id}"
-  vpc_cidr_block                    = "${aws_vpc.{{.EnvironmentName}}.cidr_block}"
-  vpc_id                            = "${aws_vpc.{{.EnvironmentName}}.id}"
-}
-
-output "cluster_name" {
-  value = "{{.EnvironmentName}}"
-}
-
-output "node_subnet_ids" {
-  value = ["${aws_subnet.{{.Region}}a-{{.EnvironmentName}}.id}", "${aws_subnet.{{.Region}}b-{{.EnvironmentName}}.id}"]
-}
-
-output "region" {
-  value = "{{.Region}}"
-}
-
-output "route_table_private-{{.Region}}a_id" {
-  value = "${aws_route_table.private-{{.Region}}a-{{.EnvironmentName}}.id}"
-}
-
-output "route_table_private-{{.Region}}b_id" {
-  value = "${aws_route_table.private-{{.Region}}b-{{.EnvironmentName}}.id}"
-}
-
-output "route_table_public_id" {
-  value = "${aws_route_table.{{.EnvironmentName}}.id}"
-}
-
-output "subnet_a" {
-  value = "${aws_subnet.{{.Region}}a-{{.EnvironmentName}}.id}"
-}
-
-output "subnet_b" {
-  value = "${aws_subnet.{{.Region}}b-{{.EnvironmentName}}.id}"
-}
-
-output "subnet_utility-a" {
-  value = "${aws_subnet.utility-{{.Region}}a-{{.EnvironmentName}}.id}"
-}
-
-output "subnet_utility-b" {
-  value = "${aws_subnet.utility-{{.Region}}b-{{.EnvironmentName}}.id}"
-}
-
-output "vpc_cidr_block" {
-  value = "${aws_vpc.{{.EnvironmentName}}.cidr_block}"
-}
-
-output "vpc_id" {
-  value = "${aws_vpc.{{.EnvironmentName}}.id}"
-}
-
-output "worker_security_group_id" {
-  value = "${aws_security_group.k8s-nodes-{{.EnvironmentName}}.id}"
-}
-
-output "master_security_group_id" {
-  value = "${aws_security_group.k8s-masters-{{.EnvironmentName}}.id}"
-}
-
-resource "aws_eip" "{{.Region}}a-{{.EnvironmentName}}" {
-  vpc = true
-
-  tags = {
-    Name                                        = "{{.Region}}a.{{.EnvironmentName}}"
-  }
-}
-
-resource "aws_eip" "{{.Region}}b-{{.EnvironmentName}}" {
-  vpc = true
-
-  tags = {
-    Name                                        = "{{.Region}}b.{{.EnvironmentName}}"
-  }
-}
-
-resource "aws_security_group" "k8s-masters-{{.EnvironmentName}}" {
-  name        = "masters.lol.k8s.local"
-  vpc_id      = "${aws_vpc.{{.EnvironmentName}}.id}"
-  description = "Security group for masters"
-}
-
-resource "aws_security_group" "k8s-nodes-{{.EnvironmentName}}" {
-  name        = "nodes.lol.k8s.local"
-  vpc_id      = "${aws_vpc.{{.EnvironmentName}}.id}"
-  description = "Security group for nodes"
-}
-
-resource "aws_internet_gateway" "{{.EnvironmentName}}" {
-  vpc_id = "${aws_vpc.{{.EnvironmentName}}.id}"
-
-  tags = {
-    Name                                        = "{{.EnvironmentName}}"
-  }
-}
-
-resource "aws_nat_gateway" "{{.Region}}a-{{.EnvironmentName}}" {
-  allocation_id = "${aws_eip.{{.Region}}a-{{.EnvironmentName}}.id}"
-  subnet_id     = "${aws_subnet.utility-{{.Region}}a-{{.EnvironmentName}}.id}"
-
-  tags = {
-    Name                                        = "{{.Region}}a.{{.EnvironmentName}}"
-  }
-}
-
-resource "aws_nat_gateway" "{{.Region}}b-{{.EnvironmentName}}" {
-  allocation_id = "${aws_eip.{{.Region}}b-{{.EnvironmentName}}.id}"
-  subnet_id     = "${aws_subnet.utility-{{.Region}}b-{{.EnvironmentName}}.id}"
-
-  tags = {
-    Name                                        = "{{.Region}}b.{{.EnvironmentName}}"
-  }
-}
-
-resource "aws_route" "0-0-0-0--0" {
-  route_table_id         = "${aws_route_table.{{.EnvironmentName}}.id}"
-  destination_cidr_block = "0.0.0.0/0"
-  gateway_id             = "${aws_internet_gateway.{{.EnvironmentName}}.id}"
-}
-
-resource "aws_route" "private-{{.Region}}a-0-0-0-0--0" {
-  route_table_id         = "${aws_route_table.private-{{.Region}}a-{{.EnvironmentName}}.id}"
-  destination_cidr_block = "0.0.0.0/0"
-  nat_gateway_id         = "${aws_nat_gateway.{{.Region}}a-{{.EnvironmentName}}.id}"
-}
-
-resource "aws_route" "private-{{.Region}}b-0-0-0-0--0" {
-  route_table_id         = "${aws_route_table.private-{{.Region}}b-{{.EnvironmentName}}.id}"
-  destination_cidr_block = "0.0.0.0/0"
-  nat_gateway_id         = "${aws_nat_gateway.{{.Region}}b-{{.EnvironmentName}}.id}"
-}
-
-resource "aws_route_table" "private-{{.Region}}a-{{.EnvironmentName}}" {
-  vpc_id = "${aws_vpc.{{.EnvironmentName}}.id}"
-
-  tags = {
-    Name                                        = "private-{{.Region}}a.{{.EnvironmentName}}"
-  }
-}
-
-resource "aws_route_table" "private-{{.Region}}b-{{.EnvironmentName}}" {
-  vpc_id = "${aws_vpc.{{.EnvironmentName}}.id}"
-
-  tags = {
-    Name                                        = "private-{{.Region}}b.{{.EnvironmentName}}"
-  }
-}
-
-resource "aws_route_table" "{{.EnvironmentName}}" {
-  vpc_id = "${aws_vpc.{{.EnvironmentName}}.id}"
-
-  tags = {
-    Name                                        = "{{.EnvironmentName}}"
-  }
-}
-
-resource "aws_route_table_association" "private-{{.Region}}a-{{.EnvironmentName}}" {
-  subnet_id      = "${aws_subnet.{{.Region}}a-{{.EnvironmentName}}.id}"
-  route_table_id = "${aws_route_table.private-{{.Region}}a-{{.EnvironmentName}}.id}"
-}
-
-resource "aws_route_table_association" "private-{{.Region}}b-{{.EnvironmentName}}" {
-  subnet_id      = "${aws_subnet.{{.Region}}b-{{.EnvironmentName}}.id}"
-  route_table_id = "${aws_route_table.private-{{.Region}}b-{{.EnvironmentName}}.id}"
-}
-
-resource "aws_route_table_association" "utility-{{.Region}}a-{{.EnvironmentName}}" {
-  subnet_id      = "${aws_subnet.utility-{{.Region}}a-{{.EnvironmentName}}.id}"
-  route_table_id = "${aws_route_table.{{.EnvironmentName}}.id}"
-}
-
-resource "aws_route_table_association" "utility-{{.Region}}b-{{.EnvironmentName}}" {
-  subnet_id      = "${aws_subnet.utility-{{.Region}}b-{{.EnvironmentName}}.id}"
-  route_table_id = "${aws_route_table.{{.EnvironmentName}}.id}"
-}
-
-resource "aws_subnet" "{{.Region}}a-{{.EnvironmentName}}" {
-  vpc_id            = "${aws_vpc.{{.EnvironmentName}}.id}"
-  cidr_block        = "172.20.32.0/19"
-  availability_zone = "{{.Region}}a"
-
-  tags = {
-    Name                                        = "{{.Region}}a.{{.EnvironmentName}}"
-    SubnetType                                  = "Private"
-  }
-
-  lifecycle {
-    ignore_changes = ["tags"]
-  }
-}
-
-resource "aws_subnet" "{{.Region}}b-{{.EnvironmentName}}" {
-  vpc_id            = "${aws_vpc.{{.EnvironmentName}}.id}"
-  cidr_block        = "172.20.64.0/19"
-  availability_zone = "{{.Region}}b"
-
-  tags = {
-    Name                                        = "{{.Region}}b.{{.EnvironmentName}}"
-    SubnetType                                  = "Private"
-  }
-
-  lifecycle {
-    ignore_changes = ["tags"]
-  }
-}
-
-resource "aws_subnet" "utility-{{.Region}}a-{{.EnvironmentName}}" {
-  vpc_id            = "${aws_vpc.{{.EnvironmentName}}.id}"
-  cidr_block        = "172.20.0.0/22"
-  availability_zone = "{{.Region}}a"
-
-  tags = {
-    Name                                        = "utility-{{.Region}}a.{{.EnvironmentName}}"
-    SubnetType                                  = "Utility"
-  }
-
-  lifecycle {
-    ignore_changes = ["tags"]
-  }
-}
-
-resource "aws_subnet" "utility-{{.Region}}b-{{.EnvironmentName}}" {
-  vpc_id            = "${aws_vpc.{{.EnvironmentName}}.id}"
-  cidr_block        = "172.20.4.0/22"
-  availability_zone = "{{.Region}}b"
-
-  tags = {
-    Name                                        = "utility-{{.Region}}b.{{.EnvironmentName}}"
-    SubnetType                                  = "Utility"
-  }
-
-  lifecycle {
-    ignore_changes = ["tags"]
-  }
-}
-
-resource "aws_vpc" "{{.EnvironmentName}}" {
-  cidr_block           = "172.20.0.0/16"
-  enable_dns_hostnames = true
-  enable_dns_support   = true
-
-  tags = {
-    Name                                        = "{{.EnvironmentName}}"
-  }
-}
-
-resource "aws_vpc_dhcp_options" "{{.EnvironmentName}}" {
-  domain_name         = "{{.Region}}.compute.internal"
-  domain_name_servers = ["AmazonProvidedDNS"]
-
-  tags = {
-    Name                                        = "{{.EnvironmentName}}"
-  }
-}
-
-resource "aws_vpc_dhcp_options_association" "{{.EnvironmentName}}" {
-  vpc_id          = "${aws_vpc.{{.EnvironmentName}}.id}"
-  dhcp_options_id = "${aws_vpc_dhcp_options.{{.EnvironmentName}}.id}"
-}
-`
-
-func RenderNetwork(config *model.Config) {
-	terraformTemplate := parseNetworkTemplate(config.Spec.EnvironmentName, config.Spec.Region, config.Spec.ConfigBucket)
-	util.WriteFile("./network.tf", terraformTemplate)
-}
-
-func parseNetworkTemplate(environmentName, region, configBucket string) []byte {
-	var buf bytes.Buffer
-	tmpl, err := template.New("networkTemplate").Parse(networkTemplate)
-	util.CheckError(err)
-	err = tmpl.Execute(&buf, struct {
-		EnvironmentName string
-		Region          string
-		ConfigBucket    string
-	}{environmentName, region, configBucket})
-	util.CheckError(err)
-	return buf.Bytes()
+// The route to the internet gateway, named 0-0-0-0--0 until terraform 0.12 required names to start with a letter
+const publicDefaultRoute = "public-0-0-0-0--0"
+
+// networkConfiguration builds the VPC the clusters run in, laid out the same way kops lays out a network
+// of its own, along with the routes and security group rules for any peering connections.
+func networkConfiguration(values NetworkTemplate) []byte {
+	environmentName := values.EnvironmentName
+	vpcId := hcl.Reference("aws_vpc", environmentName, "id")
+	publicRouteTableId := hcl.Reference("aws_route_table", environmentName, "id")
+	mastersSecurityGroupId := hcl.Reference("aws_security_group", "k8s-masters-"+environmentName, "id")
+	nodesSecurityGroupId := hcl.Reference("aws_security_group", "k8s-nodes-"+environmentName, "id")
+
+	var privateSubnetIds, utilitySubnetIds []hcl.Expression
+	for _, zone := range values.Zones {
+		privateSubnetIds = append(privateSubnetIds, privateSubnetId(zone, environmentName))
+		utilitySubnetIds = append(utilitySubnetIds, utilitySubnetId(zone, environmentName))
+	}
+
+	file := componentFile(terraform.ComponentNetwork, values.Region, values.ConfigBucket)
+
+	// The same locals kops writes into the terraform it generates for a cluster
+	locals := file.Block("locals")
+	locals.Set("cluster_name", hcl.String(environmentName)).
+		Set("node_subnet_ids", hcl.List(privateSubnetIds...)).
+		Set("region", hcl.String(values.Region))
+	for _, zone := range values.Zones {
+		locals.Set(fmt.Sprintf("route_table_private-%s_id", zone.Zone), privateRouteTableId(zone, environmentName))
+	}
+	locals.Set("route_table_public_id", publicRouteTableId)
+	for _, zone := range values.Zones {
+		locals.Set(fmt.Sprintf("subnet_%s_id", zone.Zone), privateSubnetId(zone, environmentName)).
+			Set(fmt.Sprintf("subnet_utility-%s_id", zone.Zone), utilitySubnetId(zone, environmentName))
+	}
+	locals.Set("vpc_cidr_block", hcl.Reference("aws_vpc", environmentName, "cidr_block")).
+		Set("vpc_id", vpcId)
+
+	output(file, "cluster_name", hcl.String(environmentName))
+	output(file, "node_subnet_ids", hcl.List(privateSubnetIds...))
+	output(file, "region", hcl.String(values.Region))
+	for _, zone := range values.Zones {
+		output(file, fmt.Sprintf("route_table_private-%s_id", zone.Zone), privateRouteTableId(zone, environmentName))
+	}
+	output(file, "route_table_public_id", publicRouteTableId)
+	output(file, "private_subnet_ids", hcl.List(privateSubnetIds...))
+	output(file, "utility_subnet_ids", hcl.List(utilitySubnetIds...))
+	output(file, "vpc_cidr_block", hcl.Reference("aws_vpc", environmentName, "cidr_block"))
+	output(file, "vpc_id", vpcId)
+	output(file, "worker_security_group_id", nodesSecurityGroupId)
+	output(file, "master_security_group_id", mastersSecurityGroupId)
+
+	file.Block("resource", "aws_security_group", "k8s-masters-"+environmentName).
+		Set("name", hcl.String("masters.lol.k8s.local")).
+		Set("vpc_id", vpcId).
+		Set("description", hcl.String("Security group for masters"))
+
+	file.Block("resource", "aws_security_group", "k8s-nodes-"+environmentName).
+		Set("name", hcl.String("nodes.lol.k8s.local")).
+		Set("vpc_id", vpcId).
+		Set("description", hcl.String("Security group for nodes"))
+
+	file.Block("resource", "aws_internet_gateway", environmentName).
+		Set("vpc_id", vpcId).
+		Set("tags", nameTag(environmentName))
+
+	file.Block("resource", "aws_route", publicDefaultRoute).
+		Set("route_table_id", publicRouteTableId).
+		Set("destination_cidr_block", hcl.String("0.0.0.0/0")).
+		Set("gateway_id", hcl.Reference("aws_internet_gateway", environmentName, "id"))
+
+	file.Block("resource", "aws_route_table", environmentName).
+		Set("vpc_id", vpcId).
+		Set("tags", nameTag(environmentName))
+
+	for _, zone := range values.Zones {
+		zoneName := zone.Zone + "-" + environmentName
+
+		file.Block("resource", "aws_eip", zoneName).
+			Set("vpc", hcl.Bool(true)).
+			Set("tags", nameTag(zone.Zone+"."+environmentName))
+
+		file.Block("resource", "aws_nat_gateway", zoneName).
+			Set("allocation_id", hcl.Reference("aws_eip", zoneName, "id")).
+			Set("subnet_id", utilitySubnetId(zone, environmentName)).
+			Set("tags", nameTag(zone.Zone+"."+environmentName))
+
+		file.Block("resource", "aws_route", "private-"+zone.Zone+"-0-0-0-0--0").
+			Set("route_table_id", privateRouteTableId(zone, environmentName)).
+			Set("destination_cidr_block", hcl.String("0.0.0.0/0")).
+			Set("nat_gateway_id", hcl.Reference("aws_nat_gateway", zoneName, "id"))
+
+		file.Block("resource", "aws_route_table", "private-"+zoneName).
+			Set("vpc_id", vpcId).
+			Set("tags", nameTag("private-"+zone.Zone+"."+environmentName))
+
+		file.Block("resource", "aws_route_table_association", "private-"+zoneName).
+			Set("subnet_id", privateSubnetId(zone, environmentName)).
+			Set("route_table_id", privateRouteTableId(zone, environmentName))
+
+		file.Block("resource", "aws_route_table_association", "utility-"+zoneName).
+			Set("subnet_id", utilitySubnetId(zone, environmentName)).
+			Set("route_table_id", publicRouteTableId)
+
+		subnet(file, zoneName, vpcId, zone.PrivateCidr, zone.Zone, zone.Zone+"."+environmentName, "Private")
+		subnet(file, "utility-"+zoneName, vpcId, zone.UtilityCidr, zone.Zone, "utility-"+zone.Zone+"."+environmentName, "Utility")
+	}
+
+	file.Block("resource", "aws_vpc", environmentName).
+		Set("cidr_block", hcl.String(values.VpcCidr)).
+		Set("enable_dns_hostnames", hcl.Bool(true)).
+		Set("enable_dns_support", hcl.Bool(true)).
+		Set("tags", nameTag(environmentName))
+
+	file.Block("resource", "aws_vpc_dhcp_options", environmentName).
+		Set("domain_name", hcl.String(values.Region+".compute.internal")).
+		Set("domain_name_servers", hcl.Strings("AmazonProvidedDNS")).
+		Set("tags", nameTag(environmentName))
+
+	file.Block("resource", "aws_vpc_dhcp_options_association", environmentName).
+		Set("vpc_id", vpcId).
+		Set("dhcp_options_id", hcl.Reference("aws_vpc_dhcp_options", environmentName, "id"))
+
+	for _, peeringConnection := range values.PeeringConnections {
+		peeringName := environmentName + "-" + peeringConnection.Name
+		connectionId := hcl.Reference(peeringConnection.ConnectionReference, "id")
+
+		peering := file.Block("resource", "aws_vpc_peering_connection", peeringName)
+		peering.Set("vpc_id", vpcId).
+			Set("peer_vpc_id", hcl.String(peeringConnection.PeerVpcId))
+		if peeringConnection.PeerAccountId != "" {
+			peering.Set("peer_owner_id", hcl.String(peeringConnection.PeerAccountId))
+		}
+		if peeringConnection.PeerRegion != values.Region {
+			peering.Set("peer_region", hcl.String(peeringConnection.PeerRegion))
+		}
+		peering.Set("auto_accept", hcl.Bool(peeringConnection.AutoAccept)).
+			Set("tags", nameTag(peeringConnection.Name+"."+environmentName))
+
+		if peeringConnection.PeerRoleArn != "" {
+			peerProvider := file.Block("provider", "aws")
+			peerProvider.Set("alias", hcl.String("peer-"+peeringConnection.Name)).
+				Set("region", hcl.String(peeringConnection.PeerRegion))
+			peerProvider.Block("assume_role").
+				Set("role_arn", hcl.String(peeringConnection.PeerRoleArn))
+
+			file.Block("resource", "aws_vpc_peering_connection_accepter", peeringName).
+				Set("provider", hcl.Reference("aws", "peer-"+peeringConnection.Name)).
+				Set("vpc_peering_connection_id", hcl.Reference("aws_vpc_peering_connection", peeringName, "id")).
+				Set("auto_accept", hcl.Bool(true)).
+				Set("tags", nameTag(peeringConnection.Name+"."+environmentName))
+		}
+
+		for _, zone := range values.Zones {
+			file.Block("resource", "aws_route", "private-"+zone.Zone+"-peer-"+peeringConnection.Name).
+				Set("route_table_id", privateRouteTableId(zone, environmentName)).
+				Set("destination_cidr_block", hcl.String(peeringConnection.PeerVpcCidr)).
+				Set("vpc_peering_connection_id", connectionId)
+		}
+
+		file.Block("resource", "aws_route", "public-peer-"+peeringConnection.Name).
+			Set("route_table_id", publicRouteTableId).
+			Set("destination_cidr_block", hcl.String(peeringConnection.PeerVpcCidr)).
+			Set("vpc_peering_connection_id", connectionId)
+
+		for _, role := range []string{"masters", "nodes"} {
+			file.Block("resource", "aws_security_group_rule", fmt.Sprintf("k8s-%s-%s-peer-%s", role, environmentName, peeringConnection.Name)).
+				Set("type", hcl.String("ingress")).
+				Set("security_group_id", hcl.Reference("aws_security_group", fmt.Sprintf("k8s-%s-%s", role, environmentName), "id")).
+				Set("from_port", hcl.Number(0)).
+				Set("to_port", hcl.Number(0)).
+				Set("protocol", hcl.String("-1")).
+				Set("cidr_blocks", hcl.Strings(peeringConnection.PeerVpcCidr))
+		}
+	}
+
+	return file.Bytes()
+}
+
+// Subnets are tagged by kops when a cluster is created in them, those tags are left alone.
+func subnet(file *hcl.File, name string, vpcId hcl.Expression, cidr, zone, nameTagValue, subnetType string) {
+	subnet := file.Block("resource", "aws_subnet", name)
+	subnet.Set("vpc_id", vpcId).
+		Set("cidr_block", hcl.String(cidr)).
+		Set("availability_zone", hcl.String(zone)).
+		Set("tags", hcl.Object{
+			"Name":       hcl.String(nameTagValue),
+			"SubnetType": hcl.String(subnetType),
+		})
+	subnet.Block("lifecycle").
+		Set("ignore_changes", hcl.List(hcl.Reference("tags")))
+}
+
+func privateSubnetId(zone ZoneLayout, environmentName string) hcl.Expression {
+	return hcl.Reference("aws_subnet", zone.Zone+"-"+environmentName, "id")
+}
+
+func utilitySubnetId(zone ZoneLayout, environmentName string) hcl.Expression {
+	return hcl.Reference("aws_subnet", "utility-"+zone.Zone+"-"+environmentName, "id")
+}
+
+func privateRouteTableId(zone ZoneLayout, environmentName string) hcl.Expression {
+	return hcl.Reference("aws_route_table", "private-"+zone.Zone+"-"+environmentName, "id")
+}
+
+func RenderNetwork(config *model.Config) error {
+	layout, err := networkLayout(config.Spec)
+	if err != nil {
+		return err
+	}
+	peeringConnections, err := peeringConnectionTemplates(config.Spec.EnvironmentName, config.Spec.Region, config.Spec.PeeringConnections)
+	if err != nil {
+		return err
+	}
+	return writeComponent(terraform.ComponentNetwork, networkConfiguration(NetworkTemplate{
+		EnvironmentName:    config.Spec.EnvironmentName,
+		Region:             config.Spec.Region,
+		ConfigBucket:       config.Spec.ConfigBucket,
+		VpcCidr:            layout.VpcCidr,
+		Zones:              layout.Zones,
+		PeeringConnections: peeringConnections,
+	}))
+}
+
+func peeringConnectionTemplates(environmentName, region string, peeringConnections []model.PeeringConnection) ([]PeeringConnectionTemplate, error) {
+	var peeringConnectionTemplates []PeeringConnectionTemplate
+	for _, peeringConnection := range peeringConnections {
+		if peeringConnection.PeerVpcId == "" || peeringConnection.PeerVpcCidr == "" {
+			return nil, util.NewError(util.ConfigError, "peering connection %s requires both peer-vpc-id and peer-vpc-cidr", peeringConnection.Name)
+		}
+
+		peerRegion := peeringConnection.PeerRegion
+		if peerRegion == "" {
+			peerRegion = region
+		}
+
+		// AWS only allows the requester to accept a peering connection within the same account and region,
+		// anything else has to be accepted from the peer side.
+		autoAccept := peeringConnection.PeerAccountId == "" && peerRegion == region
+		connectionReference := fmt.Sprintf("aws_vpc_peering_connection.%s-%s", environmentName, peeringConnection.Name)
+		if !autoAccept {
+			if peeringConnection.PeerRoleArn != "" {
+				connectionReference = fmt.Sprintf("aws_vpc_peering_connection_accepter.%s-%s", environmentName, peeringConnection.Name)
+			} else {
+				log.Printf("Peering connection %s must be accepted by the owner of %s", peeringConnection.Name, peeringConnection.PeerVpcId)
+			}
+		}
+
+		peeringConnectionTemplates = append(peeringConnectionTemplates, PeeringConnectionTemplate{
+			Name:                peeringConnection.Name,
+			PeerVpcCidr:         peeringConnection.PeerVpcCidr,
+			PeerVpcId:           peeringConnection.PeerVpcId,
+			PeerAccountId:       peeringConnection.PeerAccountId,
+			PeerRegion:          peerRegion,
+			PeerRoleArn:         peeringConnection.PeerRoleArn,
+			AutoAccept:          autoAccept,
+			ConnectionReference: connectionReference,
+		})
+	}
+	return peeringConnectionTemplates, nil
+}
+
+type NetworkTemplate struct {
+	EnvironmentName, Region, ConfigBucket, VpcCidr string
+	Zones                                          []ZoneLayout
+	PeeringConnections                             []PeeringConnectionTemplate
+}
+
+type PeeringConnectionTemplate struct {
+	Name, PeerVpcCidr, PeerVpcId, PeerAccountId,
+	PeerRegion, PeerRoleArn, ConnectionReference string
+	AutoAccept bool
 }
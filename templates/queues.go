@@ -0,0 +1,93 @@
+package templates
+
+import (
+	"github.com/infinityworks/fk-infra/hcl"
+	"github.com/infinityworks/fk-infra/model"
+	"github.com/infinityworks/fk-infra/terraform"
+	"github.com/infinityworks/fk-infra/util"
+)
+
+const defaultMaxReceiveCount = 5
+
+func RenderQueues(config *model.Config) error {
+	queues, err := queueTemplates(config.Spec.Queues)
+	if err != nil {
+		return err
+	}
+	return writeComponent(terraform.ComponentQueues, queuesConfiguration(QueuesTemplate{
+		ConfigBucket:    config.Spec.ConfigBucket,
+		Region:          config.Spec.Region,
+		EnvironmentName: config.Spec.EnvironmentName,
+		MaxReceiveCount: defaultMaxReceiveCount,
+		Queues:          queues,
+	}))
+}
+
+// queuesConfiguration builds an SQS queue with a dead letter queue or an SNS topic for each queue.
+func queuesConfiguration(values QueuesTemplate) []byte {
+	file := componentFile(terraform.ComponentQueues, values.Region, values.ConfigBucket)
+	for _, queue := range values.Queues {
+		name := values.EnvironmentName + "-" + queue.Name
+		if queue.Type == model.QueueTypeSqs {
+			deadLetterName := name + "-dead-letter"
+			file.Block("resource", "aws_sqs_queue", deadLetterName).
+				Set("name", hcl.String(deadLetterName)).
+				Set("message_retention_seconds", hcl.Number(1209600)).
+				Set("tags", nameTag(deadLetterName))
+
+			file.Block("resource", "aws_sqs_queue", name).
+				Set("name", hcl.String(name)).
+				Set("redrive_policy", hcl.Call("jsonencode", hcl.Object{
+					"deadLetterTargetArn": hcl.Reference("aws_sqs_queue", deadLetterName, "arn"),
+					"maxReceiveCount":     hcl.Number(values.MaxReceiveCount),
+				})).
+				Set("tags", nameTag(name))
+
+			output(file, terraform.QueueOutputName(queue.Name), hcl.Object{
+				"name":            hcl.String(queue.Name),
+				"type":            hcl.String(model.QueueTypeSqs),
+				"url":             hcl.Reference("aws_sqs_queue", name, "id"),
+				"arn":             hcl.Reference("aws_sqs_queue", name, "arn"),
+				"dead_letter_url": hcl.Reference("aws_sqs_queue", deadLetterName, "id"),
+				"dead_letter_arn": hcl.Reference("aws_sqs_queue", deadLetterName, "arn"),
+			})
+		} else {
+			file.Block("resource", "aws_sns_topic", name).
+				Set("name", hcl.String(name))
+
+			output(file, terraform.QueueOutputName(queue.Name), hcl.Object{
+				"name": hcl.String(queue.Name),
+				"type": hcl.String(model.QueueTypeSns),
+				"arn":  hcl.Reference("aws_sns_topic", name, "arn"),
+			})
+		}
+	}
+	return file.Bytes()
+}
+
+func queueTemplates(queues []model.Queue) ([]QueueTemplate, error) {
+	var queueTemplates []QueueTemplate
+	for _, queue := range queues {
+		if queue.Type != model.QueueTypeSqs && queue.Type != model.QueueTypeSns {
+			return nil, util.NewError(util.ConfigError, "queue %s has unsupported type %s, expected %s or %s", queue.Name, queue.Type, model.QueueTypeSqs, model.QueueTypeSns)
+		}
+		queueTemplates = append(queueTemplates, QueueTemplate{
+			Name: queue.Name,
+			Type: queue.Type,
+		})
+	}
+	return queueTemplates, nil
+}
+
+type QueuesTemplate struct {
+	Region          string
+	ConfigBucket    string
+	EnvironmentName string
+	MaxReceiveCount int
+	Queues          []QueueTemplate
+}
+
+type QueueTemplate struct {
+	Name string
+	Type string
+}
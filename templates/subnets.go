@@ -0,0 +1,155 @@
+package templates
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/infinityworks/fk-infra/model"
+	"github.com/infinityworks/fk-infra/util"
+	"net"
+	"regexp"
+	"strings"
+)
+
+const (
+	defaultVpcCidr               = "172.20.0.0/16"
+	defaultAvailabilityZoneCount = 2
+	defaultPrivateSubnetSize     = 19
+	defaultUtilitySubnetSize     = 22
+	minimumAvailabilityZones     = 2
+	availabilityZoneLetters      = "abcdefghijklmnopqrstuvwxyz"
+	// AWS only accepts VPCs and subnets between these sizes
+	largestBlockSize  = 16
+	smallestBlockSize = 28
+)
+
+// Zones end up in the names of subnets and kops instance groups.
+var availabilityZonePattern = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+type NetworkLayout struct {
+	VpcCidr string
+	Zones   []ZoneLayout
+}
+
+type ZoneLayout struct {
+	Zone, Suffix, PrivateCidr, UtilityCidr string
+}
+
+// The VPC is divided into blocks the size of a private subnet. The first block is carved up into the
+// utility subnets and each zone then takes one of the following blocks for its private subnet, which
+// with the defaults reproduces the layout kops creates for a two zone cluster.
+func networkLayout(spec model.Spec) (NetworkLayout, error) {
+	network := spec.Network
+	if network == nil {
+		network = &model.Network{}
+	}
+
+	vpcCidr := defaultString(network.VpcCidr, defaultVpcCidr)
+	privateSubnetSize := defaultInt(network.PrivateSubnetSize, defaultPrivateSubnetSize)
+	utilitySubnetSize := defaultInt(network.UtilitySubnetSize, defaultUtilitySubnetSize)
+	zones, err := availabilityZones(spec.Region, network)
+	if err != nil {
+		return NetworkLayout{}, err
+	}
+
+	_, vpcNetwork, err := net.ParseCIDR(vpcCidr)
+	if err != nil {
+		return NetworkLayout{}, util.WrapError(util.ConfigError, err, "invalid VPC CIDR %s", vpcCidr)
+	}
+	vpcSize, bits := vpcNetwork.Mask.Size()
+	if bits != 32 {
+		return NetworkLayout{}, util.NewError(util.ConfigError, "VPC CIDR %s must be an IPv4 range", vpcCidr)
+	}
+	if vpcSize < largestBlockSize || vpcSize > smallestBlockSize {
+		return NetworkLayout{}, util.NewError(util.ConfigError, "VPC CIDR %s must be between /%d and /%d", vpcCidr, largestBlockSize, smallestBlockSize)
+	}
+	for _, subnetSize := range []int{privateSubnetSize, utilitySubnetSize} {
+		if subnetSize < largestBlockSize || subnetSize > smallestBlockSize {
+			return NetworkLayout{}, util.NewError(util.ConfigError, "subnet size /%d must be between /%d and /%d", subnetSize, largestBlockSize, smallestBlockSize)
+		}
+	}
+	if len(zones) < minimumAvailabilityZones {
+		return NetworkLayout{}, util.NewError(util.ConfigError, "at least %d availability zones are required, %d configured", minimumAvailabilityZones, len(zones))
+	}
+	if privateSubnetSize < vpcSize || 1<<uint(privateSubnetSize-vpcSize) < len(zones)+1 {
+		return NetworkLayout{}, util.NewError(util.ConfigError, "VPC CIDR %s is too small for %d private /%d subnets and their utility subnets", vpcCidr, len(zones), privateSubnetSize)
+	}
+	if utilitySubnetSize < privateSubnetSize || 1<<uint(utilitySubnetSize-privateSubnetSize) < len(zones) {
+		return NetworkLayout{}, util.NewError(util.ConfigError, "%d utility /%d subnets don't fit in a single /%d block", len(zones), utilitySubnetSize, privateSubnetSize)
+	}
+
+	vpcBase := binary.BigEndian.Uint32(vpcNetwork.IP.To4())
+	layout := NetworkLayout{VpcCidr: vpcNetwork.String()}
+	for i, zone := range zones {
+		layout.Zones = append(layout.Zones, ZoneLayout{
+			Zone:        zone,
+			Suffix:      strings.TrimPrefix(zone, spec.Region),
+			PrivateCidr: subnetCidr(vpcBase, privateSubnetSize, uint32(i+1)),
+			UtilityCidr: subnetCidr(vpcBase, utilitySubnetSize, uint32(i)),
+		})
+	}
+	return layout, nil
+}
+
+func (layout NetworkLayout) ZoneNames() []string {
+	var zoneNames []string
+	for _, zone := range layout.Zones {
+		zoneNames = append(zoneNames, zone.Zone)
+	}
+	return zoneNames
+}
+
+func availabilityZones(region string, network *model.Network) ([]string, error) {
+	if len(network.AvailabilityZones) > 0 {
+		if err := validateAvailabilityZones(region, network.AvailabilityZones); err != nil {
+			return nil, err
+		}
+		return network.AvailabilityZones, nil
+	}
+
+	zoneCount := defaultInt(network.AvailabilityZoneCount, defaultAvailabilityZoneCount)
+	if zoneCount > len(availabilityZoneLetters) {
+		return nil, util.NewError(util.ConfigError, "availability zone count %d is larger than any region", zoneCount)
+	}
+	var zones []string
+	for _, letter := range availabilityZoneLetters[:zoneCount] {
+		zones = append(zones, fmt.Sprintf("%s%c", region, letter))
+	}
+	return zones, nil
+}
+
+func validateAvailabilityZones(region string, zones []string) error {
+	seen := make(map[string]bool)
+	for _, zone := range zones {
+		if !strings.HasPrefix(zone, region) || len(zone) == len(region) {
+			return util.NewError(util.ConfigError, "availability zone %q is not in region %s", zone, region)
+		}
+		if !availabilityZonePattern.MatchString(zone) {
+			return util.NewError(util.ConfigError, "availability zone %q can only contain lowercase letters, digits and dashes", zone)
+		}
+		if seen[zone] {
+			return util.NewError(util.ConfigError, "availability zone %s is listed more than once", zone)
+		}
+		seen[zone] = true
+	}
+	return nil
+}
+
+func subnetCidr(vpcBase uint32, size int, index uint32) string {
+	subnetBase := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(subnetBase, vpcBase+index*(1<<uint(32-size)))
+	return fmt.Sprintf("%s/%d", subnetBase, size)
+}
+
+func defaultString(value, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+func defaultInt(value, defaultValue int) int {
+	if value == 0 {
+		return defaultValue
+	}
+	return value
+}
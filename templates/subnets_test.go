@@ -0,0 +1,117 @@
+package templates
+
+import (
+	"github.com/infinityworks/fk-infra/model"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNetworkLayout(t *testing.T) {
+	tests := []struct {
+		name    string
+		network *model.Network
+		want    NetworkLayout
+		wantErr string
+	}{
+		{
+			name: "defaults reproduce the layout kops creates for two zones",
+			want: NetworkLayout{
+				VpcCidr: "172.20.0.0/16",
+				Zones: []ZoneLayout{
+					{Zone: "eu-west-1a", Suffix: "a", PrivateCidr: "172.20.32.0/19", UtilityCidr: "172.20.0.0/22"},
+					{Zone: "eu-west-1b", Suffix: "b", PrivateCidr: "172.20.64.0/19", UtilityCidr: "172.20.4.0/22"},
+				},
+			},
+		},
+		{
+			name:    "explicit zones and sizes",
+			network: &model.Network{VpcCidr: "10.0.0.0/20", AvailabilityZones: []string{"eu-west-1b", "eu-west-1c", "eu-west-1a"}, PrivateSubnetSize: 22, UtilitySubnetSize: 26},
+			want: NetworkLayout{
+				VpcCidr: "10.0.0.0/20",
+				Zones: []ZoneLayout{
+					{Zone: "eu-west-1b", Suffix: "b", PrivateCidr: "10.0.4.0/22", UtilityCidr: "10.0.0.0/26"},
+					{Zone: "eu-west-1c", Suffix: "c", PrivateCidr: "10.0.8.0/22", UtilityCidr: "10.0.0.64/26"},
+					{Zone: "eu-west-1a", Suffix: "a", PrivateCidr: "10.0.12.0/22", UtilityCidr: "10.0.0.128/26"},
+				},
+			},
+		},
+		{
+			name:    "private subnets overflowing the VPC",
+			network: &model.Network{AvailabilityZoneCount: 8},
+			wantErr: "VPC CIDR 172.20.0.0/16 is too small for 8 private /19 subnets",
+		},
+		{
+			name:    "private subnets larger than the VPC",
+			network: &model.Network{VpcCidr: "10.0.0.0/20", PrivateSubnetSize: 19, UtilitySubnetSize: 24},
+			wantErr: "VPC CIDR 10.0.0.0/20 is too small",
+		},
+		{
+			name:    "utility subnets overlapping the private subnets",
+			network: &model.Network{UtilitySubnetSize: 18},
+			wantErr: "2 utility /18 subnets don't fit in a single /19 block",
+		},
+		{
+			name:    "utility subnets overflowing their block",
+			network: &model.Network{AvailabilityZoneCount: 3, UtilitySubnetSize: 20},
+			wantErr: "3 utility /20 subnets don't fit in a single /19 block",
+		},
+		{
+			name:    "subnets smaller than AWS allows",
+			network: &model.Network{UtilitySubnetSize: 29},
+			wantErr: "subnet size /29 must be between /16 and /28",
+		},
+		{
+			name:    "subnets larger than AWS allows",
+			network: &model.Network{VpcCidr: "10.0.0.0/8", PrivateSubnetSize: 12},
+			wantErr: "VPC CIDR 10.0.0.0/8 must be between /16 and /28",
+		},
+		{
+			name:    "IPv6 VPC",
+			network: &model.Network{VpcCidr: "fd00::/56"},
+			wantErr: "must be an IPv4 range",
+		},
+		{
+			name:    "zone in another region",
+			network: &model.Network{AvailabilityZones: []string{"eu-west-1a", "us-east-1a"}},
+			wantErr: `availability zone "us-east-1a" is not in region eu-west-1`,
+		},
+		{
+			name:    "zone that is just the region",
+			network: &model.Network{AvailabilityZones: []string{"eu-west-1a", "eu-west-1"}},
+			wantErr: `availability zone "eu-west-1" is not in region eu-west-1`,
+		},
+		{
+			name:    "zone unsafe in resource names",
+			network: &model.Network{AvailabilityZones: []string{"eu-west-1a", "eu-west-1b\"}"}},
+			wantErr: "can only contain lowercase letters, digits and dashes",
+		},
+		{
+			name:    "zone listed twice",
+			network: &model.Network{AvailabilityZones: []string{"eu-west-1a", "eu-west-1a"}},
+			wantErr: "availability zone eu-west-1a is listed more than once",
+		},
+		{
+			name:    "single zone",
+			network: &model.Network{AvailabilityZones: []string{"eu-west-1a"}},
+			wantErr: "at least 2 availability zones are required, 1 configured",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			layout, err := networkLayout(model.Spec{Region: "eu-west-1", Network: test.network})
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(layout, test.want) {
+				t.Errorf("expected %+v, got %+v", test.want, layout)
+			}
+		})
+	}
+}
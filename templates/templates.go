@@ -0,0 +1,144 @@
+package templates
+
+import (
+	"bytes"
+	"github.com/infinityworks/fk-infra/hcl"
+	"github.com/infinityworks/fk-infra/kubernetes"
+	"github.com/infinityworks/fk-infra/model"
+	"github.com/infinityworks/fk-infra/terraform"
+	"github.com/infinityworks/fk-infra/util"
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+const awsProviderVersion = "~> 3.0"
+
+// RenderTerraform writes the terraform for every component described in the config.
+func RenderTerraform(config *model.Config) error {
+	return renderTerraform(config, RenderDatabases)
+}
+
+// RenderTerraformForPlan writes the terraform for every component without creating anything, for a plan that
+// hasn't been approved yet.
+func RenderTerraformForPlan(config *model.Config) error {
+	return renderTerraform(config, renderDatabasesForPlan)
+}
+
+// RenderTerraformForDestroy writes terraform that is only used to tear the environment down. Databases don't read
+// their passwords, so no password secrets are created while destroying.
+func RenderTerraformForDestroy(config *model.Config) error {
+	return renderTerraform(config, renderDatabasesForDestroy)
+}
+
+// ValidateConfig checks everything in the config that can be checked before anything is applied.
+func ValidateConfig(spec model.Spec) error {
+	if err := validateResourceNames(spec); err != nil {
+		return err
+	}
+	if err := kubernetes.ValidateObjects(spec); err != nil {
+		return err
+	}
+	if err := validateElasticSearch(spec.ElasticSearch); err != nil {
+		return err
+	}
+	return validateLogOutputs(spec)
+}
+
+func renderTerraform(config *model.Config, renderDatabases func(*model.Config) error) error {
+	if err := ValidateConfig(config.Spec); err != nil {
+		return err
+	}
+	for _, render := range []func(*model.Config) error{RenderNetwork, RenderElasticSearch, renderDatabases, RenderQueues} {
+		if err := render(config); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Resources are named after the environment and the names in the config, which terraform 0.12 requires to
+// start with a letter.
+func validateResourceNames(spec model.Spec) error {
+	names := []string{spec.EnvironmentName}
+	for _, database := range spec.Databases {
+		names = append(names, database.Name)
+	}
+	for _, elasticSearch := range spec.ElasticSearch {
+		names = append(names, elasticSearch.Name)
+	}
+	for _, queue := range spec.Queues {
+		names = append(names, queue.Name)
+	}
+	for _, peeringConnection := range spec.PeeringConnections {
+		names = append(names, peeringConnection.Name)
+	}
+	for _, name := range names {
+		if !hcl.ValidIdentifier(name) {
+			return util.NewError(util.ConfigError, "%q can't be used to name terraform resources, names must start with a letter and only contain letters, digits, dashes and underscores", name)
+		}
+	}
+	return nil
+}
+
+// writeComponent writes the terraform for a component into its own directory, where it is planned and applied
+// with its own state.
+func writeComponent(component string, content []byte) error {
+	componentDirectory := terraform.ComponentDirectory(component)
+	if err := os.MkdirAll(componentDirectory, 0750); err != nil {
+		return util.WrapError(util.ConfigError, err, "unable to create %s", componentDirectory)
+	}
+	return util.WriteFile(filepath.Join(componentDirectory, "main.tf"), content)
+}
+
+// componentFile starts the terraform for a component with the provider and the backend holding its state.
+func componentFile(component, region, configBucket string) *hcl.File {
+	file := hcl.NewFile()
+	file.Block("provider", "aws").
+		Set("region", hcl.String(region))
+
+	settings := file.Block("terraform")
+	settings.Block("backend", "s3").
+		Set("bucket", hcl.String(configBucket)).
+		Set("key", hcl.String(terraform.StateKey(component))).
+		Set("region", hcl.String(region))
+	settings.Set("required_version", hcl.String(terraform.RequiredVersion))
+	settings.Block("required_providers").
+		Set("aws", hcl.String(awsProviderVersion))
+	return file
+}
+
+// networkRemoteState makes the outputs of the network available to a component built on top of it.
+func networkRemoteState(file *hcl.File, region, configBucket string) {
+	file.Block("data", "terraform_remote_state", terraform.ComponentNetwork).
+		Set("backend", hcl.String("s3")).
+		Set("config", hcl.Object{
+			"bucket": hcl.String(configBucket),
+			"key":    hcl.String(terraform.StateKey(terraform.ComponentNetwork)),
+			"region": hcl.String(region),
+		})
+}
+
+func networkOutput(name string) hcl.Expression {
+	return hcl.Reference("data.terraform_remote_state", terraform.ComponentNetwork, "outputs", name)
+}
+
+func renderTemplate(name, text string, values interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, util.WrapError(util.UnknownError, err, "invalid template %s", name)
+	}
+	err = tmpl.Execute(&buf, values)
+	return buf.Bytes(), util.WrapError(util.UnknownError, err, "unable to render template %s", name)
+}
+
+func output(file *hcl.File, name string, value hcl.Expression) *hcl.Block {
+	outputBlock := file.Block("output", name)
+	outputBlock.Set("value", value)
+	return outputBlock
+}
+
+func nameTag(name string) hcl.Object {
+	return hcl.Object{"Name": hcl.String(name)}
+}
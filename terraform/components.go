@@ -0,0 +1,42 @@
+package terraform
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+const (
+	ComponentNetwork       = "network"
+	ComponentElasticSearch = "elasticsearch"
+	ComponentDatabase      = "database"
+	ComponentQueues        = "queues"
+)
+
+// Component is a terraform root module rendered into a directory of its own, with its own state.
+type Component struct {
+	Name string
+	// DependsOn names the components whose outputs this one reads through remote state.
+	DependsOn []string
+}
+
+// Components are in dependency order, apply walks them forwards and destroy backwards.
+var Components = []Component{
+	{Name: ComponentNetwork},
+	{Name: ComponentElasticSearch, DependsOn: []string{ComponentNetwork}},
+	{Name: ComponentDatabase, DependsOn: []string{ComponentNetwork}},
+	{Name: ComponentQueues},
+}
+
+func ComponentDirectory(component string) string {
+	return filepath.Join("terraform", component)
+}
+
+// StateKey is where the component keeps its state in the config bucket.
+func StateKey(component string) string {
+	return fmt.Sprintf("terraform/%s/terraform.tfstate", component)
+}
+
+// ComponentPlanFile is where the plan for a single component is saved alongside planFile.
+func ComponentPlanFile(planFile, component string) string {
+	return fmt.Sprintf("%s.%s", planFile, component)
+}
@@ -0,0 +1,347 @@
+package terraform
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"github.com/infinityworks/fk-infra/aws"
+	"github.com/infinityworks/fk-infra/util"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Terraform 0.11 writes version 3 state, 0.12 upgrades it to version 4 the first time it writes it.
+const legacyStateVersion = 3
+
+// Resources renamed for terraform 0.12, which requires names to start with a letter. The old names can't be
+// addressed by terraform state mv in 0.12 so they are renamed in the state before terraform reads it.
+var legacyResourceRenames = map[string]map[string]string{
+	ComponentNetwork: {"aws_route.0-0-0-0--0": "aws_route.public-0-0-0-0--0"},
+}
+
+// Before each component had a root module of its own all of them shared a single state. Resources and outputs
+// now declared by another component are moved to its state, anything not listed stays where it is.
+var legacyComponentAddresses = []struct {
+	component string
+	pattern   *regexp.Regexp
+}{
+	{ComponentDatabase, regexp.MustCompile(`^aws_db_(instance|subnet_group|parameter_group)\.`)},
+	{ComponentDatabase, regexp.MustCompile(`^aws_security_group\..*-database-access$`)},
+	{ComponentDatabase, regexp.MustCompile(`^` + databaseOutputPrefix)},
+	{ComponentElasticSearch, regexp.MustCompile(`^aws_elasticsearch_domain\.`)},
+	{ComponentElasticSearch, regexp.MustCompile(`^aws_security_group\..*-elasticsearch$`)},
+	{ComponentElasticSearch, regexp.MustCompile(`^` + elasticSearchOutputPrefix)},
+	{ComponentQueues, regexp.MustCompile(`^aws_(sqs_queue|sns_topic)\.`)},
+	{ComponentQueues, regexp.MustCompile(`^` + queueOutputPrefix)},
+}
+
+// stateBucket reads and writes the objects in the config bucket holding terraform state, report describes each
+// step of the migration as it is taken.
+type stateBucket struct {
+	name   string
+	fetch  func(key string) ([]byte, bool, error)
+	put    func(key string, content []byte) error
+	report func(format string, v ...interface{})
+}
+
+func configStateBucket(configBucket, region string) stateBucket {
+	return stateBucket{
+		name: configBucket,
+		fetch: func(key string) ([]byte, bool, error) {
+			return aws.FetchObject(configBucket, key, region)
+		},
+		put: func(key string, content []byte) error {
+			return aws.PutObject(configBucket, key, region, content)
+		},
+		report: log.Printf,
+	}
+}
+
+// dryRun describes the steps of a migration without writing anything to the bucket.
+func (bucket stateBucket) dryRun() (stateBucket, *[]string) {
+	var steps []string
+	bucket.put = func(key string, content []byte) error {
+		return nil
+	}
+	bucket.report = func(format string, v ...interface{}) {
+		steps = append(steps, fmt.Sprintf(format, v...))
+	}
+	return bucket, &steps
+}
+
+// legacyState is the version 3 state of a component, changed is set once it has to be written back.
+type legacyState struct {
+	state   map[string]interface{}
+	changed bool
+}
+
+// MigrateLegacyState prepares the state terraform 0.11 left for each component. The original is copied next to
+// it in the config bucket before resources are moved to the component declaring them and renamed, terraform
+// itself upgrades the format on the next apply. It has to run before anything reads the outputs of a component.
+func MigrateLegacyState(configBucket, region string) error {
+	return migrateLegacyState(configStateBucket(configBucket, region))
+}
+
+// PendingStateMigration describes what MigrateLegacyState would change without changing it, nothing is pending
+// once every component has been migrated.
+func PendingStateMigration(configBucket, region string) ([]string, error) {
+	return pendingStateMigration(configStateBucket(configBucket, region))
+}
+
+func pendingStateMigration(bucket stateBucket) ([]string, error) {
+	dryRunBucket, steps := bucket.dryRun()
+	err := migrateLegacyState(dryRunBucket)
+	return *steps, err
+}
+
+func migrateLegacyState(bucket stateBucket) error {
+	states := make(map[string]*legacyState)
+	currentStates := make(map[string]bool)
+	for _, component := range Components {
+		stateKey := StateKey(component.Name)
+		stateBytes, found, err := bucket.fetch(stateKey)
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+
+		// Numbers are kept as they are, state holds values such as ports and sizes that shouldn't turn into floats
+		var state map[string]interface{}
+		decoder := json.NewDecoder(bytes.NewReader(stateBytes))
+		decoder.UseNumber()
+		if err := decoder.Decode(&state); err != nil {
+			return util.WrapError(util.ExecutionError, err, "unable to read terraform state for %s", component.Name)
+		}
+		if stateNumber(state, "version") > legacyStateVersion {
+			currentStates[component.Name] = true
+			continue
+		}
+
+		backupKey := stateKey + ".0.11.backup"
+		if _, backedUp, err := bucket.fetch(backupKey); err != nil {
+			return err
+		} else if !backedUp {
+			bucket.report("Terraform state for %s was written by terraform 0.11, keeping a copy in s3://%s/%s", component.Name, bucket.name, backupKey)
+			if err := bucket.put(backupKey, stateBytes); err != nil {
+				return err
+			}
+		}
+		states[component.Name] = &legacyState{state: state}
+	}
+
+	if err := splitLegacyStates(states, currentStates, bucket.report); err != nil {
+		return err
+	}
+
+	for _, component := range Components {
+		legacy, ok := states[component.Name]
+		if !ok {
+			continue
+		}
+		if renameLegacyResources(legacy.state, legacyResourceRenames[component.Name], bucket.report) {
+			legacy.changed = true
+		}
+		if !legacy.changed {
+			continue
+		}
+		legacy.state["serial"] = stateNumber(legacy.state, "serial") + 1
+		migratedBytes, err := json.MarshalIndent(legacy.state, "", "    ")
+		if err != nil {
+			return util.WrapError(util.ExecutionError, err, "unable to write terraform state for %s", component.Name)
+		}
+		if err := bucket.put(StateKey(component.Name), migratedBytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitLegacyStates moves resources and outputs to the state of the component declaring them, creating it when
+// the component has never been applied on its own. A component that already has terraform 0.12 state is never
+// written to here, the resources have to be moved by hand with terraform state mv.
+func splitLegacyStates(states map[string]*legacyState, currentStates map[string]bool, report func(format string, v ...interface{})) error {
+	for _, component := range Components {
+		source, ok := states[component.Name]
+		if !ok {
+			continue
+		}
+		sourceModule := rootModule(source.state)
+		for _, section := range []string{"resources", "outputs"} {
+			entries, _ := sourceModule[section].(map[string]interface{})
+			for _, address := range sortedKeys(entries) {
+				target := legacyAddressComponent(address)
+				if target == "" || target == component.Name {
+					continue
+				}
+				if currentStates[target] {
+					return util.NewError(util.ConfigError, "%s in the terraform state of %s belongs to %s, which already has terraform 0.12 state. Move it with terraform state mv",
+						address, component.Name, target)
+				}
+				destination, ok := states[target]
+				if !ok {
+					destination = &legacyState{state: newLegacyState(source.state)}
+					states[target] = destination
+				}
+				destinationEntries := moduleSection(rootModule(destination.state), section)
+				if _, exists := destinationEntries[address]; exists {
+					return util.NewError(util.ConfigError, "%s is in the terraform state of both %s and %s", address, component.Name, target)
+				}
+				report("Moving %s from the terraform state of %s to %s", address, component.Name, target)
+				destinationEntries[address] = entries[address]
+				delete(entries, address)
+				source.changed = true
+				destination.changed = true
+			}
+		}
+	}
+
+	// Dependencies on resources now in another state are looked up through remote state instead
+	for _, legacy := range states {
+		if legacy.changed {
+			pruneDependencies(rootModule(legacy.state))
+		}
+	}
+	return nil
+}
+
+func legacyAddressComponent(address string) string {
+	for _, legacyAddress := range legacyComponentAddresses {
+		if legacyAddress.pattern.MatchString(address) {
+			return legacyAddress.component
+		}
+	}
+	return ""
+}
+
+// newLegacyState starts an empty state for a component, written by the same terraform version as the state its
+// resources are moved from. Terraform refuses to mix states of different lineages so it gets a lineage of its own.
+func newLegacyState(from map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"version":           json.Number(strconv.Itoa(legacyStateVersion)),
+		"terraform_version": from["terraform_version"],
+		"serial":            json.Number("0"),
+		"lineage":           newLineage(),
+		"modules": []interface{}{
+			map[string]interface{}{
+				"path":       []interface{}{"root"},
+				"outputs":    map[string]interface{}{},
+				"resources":  map[string]interface{}{},
+				"depends_on": []interface{}{},
+			},
+		},
+	}
+}
+
+// newLineage is a random version 4 UUID, the format terraform uses for lineages.
+func newLineage() string {
+	lineage := make([]byte, 16)
+	if _, err := rand.Read(lineage); err != nil {
+		panic(err)
+	}
+	lineage[6] = lineage[6]&0x0f | 0x40
+	lineage[8] = lineage[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", lineage[0:4], lineage[4:6], lineage[6:8], lineage[8:10], lineage[10:])
+}
+
+func rootModule(state map[string]interface{}) map[string]interface{} {
+	modules, _ := state["modules"].([]interface{})
+	for _, module := range modules {
+		moduleState, _ := module.(map[string]interface{})
+		path, _ := moduleState["path"].([]interface{})
+		if len(path) == 1 && path[0] == "root" {
+			return moduleState
+		}
+	}
+	moduleState := map[string]interface{}{"path": []interface{}{"root"}}
+	state["modules"] = append(modules, moduleState)
+	return moduleState
+}
+
+func moduleSection(moduleState map[string]interface{}, section string) map[string]interface{} {
+	entries, ok := moduleState[section].(map[string]interface{})
+	if !ok {
+		entries = make(map[string]interface{})
+		moduleState[section] = entries
+	}
+	return entries
+}
+
+// pruneDependencies drops depends_on entries naming resources that are no longer in the module. Counted
+// resources are kept under their index and referred to with a trailing .*.
+func pruneDependencies(moduleState map[string]interface{}) {
+	resources, _ := moduleState["resources"].(map[string]interface{})
+	inModule := func(address string) bool {
+		address = strings.TrimSuffix(address, ".*")
+		for resourceAddress := range resources {
+			if resourceAddress == address || strings.HasPrefix(resourceAddress, address+".") {
+				return true
+			}
+		}
+		return false
+	}
+	for _, resource := range resources {
+		resourceState, _ := resource.(map[string]interface{})
+		dependencies, ok := resourceState["depends_on"].([]interface{})
+		if !ok {
+			continue
+		}
+		kept := []interface{}{}
+		for _, dependency := range dependencies {
+			if dependencyAddress, _ := dependency.(string); inModule(dependencyAddress) {
+				kept = append(kept, dependency)
+			}
+		}
+		resourceState["depends_on"] = kept
+	}
+}
+
+func sortedKeys(entries map[string]interface{}) []string {
+	var keys []string
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Version 3 state keeps the resources of each module in a map keyed by address, which other resources refer to
+// in their depends_on.
+func renameLegacyResources(state map[string]interface{}, renames map[string]string, report func(format string, v ...interface{})) bool {
+	renamed := false
+	modules, _ := state["modules"].([]interface{})
+	for _, module := range modules {
+		moduleState, _ := module.(map[string]interface{})
+		resources, _ := moduleState["resources"].(map[string]interface{})
+		for oldAddress, newAddress := range renames {
+			if resource, ok := resources[oldAddress]; ok {
+				report("Renaming %s to %s in terraform state", oldAddress, newAddress)
+				resources[newAddress] = resource
+				delete(resources, oldAddress)
+				renamed = true
+			}
+		}
+		for _, resource := range resources {
+			resourceState, _ := resource.(map[string]interface{})
+			dependencies, _ := resourceState["depends_on"].([]interface{})
+			for i, dependency := range dependencies {
+				dependencyAddress, _ := dependency.(string)
+				if newAddress, ok := renames[dependencyAddress]; ok {
+					dependencies[i] = newAddress
+				}
+			}
+		}
+	}
+	return renamed
+}
+
+func stateNumber(state map[string]interface{}, key string) int64 {
+	number, _ := state[key].(json.Number)
+	value, _ := number.Int64()
+	return value
+}
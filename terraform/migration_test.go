@@ -0,0 +1,293 @@
+package terraform
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func memoryBucket(objects map[string][]byte) stateBucket {
+	return stateBucket{
+		name: "config",
+		fetch: func(key string) ([]byte, bool, error) {
+			content, ok := objects[key]
+			return content, ok, nil
+		},
+		put: func(key string, content []byte) error {
+			objects[key] = content
+			return nil
+		},
+		report: log.Printf,
+	}
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	content, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func decodeState(t *testing.T, stateBytes []byte) interface{} {
+	t.Helper()
+	var state interface{}
+	decoder := json.NewDecoder(bytes.NewReader(stateBytes))
+	decoder.UseNumber()
+	if err := decoder.Decode(&state); err != nil {
+		t.Fatalf("invalid state %s: %v", stateBytes, err)
+	}
+	return state
+}
+
+func objectKeys(objects map[string][]byte) []string {
+	var keys []string
+	for key := range objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestMigrateLegacyStateRenamesResources(t *testing.T) {
+	legacyState := readFixture(t, "network-0.11.tfstate")
+	objects := map[string][]byte{StateKey(ComponentNetwork): legacyState}
+
+	if err := migrateLegacyState(memoryBucket(objects)); err != nil {
+		t.Fatal(err)
+	}
+
+	if backup := objects[StateKey(ComponentNetwork)+".0.11.backup"]; !bytes.Equal(backup, legacyState) {
+		t.Errorf("expected the original state to be backed up unchanged, got %s", backup)
+	}
+	migratedState := objects[StateKey(ComponentNetwork)]
+	if want, got := decodeState(t, readFixture(t, "network-0.11-migrated.tfstate")), decodeState(t, migratedState); !reflect.DeepEqual(want, got) {
+		t.Errorf("expected migrated state\n%s\ngot\n%s", readFixture(t, "network-0.11-migrated.tfstate"), migratedState)
+	}
+	if !bytes.Contains(migratedState, []byte(`"create": 120000000000`)) {
+		t.Errorf("expected numbers in the state to be kept as they were, got %s", migratedState)
+	}
+}
+
+func TestMigrateLegacyStateKeepsExistingBackup(t *testing.T) {
+	backupKey := StateKey(ComponentNetwork) + ".0.11.backup"
+	objects := map[string][]byte{
+		StateKey(ComponentNetwork): readFixture(t, "network-0.11.tfstate"),
+		backupKey:                  []byte("earlier backup"),
+	}
+
+	if err := migrateLegacyState(memoryBucket(objects)); err != nil {
+		t.Fatal(err)
+	}
+
+	if backup := string(objects[backupKey]); backup != "earlier backup" {
+		t.Errorf("expected the earlier backup to be kept, got %s", backup)
+	}
+}
+
+func TestMigrateLegacyStateWithoutRenames(t *testing.T) {
+	legacyState := []byte(`{"version": 3, "serial": 2, "lineage": "l", "modules": [{"path": ["root"], "resources": {}}]}`)
+	objects := map[string][]byte{StateKey(ComponentDatabase): legacyState}
+
+	if err := migrateLegacyState(memoryBucket(objects)); err != nil {
+		t.Fatal(err)
+	}
+
+	if state := objects[StateKey(ComponentDatabase)]; !bytes.Equal(state, legacyState) {
+		t.Errorf("expected state without renamed resources to be left alone, got %s", state)
+	}
+	if want := []string{StateKey(ComponentDatabase), StateKey(ComponentDatabase) + ".0.11.backup"}; !reflect.DeepEqual(objectKeys(objects), want) {
+		t.Errorf("expected objects %v, got %v", want, objectKeys(objects))
+	}
+}
+
+func TestMigrateLegacyStateIgnoresCurrentState(t *testing.T) {
+	currentState := []byte(`{"version": 4, "terraform_version": "0.12.31", "serial": 9, "lineage": "l", "resources": []}`)
+	objects := map[string][]byte{StateKey(ComponentNetwork): currentState}
+
+	if err := migrateLegacyState(memoryBucket(objects)); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{StateKey(ComponentNetwork)}; !reflect.DeepEqual(objectKeys(objects), want) {
+		t.Errorf("expected objects %v, got %v", want, objectKeys(objects))
+	}
+	if state := objects[StateKey(ComponentNetwork)]; !bytes.Equal(state, currentState) {
+		t.Errorf("expected version 4 state to be left alone, got %s", state)
+	}
+}
+
+func stateModule(t *testing.T, stateBytes []byte) (map[string]interface{}, map[string]interface{}) {
+	t.Helper()
+	state := decodeState(t, stateBytes).(map[string]interface{})
+	return state, state["modules"].([]interface{})[0].(map[string]interface{})
+}
+
+func sectionKeys(module map[string]interface{}, section string) []string {
+	var keys []string
+	for key := range module[section].(map[string]interface{}) {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestMigrateLegacyStateSplitsSharedState(t *testing.T) {
+	sharedState := readFixture(t, "combined-0.11.tfstate")
+	objects := map[string][]byte{StateKey(ComponentNetwork): sharedState}
+
+	if err := migrateLegacyState(memoryBucket(objects)); err != nil {
+		t.Fatal(err)
+	}
+
+	if backup := objects[StateKey(ComponentNetwork)+".0.11.backup"]; !bytes.Equal(backup, sharedState) {
+		t.Errorf("expected the shared state to be backed up unchanged, got %s", backup)
+	}
+	want := []string{
+		StateKey(ComponentDatabase),
+		StateKey(ComponentElasticSearch),
+		StateKey(ComponentNetwork),
+		StateKey(ComponentNetwork) + ".0.11.backup",
+	}
+	if !reflect.DeepEqual(objectKeys(objects), want) {
+		t.Errorf("expected objects %v, got %v", want, objectKeys(objects))
+	}
+
+	tests := []struct {
+		component     string
+		wantResources []string
+		wantOutputs   []string
+		wantSerial    string
+		wantDependsOn map[string][]interface{}
+		sharesLineage bool
+	}{
+		{
+			component:     ComponentNetwork,
+			wantResources: []string{"aws_security_group.k8s-nodes-dev", "aws_vpc.dev", "data.aws_caller_identity.current"},
+			wantOutputs:   []string{"vpc_id"},
+			wantSerial:    "13",
+			wantDependsOn: map[string][]interface{}{"aws_security_group.k8s-nodes-dev": {"aws_vpc.dev"}},
+			sharesLineage: true,
+		},
+		{
+			component: ComponentDatabase,
+			wantResources: []string{"aws_db_instance.dev-orders", "aws_db_parameter_group.dev-orders", "aws_db_subnet_group.dev-orders",
+				"aws_security_group.dev-orders-database-access"},
+			wantOutputs: []string{"database_output_orders"},
+			wantSerial:  "1",
+			wantDependsOn: map[string][]interface{}{
+				"aws_db_instance.dev-orders": {"aws_db_parameter_group.dev-orders", "aws_db_subnet_group.dev-orders",
+					"aws_security_group.dev-orders-database-access"},
+				"aws_db_subnet_group.dev-orders":                {},
+				"aws_security_group.dev-orders-database-access": {},
+			},
+		},
+		{
+			component:     ComponentElasticSearch,
+			wantResources: []string{"aws_elasticsearch_domain.dev-logs", "aws_security_group.dev-logs-elasticsearch"},
+			wantOutputs:   []string{"elasticsearch_output_logs"},
+			wantSerial:    "1",
+			wantDependsOn: map[string][]interface{}{
+				"aws_elasticsearch_domain.dev-logs": {"aws_security_group.dev-logs-elasticsearch"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.component, func(t *testing.T) {
+			state, module := stateModule(t, objects[StateKey(test.component)])
+			if version := state["version"].(json.Number).String(); version != "3" {
+				t.Errorf("expected version 3 state for terraform to upgrade, got %s", version)
+			}
+			if serial := state["serial"].(json.Number).String(); serial != test.wantSerial {
+				t.Errorf("expected serial %s, got %s", test.wantSerial, serial)
+			}
+			if sharesLineage := state["lineage"] == "7c0e2a9b-3f41-4d6a-b2c8-5e9d1a7f4b20"; sharesLineage != test.sharesLineage {
+				t.Errorf("expected lineage of the shared state to be kept only by the state it was in, got %v", state["lineage"])
+			}
+			if resources := sectionKeys(module, "resources"); !reflect.DeepEqual(resources, test.wantResources) {
+				t.Errorf("expected resources %v, got %v", test.wantResources, resources)
+			}
+			if outputs := sectionKeys(module, "outputs"); !reflect.DeepEqual(outputs, test.wantOutputs) {
+				t.Errorf("expected outputs %v, got %v", test.wantOutputs, outputs)
+			}
+			resources := module["resources"].(map[string]interface{})
+			for address, wantDependsOn := range test.wantDependsOn {
+				dependsOn := resources[address].(map[string]interface{})["depends_on"]
+				if !reflect.DeepEqual(dependsOn, wantDependsOn) {
+					t.Errorf("expected %s to depend on %v, got %v", address, wantDependsOn, dependsOn)
+				}
+			}
+		})
+	}
+}
+
+func TestMigrateLegacyStateRefusesToMoveIntoCurrentState(t *testing.T) {
+	currentState := []byte(`{"version": 4, "terraform_version": "0.12.31", "serial": 3, "lineage": "l", "resources": []}`)
+	objects := map[string][]byte{
+		StateKey(ComponentNetwork):  readFixture(t, "combined-0.11.tfstate"),
+		StateKey(ComponentDatabase): currentState,
+	}
+
+	err := migrateLegacyState(memoryBucket(objects))
+	if err == nil || !strings.Contains(err.Error(), "aws_db_instance.dev-orders in the terraform state of network belongs to database, which already has terraform 0.12 state") {
+		t.Fatalf("expected an error about the database state, got %v", err)
+	}
+	if state := objects[StateKey(ComponentDatabase)]; !bytes.Equal(state, currentState) {
+		t.Errorf("expected the database state to be left alone, got %s", state)
+	}
+	if state := objects[StateKey(ComponentNetwork)]; !bytes.Equal(state, readFixture(t, "combined-0.11.tfstate")) {
+		t.Errorf("expected the shared state to be left alone, got %s", state)
+	}
+}
+
+func TestPendingStateMigrationWritesNothing(t *testing.T) {
+	sharedState := readFixture(t, "combined-0.11.tfstate")
+	objects := map[string][]byte{StateKey(ComponentNetwork): sharedState}
+
+	pending, err := pendingStateMigration(memoryBucket(objects))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{StateKey(ComponentNetwork)}; !reflect.DeepEqual(objectKeys(objects), want) {
+		t.Errorf("expected objects %v, got %v", want, objectKeys(objects))
+	}
+	if state := objects[StateKey(ComponentNetwork)]; !bytes.Equal(state, sharedState) {
+		t.Errorf("expected the shared state to be left alone, got %s", state)
+	}
+	want := []string{
+		"Terraform state for network was written by terraform 0.11, keeping a copy in s3://config/terraform/network/terraform.tfstate.0.11.backup",
+		"Moving aws_db_instance.dev-orders from the terraform state of network to database",
+		"Moving aws_db_parameter_group.dev-orders from the terraform state of network to database",
+		"Moving aws_db_subnet_group.dev-orders from the terraform state of network to database",
+		"Moving aws_elasticsearch_domain.dev-logs from the terraform state of network to elasticsearch",
+		"Moving aws_security_group.dev-logs-elasticsearch from the terraform state of network to elasticsearch",
+		"Moving aws_security_group.dev-orders-database-access from the terraform state of network to database",
+		"Moving database_output_orders from the terraform state of network to database",
+		"Moving elasticsearch_output_logs from the terraform state of network to elasticsearch",
+	}
+	if !reflect.DeepEqual(pending, want) {
+		t.Errorf("expected pending steps\n%q\ngot\n%q", want, pending)
+	}
+}
+
+func TestPendingStateMigrationOfCurrentState(t *testing.T) {
+	objects := map[string][]byte{
+		StateKey(ComponentNetwork): []byte(`{"version": 4, "terraform_version": "0.12.31", "serial": 9, "lineage": "l", "resources": []}`),
+	}
+
+	pending, err := pendingStateMigration(memoryBucket(objects))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("expected nothing pending, got %q", pending)
+	}
+}
@@ -1,14 +1,19 @@
 package terraform
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"github.com/infinityworks/fk-infra/executable"
+	"github.com/infinityworks/fk-infra/model"
 	"github.com/infinityworks/fk-infra/util"
 	"github.com/mholt/archiver"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 )
 
@@ -16,23 +21,32 @@ type Output struct {
 	Value string `json:"value"`
 }
 
+type ListOutput struct {
+	Value []string `json:"value"`
+}
+
 type Outputs struct {
-	VpcId                 Output `json:"vpc_id"`
-	VpcCidr               Output `json:"vpc_cidr_block"`
-	SubnetA               Output `json:"subnet_a"`
-	SubnetB               Output `json:"subnet_b"`
-	UtilitySubnetA        Output `json:"subnet_utility-a"`
-	UtilitySubnetB        Output `json:"subnet_utility-b"`
-	MasterSecurityGroupId Output `json:"master_security_group_id"`
-	WorkerSecurityGroupId Output `json:"worker_security_group_id"`
+	VpcId                 Output     `json:"vpc_id"`
+	VpcCidr               Output     `json:"vpc_cidr_block"`
+	PrivateSubnetIds      ListOutput `json:"private_subnet_ids"`
+	UtilitySubnetIds      ListOutput `json:"utility_subnet_ids"`
+	MasterSecurityGroupId Output     `json:"master_security_group_id"`
+	WorkerSecurityGroupId Output     `json:"worker_security_group_id"`
 
-	outputBytes []byte
+	values map[string]json.RawMessage
+	// components names the component each output belongs to
+	components map[string]string
 }
 
 type DatabaseOutput struct {
 	Name     string `json:"name"`
+	Engine   string `json:"engine"`
+	Port     string `json:"port"`
 	Endpoint string `json:"endpoint"`
-	Password string `json:"password"`
+	// PasswordSecret names the Secrets Manager secret holding the password
+	PasswordSecret string `json:"password_secret"`
+	// LegacyPassword is only set by databases applied before passwords moved to Secrets Manager
+	LegacyPassword string `json:"password,omitempty"`
 }
 
 type ElasticSearchOutput struct {
@@ -41,81 +55,531 @@ type ElasticSearchOutput struct {
 	Arn      string `json:"arn"`
 }
 
+type QueueOutput struct {
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	Url           string `json:"url"`
+	Arn           string `json:"arn"`
+	DeadLetterUrl string `json:"dead_letter_url"`
+	DeadLetterArn string `json:"dead_letter_arn"`
+}
+
+// PrivateSubnets are ordered by the availability zones the network was rendered with.
 func (outputs Outputs) PrivateSubnets() []string {
-	return []string{outputs.SubnetA.Value, outputs.SubnetB.Value}
+	return outputs.PrivateSubnetIds.Value
 }
 
+// UtilitySubnets are ordered by the availability zones the network was rendered with.
 func (outputs Outputs) UtilitySubnets() []string {
-	return []string{outputs.UtilitySubnetA.Value, outputs.UtilitySubnetB.Value}
+	return outputs.UtilitySubnetIds.Value
+}
+
+const (
+	databaseOutputPrefix      = "database_output_"
+	elasticSearchOutputPrefix = "elasticsearch_output_"
+	queueOutputPrefix         = "queue_output_"
+
+	// Databases applied before their engine could be chosen were always MySQL on its default port, their
+	// outputs only hold the name, endpoint and password.
+	legacyDatabaseEngine = "mysql"
+	legacyDatabasePort   = "3306"
+)
+
+func DatabaseOutputName(name string) string {
+	return databaseOutputPrefix + name
+}
+
+func ElasticSearchOutputName(name string) string {
+	return elasticSearchOutputPrefix + name
+}
+
+func QueueOutputName(name string) string {
+	return queueOutputPrefix + name
+}
+
+func (output *DatabaseOutput) fillLegacyFields() {
+	if output.PasswordSecret != "" || output.LegacyPassword == "" {
+		return
+	}
+	if output.Engine == "" {
+		output.Engine = legacyDatabaseEngine
+	}
+	if output.Port == "" {
+		output.Port = legacyDatabasePort
+	}
 }
 
-func (outputs Outputs) DatabaseConfig() []DatabaseOutput {
-	outputMap := make(map[string]interface{})
-	util.CheckError(json.Unmarshal(outputs.outputBytes, &outputMap))
+func (output DatabaseOutput) validate() error {
+	fields := map[string]string{"name": output.Name, "engine": output.Engine, "port": output.Port, "endpoint": output.Endpoint}
+	if output.LegacyPassword == "" {
+		fields["password_secret"] = output.PasswordSecret
+	}
+	return requireFields(fields)
+}
+
+func (output ElasticSearchOutput) validate() error {
+	return requireFields(map[string]string{"name": output.Name, "endpoint": output.Endpoint, "arn": output.Arn})
+}
+
+func (output QueueOutput) validate() error {
+	fields := map[string]string{"name": output.Name, "type": output.Type, "arn": output.Arn}
+	switch output.Type {
+	case model.QueueTypeSqs:
+		fields["url"], fields["dead_letter_url"], fields["dead_letter_arn"] = output.Url, output.DeadLetterUrl, output.DeadLetterArn
+	case model.QueueTypeSns:
+	default:
+		return fmt.Errorf("unknown queue type %q", output.Type)
+	}
+	return requireFields(fields)
+}
+
+func (outputs Outputs) DatabaseConfig() ([]DatabaseOutput, error) {
 	var databaseOutputs []DatabaseOutput
-	for key, val := range outputMap {
-		if strings.HasPrefix(key, "database_output_") {
-			var output DatabaseOutput
-			embeddedJson := []byte(val.(map[string]interface{})["value"].(string))
-			util.CheckError(json.Unmarshal(embeddedJson, &output))
-			databaseOutputs = append(databaseOutputs, output)
+	err := outputs.objectOutputs(databaseOutputPrefix, func(value []byte) error {
+		var output DatabaseOutput
+		if err := json.Unmarshal(value, &output); err != nil {
+			return err
 		}
-	}
-	return databaseOutputs
+		output.fillLegacyFields()
+		databaseOutputs = append(databaseOutputs, output)
+		return output.validate()
+	})
+	return databaseOutputs, err
 }
 
-func (outputs Outputs) ElasticSearchConfig() []ElasticSearchOutput {
-	outputMap := make(map[string]interface{})
-	util.CheckError(json.Unmarshal(outputs.outputBytes, &outputMap))
+func (outputs Outputs) ElasticSearchConfig() ([]ElasticSearchOutput, error) {
 	var elasticSearchOutputs []ElasticSearchOutput
-	for key, val := range outputMap {
-		if strings.HasPrefix(key, "elasticsearch_output_") {
-			var output ElasticSearchOutput
-			embeddedJson := []byte(val.(map[string]interface{})["value"].(string))
-			util.CheckError(json.Unmarshal(embeddedJson, &output))
-			elasticSearchOutputs = append(elasticSearchOutputs, output)
+	err := outputs.objectOutputs(elasticSearchOutputPrefix, func(value []byte) error {
+		var output ElasticSearchOutput
+		if err := json.Unmarshal(value, &output); err != nil {
+			return err
+		}
+		elasticSearchOutputs = append(elasticSearchOutputs, output)
+		return output.validate()
+	})
+	return elasticSearchOutputs, err
+}
+
+func (outputs Outputs) QueueConfig() ([]QueueOutput, error) {
+	var queueOutputs []QueueOutput
+	err := outputs.objectOutputs(queueOutputPrefix, func(value []byte) error {
+		var output QueueOutput
+		if err := json.Unmarshal(value, &output); err != nil {
+			return err
+		}
+		queueOutputs = append(queueOutputs, output)
+		return output.validate()
+	})
+	return queueOutputs, err
+}
+
+// ElasticSearch looks up the outputs of a single domain, which are missing until its component has been applied.
+func (outputs Outputs) ElasticSearch(name string) (ElasticSearchOutput, error) {
+	var elasticSearchOutput ElasticSearchOutput
+	outputName := ElasticSearchOutputName(name)
+	if _, ok := outputs.values[outputName]; !ok {
+		return elasticSearchOutput, util.NewError(util.ExecutionError, "terraform output %s is missing from component %s, it may not have been applied yet", outputName, ComponentElasticSearch)
+	}
+	value, err := outputs.objectOutput(outputName)
+	if err != nil {
+		return elasticSearchOutput, err
+	}
+	if err := json.Unmarshal(value, &elasticSearchOutput); err == nil {
+		err = elasticSearchOutput.validate()
+	}
+	return elasticSearchOutput, outputs.malformed(err, outputName)
+}
+
+// objectOutputs decodes the value of every output whose name starts with prefix. Outputs applied before they
+// were declared as objects hold the object encoded as a JSON string, which is decoded the same way.
+func (outputs Outputs) objectOutputs(prefix string, decode func(value []byte) error) error {
+	var names []string
+	for name := range outputs.values {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value, err := outputs.objectOutput(name)
+		if err != nil {
+			return err
+		}
+		if err := outputs.malformed(decode(value), name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (outputs Outputs) objectOutput(name string) ([]byte, error) {
+	var output struct {
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(outputs.values[name], &output); err != nil {
+		return nil, outputs.malformed(err, name)
+	}
+	var legacyValue string
+	if err := json.Unmarshal(output.Value, &legacyValue); err == nil {
+		return []byte(legacyValue), nil
+	}
+	return output.Value, nil
+}
+
+func (outputs Outputs) malformed(err error, name string) error {
+	if err == nil {
+		return nil
+	}
+	return util.WrapError(util.ExecutionError, err, "terraform output %s of component %s is malformed", name, outputs.components[name])
+}
+
+func requireFields(fields map[string]string) error {
+	var missing []string
+	for name, value := range fields {
+		if value == "" {
+			missing = append(missing, name)
 		}
 	}
-	return elasticSearchOutputs
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	return fmt.Errorf("missing %s", strings.Join(missing, ", "))
 }
 
-func PlanAndApply(approved bool) {
-	ExecuteTerraform("init")
-	ExecuteTerraform("plan")
-	if approved {
-		ExecuteTerraform("apply", "-auto-approve")
+func PlanAndApply(approved bool) error {
+	for _, component := range Components {
+		if applied, err := dependenciesApplied(component); err != nil {
+			return err
+		} else if !applied {
+			continue
+		}
+		if err := initTerraform(component.Name); err != nil {
+			return err
+		}
+		if _, err := executeInComponent(component.Name, false, "plan"); err != nil {
+			return err
+		}
+		if approved {
+			if _, err := executeInComponent(component.Name, false, "apply", "-auto-approve"); err != nil {
+				return err
+			}
+		}
 	}
+	return nil
 }
 
-func ExecuteTerraform(args ...string) []byte {
-	return executable.CacheOrDownload(".fk-infra/terraform",
-		func() string {
-			downloadUrl := fmt.Sprintf("https://releases.hashicorp.com/terraform/0.11.11/terraform_0.11.11_%s_%s.zip", runtime.GOOS, runtime.GOARCH)
-			log.Printf("Downloading terraform from %s", downloadUrl)
-			return downloadUrl
-		},
-		func(tempBinaryLocation string) {
-			util.CheckError(archiver.NewZip().Unarchive(tempBinaryLocation, ".fk-infra"))
-			util.CheckError(os.Remove(tempBinaryLocation))
-		},
-		args...)
+// Plan saves a plan per component alongside planFile so exactly those changes can be applied later, returning
+// the plan of each component in the JSON format of terraform show. Components waiting on a dependency to be
+// applied are left out.
+func Plan(planFile string) (map[string][]byte, error) {
+	plans := make(map[string][]byte)
+	for _, component := range Components {
+		if applied, err := dependenciesApplied(component); err != nil {
+			return nil, err
+		} else if !applied {
+			continue
+		}
+		if err := initTerraform(component.Name); err != nil {
+			return nil, err
+		}
+		componentPlanFile, err := filepath.Abs(ComponentPlanFile(planFile, component.Name))
+		if err != nil {
+			return nil, util.WrapError(util.ConfigError, err, "unable to find %s", planFile)
+		}
+		if _, err := executeInComponent(component.Name, false, "plan", fmt.Sprintf("-out=%s", componentPlanFile)); err != nil {
+			return nil, err
+		}
+		if plans[component.Name], err = executeInComponent(component.Name, true, "show", "-json", componentPlanFile); err != nil {
+			return nil, err
+		}
+	}
+	return plans, nil
 }
 
-func fetchOutputsBytes() (output []byte) {
-	defer func() {
-		if recover() != nil {
-			log.Println("Unable to fetch terraform outputs")
-			output = []byte("{}")
+// ApplyPlan refuses to apply the plan of a component made against a different version of its state than the
+// current one. Components that weren't planned are skipped.
+func ApplyPlan(planFile string, plannedStateVersions map[string]StateVersion) error {
+	for _, component := range Components {
+		plannedStateVersion, planned := plannedStateVersions[component.Name]
+		if !planned {
+			log.Printf("Terraform for %s was not planned, run plan again to include it", component.Name)
+			continue
+		}
+		if err := initTerraform(component.Name); err != nil {
+			return err
+		}
+		currentStateVersion, err := FetchStateVersion(component.Name)
+		if err != nil {
+			return err
+		}
+		if currentStateVersion != plannedStateVersion {
+			return util.NewError(util.ConfigError, "terraform state for %s has changed since %s was planned, expected %+v but found %+v", component.Name, planFile, plannedStateVersion, currentStateVersion)
+		}
+		componentPlanFile, err := filepath.Abs(ComponentPlanFile(planFile, component.Name))
+		if err != nil {
+			return util.WrapError(util.ConfigError, err, "unable to find %s", planFile)
 		}
-	}()
+		if _, err := executeInComponent(component.Name, false, "apply", componentPlanFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	return ExecuteTerraform("output", "-json")
+// StateVersion identifies the terraform state a plan was made against, the serial increases on every write.
+type StateVersion struct {
+	Lineage string `json:"lineage"`
+	Serial  int64  `json:"serial"`
 }
 
-func FetchTerraformOutputs() Outputs {
-	var terraformOutputs Outputs
-	outputBytes := fetchOutputsBytes()
-	util.CheckError(json.Unmarshal(outputBytes, &terraformOutputs))
-	terraformOutputs.outputBytes = outputBytes
-	return terraformOutputs
+// ShowPlan returns the JSON terraform show writes for the plan saved for a component, found is false when the
+// component wasn't planned.
+func ShowPlan(planFile, component string) ([]byte, bool, error) {
+	componentPlanFile, err := filepath.Abs(ComponentPlanFile(planFile, component))
+	if err != nil {
+		return nil, false, util.WrapError(util.ConfigError, err, "unable to find %s", planFile)
+	}
+	if _, err := os.Stat(componentPlanFile); os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err := initTerraform(component); err != nil {
+		return nil, false, err
+	}
+	plan, err := executeInComponent(component, true, "show", "-json", componentPlanFile)
+	return plan, err == nil, err
+}
+
+func FetchStateVersion(component string) (StateVersion, error) {
+	var stateVersion StateVersion
+	stateBytes, err := executeInComponent(component, true, "state", "pull")
+	if err != nil {
+		return stateVersion, err
+	}
+	if len(bytes.TrimSpace(stateBytes)) > 0 {
+		err = util.WrapError(util.ExecutionError, json.Unmarshal(stateBytes, &stateVersion), "unable to read terraform state for %s", component)
+	}
+	return stateVersion, err
+}
+
+// PlanAndDestroy works backwards through the components so nothing is destroyed while another still uses it.
+func PlanAndDestroy(approved bool) error {
+	for i := len(Components) - 1; i >= 0; i-- {
+		component := Components[i]
+		if err := initTerraform(component.Name); err != nil {
+			return err
+		}
+		if _, err := executeInComponent(component.Name, false, "plan", "-destroy"); err != nil {
+			return err
+		}
+		if approved {
+			if _, err := executeInComponent(component.Name, false, "destroy", "-auto-approve"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// A component reading the remote state of another can't be planned until that one has outputs to read.
+func dependenciesApplied(component Component) (bool, error) {
+	for _, dependency := range component.DependsOn {
+		outputs, err := componentOutputs(dependency)
+		if err != nil {
+			return false, err
+		}
+		if len(outputs) == 0 {
+			log.Printf("Skipping terraform for %s until %s has been applied", component.Name, dependency)
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+const pluginMirrorDirectory = "terraform-plugins"
+
+// RequiredVersion is the terraform version the rendered configuration is written for.
+const RequiredVersion = ">= 0.12"
+
+// Downloads for platforms without a pinned checksum are refused rather than run unverified, every platform in
+// executable.SupportedPlatforms needs its entry from the terraform_<version>_SHA256SUMS of the release.
+var terraformTool = executable.Tool{
+	Name:    "terraform",
+	Version: "0.12.31",
+	Filename: func(version, goos, goarch string) string {
+		return fmt.Sprintf("terraform_%s_%s_%s.zip", version, goos, goarch)
+	},
+	DownloadUrl: func(version, filename string) string {
+		return fmt.Sprintf("https://releases.hashicorp.com/terraform/%s/%s", version, filename)
+	},
+	Checksums:   map[string]string{},
+	VersionArgs: []string{"version"},
+	Install: func(downloadLocation, binaryLocation string) error {
+		if err := archiver.NewZip().Unarchive(downloadLocation, filepath.Dir(binaryLocation)); err != nil {
+			return err
+		}
+		return os.Remove(downloadLocation)
+	},
+}
+
+// componentVariables are given to terraform through the environment, so values such as passwords are only ever
+// written to the plan file.
+var componentVariables = make(map[string]map[string]string)
+
+// SetVariable gives a variable declared by a component its value for every terraform command run in it.
+func SetVariable(component, name, value string) {
+	if componentVariables[component] == nil {
+		componentVariables[component] = make(map[string]string)
+	}
+	componentVariables[component][name] = value
+}
+
+func executeInComponent(component string, quiet bool, args ...string) ([]byte, error) {
+	var environment []string
+	for name, value := range componentVariables[component] {
+		environment = append(environment, fmt.Sprintf("TF_VAR_%s=%s", name, value))
+	}
+	return executable.CacheOrDownloadWithInvocation(terraformTool, executable.Invocation{
+		Directory:   ComponentDirectory(component),
+		Quiet:       quiet,
+		Environment: environment,
+	}, args...)
+}
+
+// Terraform can't download providers through a mirror itself, so with a mirror configured init is pointed at a
+// directory of plugins laid out as terraform-plugins/<os>_<arch> within it.
+func initTerraform(component string) error {
+	args := []string{"init"}
+	if executable.Mirror() != "" {
+		pluginDirectory, err := mirrorPlugins()
+		if err != nil {
+			return err
+		}
+		// init runs in the component directory
+		if pluginDirectory, err = filepath.Abs(pluginDirectory); err != nil {
+			return util.WrapError(util.ConfigError, err, "unable to find terraform plugins")
+		}
+		args = append(args, fmt.Sprintf("-plugin-dir=%s", pluginDirectory))
+	}
+	_, err := executeInComponent(component, false, args...)
+	return err
+}
+
+// HTTP mirrors can't be listed so they publish a SHA256SUMS file naming each plugin along with its checksum,
+// the plugins are fetched into the tool cache and verified against it.
+func mirrorPlugins() (string, error) {
+	platform := fmt.Sprintf("%s_%s", runtime.GOOS, runtime.GOARCH)
+	if !executable.MirrorIsUrl() {
+		return executable.MirrorLocation(pluginMirrorDirectory, platform), nil
+	}
+
+	pluginDirectory := filepath.Join(".fk-infra", pluginMirrorDirectory, platform)
+	if err := os.MkdirAll(pluginDirectory, 0750); err != nil {
+		return "", util.WrapError(util.ExecutionError, err, "unable to create %s", pluginDirectory)
+	}
+
+	sumsLocation := filepath.Join(pluginDirectory, "SHA256SUMS")
+	if _, err := executable.Fetch(executable.MirrorLocation(pluginMirrorDirectory, platform, "SHA256SUMS"), sumsLocation); err != nil {
+		return "", err
+	}
+	sums, err := ioutil.ReadFile(sumsLocation)
+	if err != nil {
+		return "", util.WrapError(util.ExecutionError, err, "unable to read %s", sumsLocation)
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(sums)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return "", util.NewError(util.ExecutionError, "malformed line in plugin mirror SHA256SUMS: %q", line)
+		}
+		expectedChecksum, pluginName := fields[0], filepath.Base(fields[1])
+		pluginLocation := filepath.Join(pluginDirectory, pluginName)
+		if checksum, err := executable.FileChecksum(pluginLocation); err == nil && checksum == expectedChecksum {
+			continue
+		}
+
+		log.Printf("Downloading terraform plugin %s", pluginName)
+		checksum, err := executable.Fetch(executable.MirrorLocation(pluginMirrorDirectory, platform, pluginName), pluginLocation)
+		if err != nil {
+			return "", err
+		}
+		if checksum != expectedChecksum {
+			os.Remove(pluginLocation)
+			return "", util.NewError(util.ExecutionError, "terraform plugin %s has checksum %s, expected %s", pluginName, checksum, expectedChecksum)
+		}
+		if err := os.Chmod(pluginLocation, 0750); err != nil {
+			return "", util.WrapError(util.ExecutionError, err, "unable to make %s executable", pluginLocation)
+		}
+	}
+	return pluginDirectory, nil
+}
+
+// A component that has never been applied has no state, terraform output refuses to run against it so the
+// state is checked first. Any other failure is returned rather than read as the component not being applied.
+func componentOutputs(component string) (map[string]json.RawMessage, error) {
+	outputs := make(map[string]json.RawMessage)
+	stateBytes, err := executeInComponent(component, true, "state", "pull")
+	if err != nil {
+		return outputs, err
+	}
+	if hasOutputs, err := stateHasOutputs(stateBytes); err != nil {
+		return outputs, util.WrapError(util.ExecutionError, err, "unable to read terraform state for %s", component)
+	} else if !hasOutputs {
+		return outputs, nil
+	}
+	outputBytes, err := executeInComponent(component, true, "output", "-json")
+	if err != nil {
+		return outputs, err
+	}
+	err = json.Unmarshal(outputBytes, &outputs)
+	return outputs, util.WrapError(util.ExecutionError, err, "unable to read terraform outputs for %s", component)
+}
+
+func stateHasOutputs(stateBytes []byte) (bool, error) {
+	if len(bytes.TrimSpace(stateBytes)) == 0 {
+		return false, nil
+	}
+	// terraform 0.11 state keeps outputs per module
+	var state struct {
+		Outputs map[string]json.RawMessage `json:"outputs"`
+		Modules []struct {
+			Outputs map[string]json.RawMessage `json:"outputs"`
+		} `json:"modules"`
+	}
+	if err := json.Unmarshal(stateBytes, &state); err != nil {
+		return false, err
+	}
+	for _, module := range state.Modules {
+		if len(module.Outputs) > 0 {
+			return true, nil
+		}
+	}
+	return len(state.Outputs) > 0, nil
+}
+
+// FetchTerraformOutputs merges the outputs of every component, their names don't overlap.
+func FetchTerraformOutputs() (Outputs, error) {
+	terraformOutputs := Outputs{
+		values:     make(map[string]json.RawMessage),
+		components: make(map[string]string),
+	}
+	for _, component := range Components {
+		if _, err := os.Stat(ComponentDirectory(component.Name)); os.IsNotExist(err) {
+			continue
+		}
+		outputs, err := componentOutputs(component.Name)
+		if err != nil {
+			return terraformOutputs, err
+		}
+		for name, output := range outputs {
+			terraformOutputs.values[name] = output
+			terraformOutputs.components[name] = component.Name
+		}
+	}
+
+	outputBytes, err := json.Marshal(terraformOutputs.values)
+	if err == nil {
+		err = json.Unmarshal(outputBytes, &terraformOutputs)
+	}
+	return terraformOutputs, util.WrapError(util.ExecutionError, err, "unable to read terraform outputs")
 }
@@ -0,0 +1,208 @@
+package terraform
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func outputsOf(values map[string]string) Outputs {
+	outputs := Outputs{
+		values:     make(map[string]json.RawMessage),
+		components: make(map[string]string),
+	}
+	for name, value := range values {
+		outputs.values[name] = json.RawMessage(value)
+		outputs.components[name] = "test"
+	}
+	return outputs
+}
+
+func TestDatabaseConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  map[string]string
+		want    []DatabaseOutput
+		wantErr string
+	}{
+		{
+			name: "legacy output written as a string before engines could be chosen",
+			values: map[string]string{
+				"database_output_orders": `{"value": "{\"name\":\"orders\",\"endpoint\":\"orders.rds:3306\",\"password\":\"secret\"}"}`,
+			},
+			want: []DatabaseOutput{{Name: "orders", Engine: "mysql", Port: "3306", Endpoint: "orders.rds:3306", LegacyPassword: "secret"}},
+		},
+		{
+			name: "legacy output with an engine and port",
+			values: map[string]string{
+				"database_output_orders": `{"value": "{\"name\":\"orders\",\"engine\":\"postgres\",\"port\":\"5432\",\"endpoint\":\"orders.rds:5432\",\"password\":\"secret\"}"}`,
+			},
+			want: []DatabaseOutput{{Name: "orders", Engine: "postgres", Port: "5432", Endpoint: "orders.rds:5432", LegacyPassword: "secret"}},
+		},
+		{
+			name: "current output",
+			values: map[string]string{
+				"database_output_orders": `{"value": {"name": "orders", "engine": "postgres", "port": "5432", "endpoint": "orders.rds:5432", "password_secret": "dev/database/orders/password"}}`,
+				"database_output_users":  `{"value": {"name": "users", "engine": "mysql", "port": "3306", "endpoint": "users.rds:3306", "password_secret": "dev/database/users/password"}}`,
+				"vpc_id":                 `{"value": "vpc-1"}`,
+			},
+			want: []DatabaseOutput{
+				{Name: "orders", Engine: "postgres", Port: "5432", Endpoint: "orders.rds:5432", PasswordSecret: "dev/database/orders/password"},
+				{Name: "users", Engine: "mysql", Port: "3306", Endpoint: "users.rds:3306", PasswordSecret: "dev/database/users/password"},
+			},
+		},
+		{
+			name: "current output missing its engine and port",
+			values: map[string]string{
+				"database_output_orders": `{"value": {"name": "orders", "endpoint": "orders.rds:5432", "password_secret": "dev/database/orders/password"}}`,
+			},
+			wantErr: "terraform output database_output_orders of component test is malformed: missing engine, port",
+		},
+		{
+			name: "output with neither a password nor a secret",
+			values: map[string]string{
+				"database_output_orders": `{"value": {"name": "orders", "engine": "mysql", "port": "3306", "endpoint": "orders.rds:3306"}}`,
+			},
+			wantErr: "missing password_secret",
+		},
+		{
+			name: "output that isn't an object",
+			values: map[string]string{
+				"database_output_orders": `{"value": ["orders"]}`,
+			},
+			wantErr: "terraform output database_output_orders of component test is malformed",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := outputsOf(test.values).DatabaseConfig()
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expected %+v, got %+v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestElasticSearchConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  map[string]string
+		want    []ElasticSearchOutput
+		wantErr string
+	}{
+		{
+			name: "legacy output written as a string",
+			values: map[string]string{
+				"elasticsearch_output_logs": `{"value": "{\"name\":\"logs\",\"endpoint\":\"logs.es\",\"arn\":\"arn:aws:es:logs\"}"}`,
+			},
+			want: []ElasticSearchOutput{{Name: "logs", Endpoint: "logs.es", Arn: "arn:aws:es:logs"}},
+		},
+		{
+			name: "current output",
+			values: map[string]string{
+				"elasticsearch_output_logs": `{"value": {"name": "logs", "endpoint": "logs.es", "arn": "arn:aws:es:logs"}}`,
+			},
+			want: []ElasticSearchOutput{{Name: "logs", Endpoint: "logs.es", Arn: "arn:aws:es:logs"}},
+		},
+		{
+			name: "output missing its arn",
+			values: map[string]string{
+				"elasticsearch_output_logs": `{"value": {"name": "logs", "endpoint": "logs.es"}}`,
+			},
+			wantErr: "terraform output elasticsearch_output_logs of component test is malformed: missing arn",
+		},
+		{
+			name: "output that isn't JSON",
+			values: map[string]string{
+				"elasticsearch_output_logs": `{"value": "logs.es"}`,
+			},
+			wantErr: "terraform output elasticsearch_output_logs of component test is malformed",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := outputsOf(test.values).ElasticSearchConfig()
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expected %+v, got %+v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestRequireFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  map[string]string
+		wantErr string
+	}{
+		{name: "all set", fields: map[string]string{"name": "orders", "endpoint": "orders.rds"}},
+		{name: "one missing", fields: map[string]string{"name": "orders", "endpoint": ""}, wantErr: "missing endpoint"},
+		{name: "several missing are sorted", fields: map[string]string{"port": "", "name": "orders", "engine": ""}, wantErr: "missing engine, port"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := requireFields(test.fields)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != test.wantErr {
+				t.Fatalf("expected error %q, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestTerraformChecksumsArePinned(t *testing.T) {
+	if missing := terraformTool.MissingChecksums(); len(missing) > 0 {
+		t.Errorf("terraform %s has no SHA256 pinned for %v, copy them from the published SHA256SUMS", terraformTool.Version, missing)
+	}
+}
+
+func TestStateHasOutputs(t *testing.T) {
+	tests := []struct {
+		name    string
+		state   string
+		want    bool
+		wantErr bool
+	}{
+		{name: "no state", state: "", want: false},
+		{name: "no outputs", state: `{"version": 4, "outputs": {}}`, want: false},
+		{name: "outputs", state: `{"version": 4, "outputs": {"vpc_id": {"value": "vpc-0a1b2c3d", "type": "string"}}}`, want: true},
+		{name: "terraform 0.11 outputs", state: `{"version": 3, "modules": [{"path": ["root"], "outputs": {"vpc_id": {"value": "vpc-0a1b2c3d"}}}]}`, want: true},
+		{name: "terraform 0.11 no outputs", state: `{"version": 3, "modules": [{"path": ["root"], "outputs": {}}]}`, want: false},
+		{name: "unreadable", state: "Error: state locked", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := stateHasOutputs([]byte(test.state))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+			if got != test.want {
+				t.Errorf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+)
+
+type ErrorKind int
+
+const (
+	UnknownError ErrorKind = iota
+	ConfigError
+	CloudError
+	ExecutionError
+	TimeoutError
+)
+
+var errorKindNames = map[ErrorKind]string{
+	UnknownError:   "unknown",
+	ConfigError:    "config",
+	CloudError:     "cloud",
+	ExecutionError: "execution",
+	TimeoutError:   "timeout",
+}
+
+func (kind ErrorKind) String() string {
+	return errorKindNames[kind]
+}
+
+// Error records what kind of failure happened so callers can tell a bad fk-infra.yml from an AWS outage
+// without picking apart the message.
+type Error struct {
+	Kind    ErrorKind
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func NewError(kind ErrorKind, format string, args ...interface{}) error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+// WrapError returns nil when err is nil so it can wrap the result of a call directly.
+func WrapError(kind ErrorKind, err error, format string, args ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, args...), Err: err}
+}
+
+func KindOf(err error) ErrorKind {
+	var kindedError *Error
+	if errors.As(err, &kindedError) {
+		return kindedError.Kind
+	}
+	return UnknownError
+}
+
+func IsKind(err error, kind ErrorKind) bool {
+	return KindOf(err) == kind
+}
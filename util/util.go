@@ -1,30 +1,30 @@
 package util
 
 import (
+	"crypto/rand"
 	"github.com/spf13/afero"
 	"io/ioutil"
-	"log"
-	"math/rand"
+	"math/big"
 )
 
 const alphaNumericChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 
-func CheckError(err error) {
-	if err != nil {
-		log.Panic(err)
-	}
-}
-
-func RandomAlphaNumeric(length int) string {
+// RandomAlphaNumeric is suitable for passwords, each character is drawn uniformly from a cryptographic source.
+func RandomAlphaNumeric(length int) (string, error) {
 	b := make([]byte, length)
+	charCount := big.NewInt(int64(len(alphaNumericChars)))
 	for i := range b {
-		b[i] = alphaNumericChars[rand.Intn(len(alphaNumericChars))]
+		index, err := rand.Int(rand.Reader, charCount)
+		if err != nil {
+			return "", WrapError(UnknownError, err, "unable to generate random characters")
+		}
+		b[i] = alphaNumericChars[index.Int64()]
 	}
-	return string(b)
+	return string(b), nil
 }
 
-func WriteFile(fileName string, content []byte) {
-	CheckError(ioutil.WriteFile(fileName, content, 0666))
+func WriteFile(fileName string, content []byte) error {
+	return WrapError(ConfigError, ioutil.WriteFile(fileName, content, 0666), "unable to write %s", fileName)
 }
 
 func String(str string) *string {
@@ -35,7 +35,15 @@ func Int32(num int32) *int32 {
 	return &num
 }
 
+func Int64(num int64) *int64 {
+	return &num
+}
+
+func Bool(value bool) *bool {
+	return &value
+}
+
 func PathExists(path string) bool {
 	directoryExists, err := afero.Exists(afero.NewOsFs(), path)
 	return err == nil && directoryExists
-}
\ No newline at end of file
+}